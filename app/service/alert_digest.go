@@ -0,0 +1,181 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	DeliveryImmediate = "immediate"
+	DeliveryBatch     = "batch"
+	DeliveryDaily     = "daily"
+
+	// The rule of the digests in the alert history
+	DeliveryDigestRule = "digest"
+
+	digestTextLength = 80
+)
+
+// DeliveryOf returns the delivery of the rule to the subscription
+func DeliveryOf(subscription config.Subscription, rule string) config.Delivery {
+	if delivery, hit := subscription.RuleDeliveries[rule]; hit {
+		return delivery
+	}
+	return subscription.Delivery
+}
+
+func IsImmediate(delivery config.Delivery) bool {
+	return delivery.Mode == "" || delivery.Mode == DeliveryImmediate
+}
+
+// ParseDigestTime parses 08:00 into the minutes of the day
+func ParseDigestTime(value string) (minutes int, err error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid digest time %s, expect 08:00", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateDelivery(delivery config.Delivery) error {
+	switch delivery.Mode {
+	case "", DeliveryImmediate:
+	case DeliveryBatch:
+		if delivery.BatchMinutes <= 0 {
+			return fmt.Errorf("batch minutes must be positive")
+		}
+	case DeliveryDaily:
+		if _, err := ParseDigestTime(delivery.DigestTime); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown delivery mode %s, expect immediate, batch or daily", delivery.Mode)
+	}
+	return nil
+}
+
+type digestItem struct {
+	rule string
+	msg  *Msg
+}
+
+type pendingDigest struct {
+	subscription config.Subscription
+	delivery     config.Delivery
+	// When the first item is added
+	since time.Time
+	items []digestItem
+}
+
+// due tells if the digest should be sent at now
+func (d *pendingDigest) due(now time.Time) bool {
+	switch d.delivery.Mode {
+	case DeliveryBatch:
+		return !now.Before(d.since.Add(time.Duration(d.delivery.BatchMinutes) * time.Minute))
+	case DeliveryDaily:
+		loc, err := SubscriptionLocation(d.subscription)
+		if err != nil {
+			return true
+		}
+		minutes, err := ParseDigestTime(d.delivery.DigestTime)
+		if err != nil {
+			return true
+		}
+		since := d.since.In(loc)
+		next := time.Date(since.Year(), since.Month(), since.Day(), minutes/60, minutes%60, 0, 0, loc)
+		if !next.After(since) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return !now.Before(next)
+	}
+	return true
+}
+
+// AlertDigests keeps the matched msgs of the batch and the daily deliveries until they are due
+type AlertDigests struct {
+	lock sync.Mutex
+	// User and delivery -> digest
+	pending map[string]*pendingDigest
+}
+
+func NewAlertDigests() *AlertDigests {
+	return &AlertDigests{
+		pending: make(map[string]*pendingDigest),
+	}
+}
+
+func (d *AlertDigests) Add(subscription config.Subscription, delivery config.Delivery, rule string, msg *Msg, now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	key := fmt.Sprintf("%s %+v", subscription.User, delivery)
+	digest, hit := d.pending[key]
+	if !hit {
+		digest = &pendingDigest{delivery: delivery, since: now}
+		d.pending[key] = digest
+	}
+	// The latest subscription wins
+	digest.subscription = subscription
+	digest.items = append(digest.items, digestItem{rule: rule, msg: msg})
+}
+
+func (d *AlertDigests) Len() (n int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, digest := range d.pending {
+		n += len(digest.items)
+	}
+	return
+}
+
+// Due removes and returns the digests due at now
+func (d *AlertDigests) Due(now time.Time) (due []*pendingDigest) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for key, digest := range d.pending {
+		if digest.due(now) {
+			due = append(due, digest)
+			delete(d.pending, key)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].subscription.User < due[j].subscription.User
+	})
+	return
+}
+
+// FormatDigest formats the matched msgs into a single alert
+func FormatDigest(items []digestItem, language string) (title, content string) {
+	var (
+		counts = make(map[string]int)
+		rules  []string
+		lines  []string
+	)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].msg.CreatedAt.Before(items[j].msg.CreatedAt)
+	})
+	for _, item := range items {
+		if counts[item.rule] == 0 {
+			rules = append(rules, item.rule)
+		}
+		counts[item.rule]++
+		lines = append(lines, fmt.Sprintf("%s [%s] %s", item.msg.LocalTime(), item.rule, truncate(digestTextLength, stripHTML(item.msg.Text))))
+	}
+
+	var summary []string
+	for _, rule := range rules {
+		summary = append(summary, fmt.Sprintf("%s %d", rule, counts[rule]))
+	}
+	if language == "zh" {
+		title = fmt.Sprintf("汇总: %d条提醒 (%s)", len(items), strings.Join(summary, ", "))
+	} else {
+		title = fmt.Sprintf("Digest: %d alerts (%s)", len(items), strings.Join(summary, ", "))
+	}
+	return title, strings.Join(lines, "\n")
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestAlertDigests(t *testing.T) {
+	var (
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		alice         = &fakeNotifier{name: "alice"}
+		bob           = &fakeNotifier{name: "bob"}
+		router        = NewAlertRouter(subscriptions).Notifier(alice).Notifier(bob)
+		rate          = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+		urgent        = NewKeywordMsgFilter(config.Rule{Name: "urgent", Keywords: []string{"停牌"}})
+		// 10:00 in Shanghai
+		now = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+	)
+	router.now = func() time.Time { return now }
+	subscriptions.Load([]config.Subscription{
+		{
+			User:           "alice",
+			Notifiers:      []string{"alice"},
+			Delivery:       config.Delivery{Mode: DeliveryBatch, BatchMinutes: 15},
+			RuleDeliveries: map[string]config.Delivery{"urgent": {Mode: DeliveryImmediate}},
+		},
+		{
+			User:      "bob",
+			Notifiers: []string{"bob"},
+			Language:  "zh",
+			Delivery:  config.Delivery{Mode: DeliveryDaily, DigestTime: "08:00"},
+		},
+	})
+
+	ApplyFilter([]MsgFilter{rate, urgent}, []*Msg{
+		{Source: FutuSourceName, ID: "1", CreatedAt: now, Text: "普拉格 目标价 40"},
+		{Source: FutuSourceName, ID: "2", CreatedAt: now, Text: "特斯拉 目标价 800"},
+		{Source: FutuSourceName, ID: "3", CreatedAt: now, Text: "某公司 停牌"},
+	}, router.Route)
+
+	// Only the immediate one of alice
+	if len(alice.titles) != 1 || len(bob.titles) != 0 {
+		t.Fatalf("alice %v, bob %v", alice.titles, bob.titles)
+	}
+
+	router.FlushDigests(now.Add(10 * time.Minute))
+	if len(alice.titles) != 1 {
+		t.Fatalf("the batch is not due: %v", alice.titles)
+	}
+
+	router.FlushDigests(now.Add(15 * time.Minute))
+	if len(alice.titles) != 2 || alice.titles[1] != "Digest: 2 alerts (rate 2)" || strings.Count(alice.contents[1], "\n") != 1 {
+		t.Fatalf("alice batch %q %q", alice.titles, alice.contents)
+	}
+
+	// 07:59 and 08:00 of the next day in Shanghai
+	router.FlushDigests(time.Date(2020, 12, 1, 23, 59, 0, 0, time.UTC))
+	if len(bob.titles) != 0 {
+		t.Fatalf("the daily digest is not due: %v", bob.titles)
+	}
+	router.FlushDigests(time.Date(2020, 12, 2, 0, 0, 0, 0, time.UTC))
+	if len(bob.titles) != 1 || bob.titles[0] != "汇总: 3条提醒 (rate 2, urgent 1)" {
+		t.Fatalf("bob daily %q", bob.titles)
+	}
+	if router.digests.Len() != 0 {
+		t.Errorf("%d msgs are left", router.digests.Len())
+	}
+}
+
+func TestValidateDelivery(t *testing.T) {
+	for _, invalid := range []config.Delivery{
+		{Mode: "hourly"},
+		{Mode: DeliveryBatch},
+		{Mode: DeliveryDaily, DigestTime: "8am"},
+	} {
+		if err := validateDelivery(invalid); err == nil {
+			t.Errorf("expect the error of %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	PriorityInfo     = "info"
+	PriorityWarning  = "warning"
+	PriorityCritical = "critical"
+
+	AlertEventSent      = "sent"
+	AlertEventFailed    = "failed"
+	AlertEventEscalated = "escalated"
+	AlertEventAcked     = "acked"
+)
+
+var (
+	Priorities = []string{PriorityInfo, PriorityWarning, PriorityCritical}
+
+	TheAlertHistory = NewAlertHistory(config.Config.Alerting.HistorySize)
+)
+
+// PriorityFilter is the filter which has its own priority
+type PriorityFilter interface {
+	Priority() string
+}
+
+// PriorityOf returns the priority of the rule, info if not given
+func PriorityOf(filter MsgFilter) string {
+	if f, ok := filter.(PriorityFilter); ok && f.Priority() != "" {
+		return f.Priority()
+	}
+	if priority, hit := config.Config.Alerting.Priorities[filter.Name()]; hit {
+		return priority
+	}
+	return PriorityInfo
+}
+
+// NotifiersOf returns the notifiers of the subscription for the priority
+func NotifiersOf(subscription config.Subscription, priority string) []string {
+	if names := subscription.PriorityNotifiers[priority]; len(names) > 0 {
+		return names
+	}
+	if len(subscription.Notifiers) > 0 {
+		return subscription.Notifiers
+	}
+	return []string{DefaultNotifierName}
+}
+
+type AlertEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Notifier string    `json:"notifier,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// AlertRecord is an alert sent to a user, with the timeline of the deliveries
+type AlertRecord struct {
+	ID       int64  `json:"id"`
+	Rule     string `json:"rule"`
+	Priority string `json:"priority"`
+	User     string `json:"user"`
+	Source   string `json:"source,omitempty"`
+	MsgID    string `json:"msg_id,omitempty"`
+	// The near-duplicate msgs of the other sources are not alerted but listed here
+	Cluster   int64        `json:"cluster,omitempty"`
+	Sources   []string     `json:"sources,omitempty"`
+	Title     string       `json:"title"`
+	Content   string       `json:"content"`
+	CreatedAt time.Time    `json:"created_at"`
+	AckedAt   *time.Time   `json:"acked_at,omitempty"`
+	Escalated bool         `json:"escalated"`
+	Timeline  []AlertEvent `json:"timeline"`
+}
+
+// AlertHistory keeps the latest alerts
+type AlertHistory struct {
+	lock     sync.RWMutex
+	maxCount int
+	// From the oldest
+	records []*AlertRecord
+	byID    map[int64]*AlertRecord
+	lastID  int64
+	// Called with the copies of the records added or changed
+	observers []func(AlertRecord)
+}
+
+func NewAlertHistory(maxCount int) *AlertHistory {
+	return &AlertHistory{
+		maxCount: maxCount,
+		byID:     make(map[int64]*AlertRecord),
+	}
+}
+
+// Observe calls fn with the records added or changed, e.g. to index them, fn must not block
+func (h *AlertHistory) Observe(fn func(AlertRecord)) *AlertHistory {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.observers = append(h.observers, fn)
+	return h
+}
+
+// changed calls the observers with the lock held, so that the changes are observed in order
+func (h *AlertHistory) changed(record *AlertRecord) {
+	if len(h.observers) == 0 {
+		return
+	}
+	c := record.copy()
+	for _, fn := range h.observers {
+		fn(c)
+	}
+}
+
+// Add assigns the ID of the record and keeps it
+func (h *AlertHistory) Add(record *AlertRecord) int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.lastID++
+	record.ID = h.lastID
+	h.records = append(h.records, record)
+	h.byID[record.ID] = record
+	for h.maxCount > 0 && len(h.records) > h.maxCount {
+		delete(h.byID, h.records[0].ID)
+		h.records[0] = nil
+		h.records = h.records[1:]
+	}
+	h.changed(record)
+	return record.ID
+}
+
+// Event adds the event to the timeline of the alert
+func (h *AlertHistory) Event(id int64, event AlertEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if record, hit := h.byID[id]; hit {
+		record.Timeline = append(record.Timeline, event)
+		h.changed(record)
+	}
+}
+
+// AddSource adds the source to the alerts of the cluster by the rule to the user
+func (h *AlertHistory) AddSource(cluster int64, rule, user, source string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, record := range h.records {
+		if record.Cluster == cluster && record.Rule == rule && record.User == user && !slices.Contains(record.Sources, source) {
+			record.Sources = append(record.Sources, source)
+			h.changed(record)
+		}
+	}
+}
+
+func (h *AlertHistory) Ack(id int64, now time.Time) (AlertRecord, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	record, hit := h.byID[id]
+	if !hit {
+		return AlertRecord{}, fmt.Errorf("unknown alert %d", id)
+	}
+	if record.AckedAt == nil {
+		record.AckedAt = &now
+		record.Timeline = append(record.Timeline, AlertEvent{Time: now, Type: AlertEventAcked})
+		h.changed(record)
+	}
+	return record.copy(), nil
+}
+
+func (h *AlertHistory) Get(id int64) (AlertRecord, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if record, hit := h.byID[id]; hit {
+		return record.copy(), true
+	}
+	return AlertRecord{}, false
+}
+
+// List returns the latest alerts from the newest
+func (h *AlertHistory) List(limit int) (records []AlertRecord) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for idx := len(h.records) - 1; idx >= 0 && (limit <= 0 || len(records) < limit); idx-- {
+		records = append(records, h.records[idx].copy())
+	}
+	return
+}
+
+// Escalate marks and returns the critical alerts not acknowledged in time
+func (h *AlertHistory) Escalate(now time.Time, after time.Duration) (records []AlertRecord) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, record := range h.records {
+		if record.Priority != PriorityCritical || record.AckedAt != nil || record.Escalated || now.Sub(record.CreatedAt) < after {
+			continue
+		}
+		record.Escalated = true
+		records = append(records, record.copy())
+		h.changed(record)
+	}
+	return
+}
+
+func (r *AlertRecord) copy() AlertRecord {
+	c := *r
+	c.Sources = append([]string(nil), r.Sources...)
+	c.Timeline = append([]AlertEvent{}, r.Timeline...)
+	return c
+}
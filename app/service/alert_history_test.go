@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestAlertEscalation(t *testing.T) {
+	var (
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		history       = NewAlertHistory(10)
+		app           = &fakeNotifier{name: "app"}
+		phone         = &fakeNotifier{name: "phone"}
+		sms           = &fakeNotifier{name: "sms"}
+		router        = NewAlertRouter(subscriptions).Notifier(app).Notifier(phone).Notifier(sms).
+				History(history).EscalateAfter(10 * time.Minute)
+		halt = NewKeywordMsgFilter(config.Rule{Name: "halt", Keywords: []string{"停牌"}, Priority: PriorityCritical})
+		rate = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+		now  = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+	)
+	router.now = func() time.Time { return now }
+	subscriptions.Load([]config.Subscription{{
+		User:                "alice",
+		Notifiers:           []string{"app"},
+		PriorityNotifiers:   map[string][]string{PriorityCritical: {"app", "phone"}},
+		EscalationNotifiers: []string{"sms"},
+		// The critical ones are not batched
+		Delivery: config.Delivery{Mode: DeliveryBatch, BatchMinutes: 60},
+	}})
+
+	ApplyFilter([]MsgFilter{halt, rate}, []*Msg{
+		{Source: FutuSourceName, ID: "1", CreatedAt: now, Text: "甲公司 停牌"},
+		{Source: FutuSourceName, ID: "2", CreatedAt: now, Text: "乙公司 停牌"},
+		{Source: FutuSourceName, ID: "3", CreatedAt: now, Text: "目标价 上调"},
+	}, router.Route)
+	if len(app.titles) != 2 || len(phone.titles) != 2 || router.digests.Len() != 1 {
+		t.Fatalf("app %v, phone %v, %d digested", app.titles, phone.titles, router.digests.Len())
+	}
+
+	records := history.List(0)
+	if len(records) != 2 || records[0].Priority != PriorityCritical || len(records[0].Timeline) != 2 {
+		t.Fatalf("history %+v", records)
+	}
+	if _, err := history.Ack(records[0].ID, now.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	router.Escalate(now.Add(9 * time.Minute))
+	if len(sms.titles) != 0 {
+		t.Fatalf("escalated too early: %v", sms.titles)
+	}
+	router.Escalate(now.Add(10 * time.Minute))
+	router.Escalate(now.Add(20 * time.Minute))
+	// Only the one not acked, once
+	if len(sms.titles) != 1 || !strings.HasPrefix(sms.titles[0], "[ESCALATED] halt") {
+		t.Fatalf("sms %v", sms.titles)
+	}
+
+	escalated, _ := history.Get(records[1].ID)
+	var events []string
+	for _, event := range escalated.Timeline {
+		events = append(events, event.Type+" "+event.Notifier)
+	}
+	if got := strings.Join(events, ","); got != "sent app,sent phone,escalated sms" {
+		t.Errorf("timeline %s", got)
+	}
+	if acked, _ := history.Get(records[0].ID); acked.AckedAt == nil || acked.Escalated {
+		t.Errorf("acked %+v", acked)
+	}
+}
+
+func TestAlertEmptyPriorityNotifiers(t *testing.T) {
+	var (
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		app           = &fakeNotifier{name: "app"}
+		router        = NewAlertRouter(subscriptions).Notifier(app)
+		rate          = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+	)
+	// Saved before the empty lists were rejected
+	subscriptions.Load([]config.Subscription{{
+		User:              "alice",
+		Notifiers:         []string{"app"},
+		PriorityNotifiers: map[string][]string{PriorityInfo: {}},
+	}})
+
+	router.Route(rate, &Msg{Source: FutuSourceName, ID: "1", CreatedAt: time.Now(), Text: "目标价 上调"})
+	if len(app.titles) != 1 {
+		t.Errorf("app %v", app.titles)
+	}
+}
+
+func TestAlertHistorySize(t *testing.T) {
+	history := NewAlertHistory(2)
+	for i := 0; i < 3; i++ {
+		history.Add(&AlertRecord{Rule: fmt.Sprint(i)})
+	}
+	if records := history.List(0); len(records) != 2 || records[0].Rule != "2" || records[1].Rule != "1" {
+		t.Errorf("records %+v", records)
+	}
+	if _, hit := history.Get(1); hit {
+		t.Error("the oldest one should be dropped")
+	}
+}
+
+func TestAPIAlerts(t *testing.T) {
+	defer func(history *AlertHistory) { TheAlertHistory = history }(TheAlertHistory)
+	TheAlertHistory = NewAlertHistory(10)
+	id := TheAlertHistory.Add(&AlertRecord{Rule: "halt", Priority: PriorityCritical, User: "alice"})
+
+	var (
+		server = NewAPIServer("")
+		ack    = func(path, remoteAddr string) *httptest.ResponseRecorder {
+			recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil)
+			request.RemoteAddr = remoteAddr
+			server.ServeHTTP(recorder, request)
+			return recorder
+		}
+	)
+	// Not from the loopback without tokens
+	if recorder := ack(fmt.Sprintf("/alerts/%d/ack", id), "192.0.2.1:1234"); recorder.Code != http.StatusForbidden {
+		t.Errorf("ack from afar: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := ack(fmt.Sprintf("/alerts/%d/ack", id), "127.0.0.1:1234"); recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"type":"acked"`) {
+		t.Errorf("ack: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := ack("/alerts/100/ack", "127.0.0.1:1234"); recorder.Code != http.StatusNotFound {
+		t.Errorf("ack unknown: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
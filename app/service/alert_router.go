@@ -0,0 +1,308 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	DefaultNotifierName = "neuron"
+	// The user of the cooldowns and the summaries when the filters alert by themselves
+	DefaultAlertUser = "default"
+
+	alertDigestInterval = time.Minute
+)
+
+var (
+	TheAlertRouter = NewAlertRouter(TheSubscriptions).
+		Notifier(utils.NewNeuronNotifier(DefaultNotifierName, utils.TheNeuronClient))
+)
+
+func init() {
+	for _, n := range config.Config.Notifiers {
+		notifier, err := utils.NewNotifier(n)
+		if err != nil {
+			glog.Errorf("failed to create the notifier, ERR: %v", err)
+			continue
+		}
+		TheAlertRouter.Notifier(notifier)
+	}
+}
+
+// AlertRouter fans out the matched msgs to the subscribed users
+type AlertRouter struct {
+	subscriptions *Subscriptions
+	lock          sync.RWMutex
+	notifiers     map[string]utils.Notifier
+	digests       *AlertDigests
+	history       *AlertHistory
+	suppressor    *AlertSuppressor
+	clusters      *MsgClusters
+	escalateAfter time.Duration
+	// Called with every match, whether alerted or not
+	matchObservers []func(filter MsgFilter, msg *Msg, at time.Time)
+	now            func() time.Time
+}
+
+func NewAlertRouter(subscriptions *Subscriptions) *AlertRouter {
+	return &AlertRouter{
+		subscriptions: subscriptions,
+		notifiers:     make(map[string]utils.Notifier),
+		digests:       NewAlertDigests(),
+		history:       TheAlertHistory,
+		suppressor:    NewAlertSuppressor(),
+		clusters:      TheMsgClusters,
+		escalateAfter: time.Duration(config.Config.Alerting.EscalationMinutes) * time.Minute,
+		now:           time.Now,
+	}
+}
+
+// History replaces the history of the alerts
+func (r *AlertRouter) History(history *AlertHistory) *AlertRouter {
+	r.history = history
+	return r
+}
+
+// Suppressor replaces the snoozes and the cooldowns
+func (r *AlertRouter) Suppressor(suppressor *AlertSuppressor) *AlertRouter {
+	r.suppressor = suppressor
+	return r
+}
+
+func (r *AlertRouter) GetSuppressor() *AlertSuppressor {
+	return r.suppressor
+}
+
+// Clusters replaces the clusters of the near-duplicate msgs
+func (r *AlertRouter) Clusters(clusters *MsgClusters) *AlertRouter {
+	r.clusters = clusters
+	return r
+}
+
+// EscalateAfter is the time to wait for the ack of the critical alerts, 0 to disable the escalation
+func (r *AlertRouter) EscalateAfter(d time.Duration) *AlertRouter {
+	r.escalateAfter = d
+	return r
+}
+
+// ObserveMatch calls fn with every msg matched, e.g. to store the matches, before it is routed
+func (r *AlertRouter) ObserveMatch(fn func(filter MsgFilter, msg *Msg, at time.Time)) *AlertRouter {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.matchObservers = append(r.matchObservers, fn)
+	return r
+}
+
+// Notifier adds or replaces the notifier of the name
+func (r *AlertRouter) Notifier(notifier utils.Notifier) *AlertRouter {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.notifiers[notifier.Name()] = notifier
+	return r
+}
+
+func (r *AlertRouter) GetNotifier(name string) (utils.Notifier, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if notifier, hit := r.notifiers[name]; hit {
+		return notifier, nil
+	}
+	return nil, fmt.Errorf("unknown notifier: %s", name)
+}
+
+// Route sends the msg matched by the filter to the subscribed users,
+// the filter alerts by itself if there is no subscription at all.
+// A story is alerted once per rule and user, the near-duplicate msgs of the other sources
+// are only added to the alert of the first one.
+func (r *AlertRouter) Route(filter MsgFilter, msg *Msg) {
+	r.lock.RLock()
+	observers := r.matchObservers
+	r.lock.RUnlock()
+	for _, fn := range observers {
+		fn(filter, msg, r.now())
+	}
+
+	if r.suppressor.Snoozed(filter.Name(), r.now()) {
+		glog.V(4).Infof("SKIP ALERT OF SNOOZED RULE %s: %s/%s", filter.Name(), msg.Source, msg.ID)
+		return
+	}
+
+	// Clustered when collected, adding it again only finds its cluster
+	cluster, _ := r.clusters.Add(msg)
+
+	if r.subscriptions.Len() == 0 {
+		if !r.alertOnce(cluster, filter, DefaultAlertUser, msg) {
+			return
+		}
+		if !r.suppressor.Allow(DefaultAlertUser, filter.Name(), r.now()) {
+			glog.V(4).Infof("SKIP ALERT OF RULE %s IN COOLDOWN: %s/%s", filter.Name(), msg.Source, msg.ID)
+			return
+		}
+		AlertMatched(filter, msg)
+		return
+	}
+
+	priority := PriorityOf(filter)
+	for _, subscription := range r.subscriptions.Match(filter.Name(), msg) {
+		if priority != PriorityCritical && InQuietHours(subscription, r.now()) {
+			glog.V(4).Infof("SKIP ALERT OF RULE %s TO %s IN QUIET HOURS: %s/%s", filter.Name(), subscription.User, msg.Source, msg.ID)
+			continue
+		}
+		if !r.alertOnce(cluster, filter, subscription.User, msg) {
+			continue
+		}
+		// The critical ones are always sent immediately
+		if delivery := DeliveryOf(subscription, filter.Name()); priority != PriorityCritical && !IsImmediate(delivery) {
+			r.digests.Add(subscription, delivery, filter.Name(), msg, r.now())
+			continue
+		}
+		if !r.suppressor.Allow(subscription.User, filter.Name(), r.now()) {
+			glog.V(4).Infof("SKIP ALERT OF RULE %s TO %s IN COOLDOWN: %s/%s", filter.Name(), subscription.User, msg.Source, msg.ID)
+			continue
+		}
+		record := &AlertRecord{
+			Rule:     filter.Name(),
+			Priority: priority,
+			User:     subscription.User,
+			Source:   msg.Source,
+			MsgID:    msg.ID,
+			Cluster:  cluster.ID,
+			Sources:  cluster.Sources,
+		}
+		r.send(record, NotifiersOf(subscription, priority), func(notifier string) (string, string) {
+			return FormatAlert(filter, notifier, subscription.Language, msg)
+		})
+	}
+}
+
+// alertOnce tells if the rule has not alerted the user of the cluster of the msg by another msg,
+// otherwise the source of the msg is added to the alerts of the cluster
+func (r *AlertRouter) alertOnce(cluster MsgCluster, filter MsgFilter, user string, msg *Msg) bool {
+	if r.clusters.Alert(cluster.ID, filter.Name(), user, msg) {
+		return true
+	}
+	glog.V(4).Infof("SKIP ALERT OF RULE %s TO %s OF DUPLICATE MSG %s/%s IN CLUSTER %d", filter.Name(), user, msg.Source, msg.ID, cluster.ID)
+	r.history.AddSource(cluster.ID, filter.Name(), user, msg.Source)
+	return false
+}
+
+// send keeps the alert in the history and sends it by the notifiers
+func (r *AlertRouter) send(record *AlertRecord, notifiers []string, format func(notifier string) (title, content string)) {
+	if len(notifiers) == 0 {
+		glog.Errorf("no notifier to send alert %s to %s", record.Rule, record.User)
+		return
+	}
+	record.CreatedAt = r.now()
+	record.Title, record.Content = format(notifiers[0])
+	id := r.history.Add(record)
+	for _, name := range notifiers {
+		r.deliver(id, record.User, name, AlertEventSent, format)
+	}
+}
+
+// deliver sends the alert by the notifier and records the event in the timeline
+func (r *AlertRouter) deliver(id int64, user, name, eventType string, format func(notifier string) (title, content string)) {
+	notifier, err := r.GetNotifier(name)
+	if err == nil {
+		err = notifier.Notify(format(name))
+	}
+	event := AlertEvent{Time: r.now(), Type: eventType, Notifier: name}
+	if err != nil {
+		glog.Errorf("failed to send alert %d to %s by %s, ERR: %v", id, user, name, err)
+		event.Type, event.Error = AlertEventFailed, err.Error()
+	}
+	r.history.Event(id, event)
+}
+
+// FlushDigests sends the digests due at now
+func (r *AlertRouter) FlushDigests(now time.Time) {
+	for _, digest := range r.digests.Due(now) {
+		title, content := FormatDigest(digest.items, digest.subscription.Language)
+		record := &AlertRecord{
+			Rule:     DeliveryDigestRule,
+			Priority: PriorityInfo,
+			User:     digest.subscription.User,
+		}
+		r.send(record, NotifiersOf(digest.subscription, PriorityInfo), func(notifier string) (string, string) {
+			return title, content
+		})
+	}
+}
+
+// FlushSuppressed tells the users how many alerts are suppressed in the ended cooldown windows
+func (r *AlertRouter) FlushSuppressed(now time.Time) {
+	for _, summary := range r.suppressor.Expired(now) {
+		subscription, hit := r.subscriptions.Get(summary.User)
+		if !hit {
+			if summary.User != DefaultAlertUser {
+				continue
+			}
+			subscription = config.Subscription{User: DefaultAlertUser}
+		}
+		title, content := FormatSuppressed(summary, subscription.Language)
+		record := &AlertRecord{
+			Rule:     SuppressedSummaryRule,
+			Priority: PriorityInfo,
+			User:     summary.User,
+		}
+		r.send(record, NotifiersOf(subscription, PriorityInfo), func(notifier string) (string, string) {
+			return title, content
+		})
+	}
+}
+
+// Escalate sends the critical alerts not acknowledged in time again by the escalation notifiers,
+// or by the same notifiers if there is none.
+func (r *AlertRouter) Escalate(now time.Time) {
+	if r.escalateAfter <= 0 {
+		return
+	}
+	for _, record := range r.history.Escalate(now, r.escalateAfter) {
+		subscription, hit := r.subscriptions.Get(record.User)
+		if !hit {
+			continue
+		}
+		notifiers := subscription.EscalationNotifiers
+		if len(notifiers) == 0 {
+			notifiers = NotifiersOf(subscription, record.Priority)
+		}
+		for _, name := range notifiers {
+			r.deliver(record.ID, record.User, name, AlertEventEscalated, func(string) (string, string) {
+				return "[ESCALATED] " + record.Title, record.Content
+			})
+		}
+	}
+}
+
+// Start sends the due digests and the suppressed summaries, and escalates the alerts every minute
+func (r *AlertRouter) Start() {
+	go func() {
+		ticker := time.NewTicker(alertDigestInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			r.FlushDigests(r.now())
+			r.FlushSuppressed(r.now())
+			r.Escalate(r.now())
+		}
+	}()
+}
+
+// AlertRules are the rules of the collectors and the subscriptions
+func AlertRules() (rules []MsgFilter, err error) {
+	names := append([]string{}, config.Config.Collector.Rules...)
+	for _, name := range TheSubscriptions.Rules() {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	return GetRules(names)
+}
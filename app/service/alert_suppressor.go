@@ -0,0 +1,172 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	// The rule of the suppressed summaries in the alert history
+	SuppressedSummaryRule = "suppressed"
+)
+
+// CooldownOf returns the cooldown of the rule, the one of all the rules if not given
+func CooldownOf(rule string) config.Cooldown {
+	if cooldown, hit := config.Config.Alerting.Cooldowns[rule]; hit {
+		return cooldown
+	}
+	return config.Config.Alerting.Cooldown
+}
+
+type cooldownWindow struct {
+	user, rule string
+	minutes    int
+	end        time.Time
+	count      int
+	suppressed int
+}
+
+// SuppressedSummary is the number of the alerts suppressed in a cooldown window
+type SuppressedSummary struct {
+	User          string
+	Rule          string
+	Suppressed    int
+	WindowMinutes int
+}
+
+// AlertSuppressor keeps the snoozed rules and the cooldown windows of the users
+type AlertSuppressor struct {
+	lock sync.Mutex
+	// Rule -> until
+	snoozes map[string]time.Time
+	// User and rule -> window
+	windows    map[string]*cooldownWindow
+	cooldownOf func(rule string) config.Cooldown
+}
+
+func NewAlertSuppressor() *AlertSuppressor {
+	return &AlertSuppressor{
+		snoozes:    make(map[string]time.Time),
+		windows:    make(map[string]*cooldownWindow),
+		cooldownOf: CooldownOf,
+	}
+}
+
+// Cooldowns replaces the cooldowns of the rules
+func (s *AlertSuppressor) Cooldowns(cooldownOf func(rule string) config.Cooldown) *AlertSuppressor {
+	s.cooldownOf = cooldownOf
+	return s
+}
+
+// Snooze silences all the alerts of the rule until the time
+func (s *AlertSuppressor) Snooze(rule string, until time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.snoozes[rule] = until
+}
+
+func (s *AlertSuppressor) Unsnooze(rule string) (hit bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, hit = s.snoozes[rule]
+	delete(s.snoozes, rule)
+	return
+}
+
+func (s *AlertSuppressor) Snoozed(rule string, now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	until, hit := s.snoozes[rule]
+	if hit && !now.Before(until) {
+		delete(s.snoozes, rule)
+		return false
+	}
+	return hit
+}
+
+// Snoozes returns the snoozed rules and until when
+func (s *AlertSuppressor) Snoozes(now time.Time) map[string]time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snoozes := make(map[string]time.Time)
+	for rule, until := range s.snoozes {
+		if now.Before(until) {
+			snoozes[rule] = until
+		}
+	}
+	return snoozes
+}
+
+// Allow counts the alert of the rule to the user, false if it exceeds the cooldown
+func (s *AlertSuppressor) Allow(user, rule string, now time.Time) bool {
+	cooldown := s.cooldownOf(rule)
+	if cooldown.MaxAlerts <= 0 || cooldown.WindowMinutes <= 0 {
+		return true
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := user + "/" + rule
+	window, hit := s.windows[key]
+	if !hit || !now.Before(window.end) {
+		if hit && window.suppressed > 0 {
+			// Ended but not summarized yet
+			s.windows[fmt.Sprintf("%s/%d", key, window.end.UnixNano())] = window
+		}
+		window = &cooldownWindow{
+			user:    user,
+			rule:    rule,
+			minutes: cooldown.WindowMinutes,
+			end:     now.Add(time.Duration(cooldown.WindowMinutes) * time.Minute),
+		}
+		s.windows[key] = window
+	}
+	if window.count >= cooldown.MaxAlerts {
+		window.suppressed++
+		return false
+	}
+	window.count++
+	return true
+}
+
+// Expired removes the windows ended at now and returns the ones with suppressed alerts
+func (s *AlertSuppressor) Expired(now time.Time) (summaries []SuppressedSummary) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, window := range s.windows {
+		if now.Before(window.end) {
+			continue
+		}
+		delete(s.windows, key)
+		if window.suppressed > 0 {
+			summaries = append(summaries, SuppressedSummary{
+				User:          window.user,
+				Rule:          window.rule,
+				Suppressed:    window.suppressed,
+				WindowMinutes: window.minutes,
+			})
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].User != summaries[j].User {
+			return summaries[i].User < summaries[j].User
+		}
+		return summaries[i].Rule < summaries[j].Rule
+	})
+	return
+}
+
+// FormatSuppressed formats the summary of the suppressed alerts
+func FormatSuppressed(summary SuppressedSummary, language string) (title, content string) {
+	if language == "zh" {
+		return fmt.Sprintf("%s: 另有%d条提醒被抑制", summary.Rule, summary.Suppressed),
+			fmt.Sprintf("规则 %s 在%d分钟内超过提醒上限", summary.Rule, summary.WindowMinutes)
+	}
+	return fmt.Sprintf("%s: %d more alerts suppressed", summary.Rule, summary.Suppressed),
+		fmt.Sprintf("The rule %s exceeded its cap in %d minutes", summary.Rule, summary.WindowMinutes)
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestAlertCooldown(t *testing.T) {
+	var (
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		alice         = &fakeNotifier{name: "alice"}
+		suppressor    = NewAlertSuppressor().Cooldowns(func(rule string) config.Cooldown {
+			if rule == "rate" {
+				return config.Cooldown{MaxAlerts: 2, WindowMinutes: 10}
+			}
+			return config.Cooldown{}
+		})
+		router = NewAlertRouter(subscriptions).Notifier(alice).History(NewAlertHistory(10)).Suppressor(suppressor)
+		rate   = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+		halt   = NewKeywordMsgFilter(config.Rule{Name: "halt", Keywords: []string{"停牌"}})
+		now    = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+	)
+	router.now = func() time.Time { return now }
+	subscriptions.Load([]config.Subscription{{User: "alice", Notifiers: []string{"alice"}}})
+
+	var msgs []*Msg
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs,
+			&Msg{Source: FutuSourceName, ID: fmt.Sprintf("r%d", i), CreatedAt: now, Text: "目标价 上调"},
+			&Msg{Source: FutuSourceName, ID: fmt.Sprintf("h%d", i), CreatedAt: now, Text: "停牌"},
+		)
+	}
+	ApplyFilter([]MsgFilter{rate, halt}, msgs, router.Route)
+	// 2 of rate and all of halt
+	if len(alice.titles) != 7 {
+		t.Fatalf("alice %v", alice.titles)
+	}
+
+	router.FlushSuppressed(now.Add(9 * time.Minute))
+	if len(alice.titles) != 7 {
+		t.Fatalf("the window is not ended: %v", alice.titles)
+	}
+	router.FlushSuppressed(now.Add(10 * time.Minute))
+	if len(alice.titles) != 8 || alice.titles[7] != "rate: 3 more alerts suppressed" {
+		t.Fatalf("alice %v", alice.titles)
+	}
+
+	// A new window
+	now = now.Add(10 * time.Minute)
+	ApplyFilter([]MsgFilter{rate}, msgs[:2], router.Route)
+	if len(alice.titles) != 9 {
+		t.Errorf("alice %v", alice.titles)
+	}
+}
+
+// alertingFilter records the alerts instead of sending them
+type alertingFilter struct {
+	MsgFilter
+	alerted []string
+}
+
+func (f *alertingFilter) Alert(msg *Msg) error {
+	f.alerted = append(f.alerted, msg.ID)
+	return nil
+}
+
+func TestAlertCooldownWithoutSubscriptions(t *testing.T) {
+	var (
+		neuron     = &fakeNotifier{name: DefaultNotifierName}
+		suppressor = NewAlertSuppressor().Cooldowns(func(string) config.Cooldown {
+			return config.Cooldown{MaxAlerts: 2, WindowMinutes: 10}
+		})
+		router = NewAlertRouter(NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))).
+			Notifier(neuron).History(NewAlertHistory(10)).Suppressor(suppressor)
+		rate = &alertingFilter{MsgFilter: NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})}
+		now  = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+		msgs []*Msg
+	)
+	router.now = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, &Msg{Source: FutuSourceName, ID: fmt.Sprint(i), CreatedAt: now, Text: fmt.Sprintf("%d 目标价 上调 %d", i, i*i)})
+	}
+
+	router.GetSuppressor().Snooze("rate", now.Add(time.Minute))
+	ApplyFilter([]MsgFilter{rate}, msgs[:1], router.Route)
+	if len(rate.alerted) != 0 {
+		t.Fatalf("snoozed: %v", rate.alerted)
+	}
+
+	now = now.Add(time.Minute)
+	ApplyFilter([]MsgFilter{rate}, msgs[1:], router.Route)
+	if len(rate.alerted) != 2 {
+		t.Fatalf("alerted %v", rate.alerted)
+	}
+	router.FlushSuppressed(now.Add(10 * time.Minute))
+	if len(neuron.titles) != 1 || neuron.titles[0] != "rate: 2 more alerts suppressed" {
+		t.Errorf("neuron %v", neuron.titles)
+	}
+}
+
+func TestAlertCooldownSummary(t *testing.T) {
+	var (
+		suppressor = NewAlertSuppressor().Cooldowns(func(string) config.Cooldown {
+			return config.Cooldown{MaxAlerts: 1, WindowMinutes: 5}
+		})
+		now = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+	)
+	suppressor.Allow("alice", "rate", now)
+	suppressor.Allow("alice", "rate", now)
+	// The ended window is kept for the summary when the next one starts
+	suppressor.Allow("alice", "rate", now.Add(6*time.Minute))
+
+	summaries := suppressor.Expired(now.Add(6 * time.Minute))
+	if len(summaries) != 1 || summaries[0].Suppressed != 1 || summaries[0].WindowMinutes != 5 {
+		t.Errorf("summaries %+v", summaries)
+	}
+	if title, _ := FormatSuppressed(summaries[0], "zh"); title != "rate: 另有1条提醒被抑制" {
+		t.Errorf("title %s", title)
+	}
+}
+
+func TestSnoozeRule(t *testing.T) {
+	var (
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		alice         = &fakeNotifier{name: "alice"}
+		router        = NewAlertRouter(subscriptions).Notifier(alice).History(NewAlertHistory(10))
+		rate          = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+		now           = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+		msgs          = []*Msg{{Source: FutuSourceName, ID: "1", CreatedAt: now, Text: "目标价 上调"}}
+	)
+	router.now = func() time.Time { return now }
+	subscriptions.Load([]config.Subscription{{User: "alice", Notifiers: []string{"alice"}}})
+
+	router.GetSuppressor().Snooze("rate", now.Add(time.Hour))
+	ApplyFilter([]MsgFilter{rate}, msgs, router.Route)
+	if len(alice.titles) != 0 {
+		t.Fatalf("snoozed: %v", alice.titles)
+	}
+
+	now = now.Add(time.Hour)
+	ApplyFilter([]MsgFilter{rate}, msgs, router.Route)
+	if len(alice.titles) != 1 {
+		t.Fatalf("the snooze is over: %v", alice.titles)
+	}
+	if snoozes := router.GetSuppressor().Snoozes(now); len(snoozes) != 0 {
+		t.Errorf("snoozes %v", snoozes)
+	}
+}
+
+func TestQuietWeekends(t *testing.T) {
+	var (
+		subscription = config.Subscription{QuietWeekends: true}
+		// Friday 23:00 in New York, Saturday 12:00 in Shanghai
+		now = time.Date(2020, 12, 5, 4, 0, 0, 0, time.UTC)
+	)
+	if !InQuietHours(subscription, now) {
+		t.Error("Saturday in Shanghai")
+	}
+	subscription.Timezone = "America/New_York"
+	if InQuietHours(subscription, now) {
+		t.Error("Friday in New York")
+	}
+}
+
+func TestAPISnooze(t *testing.T) {
+	defer func(suppressor *AlertSuppressor) { TheAlertRouter.Suppressor(suppressor) }(TheAlertRouter.GetSuppressor())
+	TheAlertRouter.Suppressor(NewAlertSuppressor())
+
+	var (
+		server = NewAPIServer("")
+		do     = func(method, path string) *httptest.ResponseRecorder {
+			recorder, request := httptest.NewRecorder(), httptest.NewRequest(method, path, nil)
+			request.RemoteAddr = "127.0.0.1:1234"
+			server.ServeHTTP(recorder, request)
+			return recorder
+		}
+	)
+	if recorder := do(http.MethodPost, "/rules/rate/snooze?minutes=30"); recorder.Code != http.StatusOK {
+		t.Fatalf("snooze: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := do(http.MethodGet, "/rules/snoozes"); !strings.Contains(recorder.Body.String(), `"rate"`) {
+		t.Errorf("snoozes: %s", recorder.Body.String())
+	}
+	for _, c := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodPost, "/rules/unknown/snooze", http.StatusNotFound},
+		{http.MethodPost, "/rules/rate/snooze?minutes=0", http.StatusBadRequest},
+		{http.MethodDelete, "/rules/rate/snooze", http.StatusNoContent},
+		{http.MethodDelete, "/rules/rate/snooze", http.StatusNotFound},
+	} {
+		if recorder := do(c.method, c.path); recorder.Code != c.code {
+			t.Errorf("%s %s: %d %s", c.method, c.path, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+var (
+	TheAlertTemplates = mustAlertTemplates(config.Config.Templates)
+
+	htmlTagRegexp = regexp.MustCompile(`(?s)<[^>]*>`)
+
+	alertTemplateFuncs = template.FuncMap{
+		"truncate":     truncate,
+		"stripHTML":    stripHTML,
+		"highlight":    highlight,
+		"relativeTime": relativeTime,
+		"join":         strings.Join,
+	}
+
+	// Used to validate the templates
+	sampleAlertMsg = &Msg{
+		Source:     FutuSourceName,
+		ID:         "1",
+		CreateTime: "08:00",
+		CreatedAt:  time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC),
+		Text:       "摩根士丹利：上调普拉格能源(PLUG.US)评级至“增持”，目标价由25美元上调至40美元",
+		Rating:     ExtractRatingChange("摩根士丹利：上调普拉格能源(PLUG.US)评级至“增持”，目标价由25美元上调至40美元"),
+		Symbols:    []string{"PLUG"},
+	}
+	sampleAlertData = AlertData{
+		Rule:      "rate",
+		Notifier:  DefaultNotifierName,
+		Source:    sampleAlertMsg.Source,
+		ID:        sampleAlertMsg.ID,
+		Time:      "2020-12-01 08:00:00",
+		CreatedAt: sampleAlertMsg.CreatedAt,
+		Text:      sampleAlertMsg.Text,
+		Rating:    sampleAlertMsg.Rating,
+		Symbols:   sampleAlertMsg.Symbols,
+		Keywords:  []string{"目标价", "评级"},
+		Msg:       sampleAlertMsg,
+	}
+)
+
+func mustAlertTemplates(templates []config.Template) *AlertTemplates {
+	t, err := NewAlertTemplates(templates)
+	if err != nil {
+		glog.Errorf("failed to load some alert templates, skip them, ERR: %v", err)
+	}
+	return t
+}
+
+// AlertData is what the alert templates see
+type AlertData struct {
+	Rule     string
+	Notifier string
+	Language string
+	Source   string
+	ID       string
+	// In the zone of the sources
+	Time      string
+	CreatedAt time.Time
+	Text      string
+	Rating    *RatingChange
+	Symbols   []string
+	// Of the rule, used by highlight
+	Keywords []string
+	Msg      *Msg
+}
+
+// KeywordsFilter is the filter which can tell its keywords to highlight
+type KeywordsFilter interface {
+	Keywords() []string
+}
+
+func NewAlertData(filter MsgFilter, notifier, language string, msg *Msg) AlertData {
+	data := AlertData{
+		Rule:      filter.Name(),
+		Notifier:  notifier,
+		Language:  language,
+		Source:    msg.Source,
+		ID:        msg.ID,
+		Time:      msg.LocalTime(),
+		CreatedAt: msg.CreatedAt,
+		Text:      msg.Text,
+		Rating:    msg.Rating,
+		Symbols:   msg.Symbols,
+		Msg:       msg,
+	}
+	if f, ok := filter.(KeywordsFilter); ok {
+		data.Keywords = f.Keywords()
+	}
+	return data
+}
+
+type alertTemplate struct {
+	rule, notifier, language string
+	title, content           *template.Template
+}
+
+// score is the number of the matched fields, -1 if not matched
+func (t *alertTemplate) score(rule, notifier, language string) (score int) {
+	for _, field := range [][2]string{{t.rule, rule}, {t.notifier, notifier}, {t.language, language}} {
+		switch field[0] {
+		case "":
+		case field[1]:
+			score++
+		default:
+			return -1
+		}
+	}
+	return
+}
+
+// AlertTemplates renders the alerts by the most specific template of the rule, the notifier and the language
+type AlertTemplates struct {
+	templates []*alertTemplate
+}
+
+// NewAlertTemplates parses the templates and renders them with a sample msg,
+// the invalid ones are skipped and joined in the error
+func NewAlertTemplates(templates []config.Template) (*AlertTemplates, error) {
+	var (
+		t    = &AlertTemplates{}
+		errs []error
+	)
+	for idx, c := range templates {
+		parsed, err := newAlertTemplate(c)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Templates[%d]: %v", idx, err))
+			continue
+		}
+		t.templates = append(t.templates, parsed)
+	}
+	return t, errors.Join(errs...)
+}
+
+func newAlertTemplate(c config.Template) (*alertTemplate, error) {
+	parsed := &alertTemplate{rule: c.Rule, notifier: c.Notifier, language: c.Language}
+	for _, part := range []struct {
+		name   string
+		text   string
+		target **template.Template
+	}{
+		{"title", c.Title, &parsed.title},
+		{"content", c.Content, &parsed.content},
+	} {
+		if part.text == "" {
+			continue
+		}
+		tmpl, err := template.New(part.name).Funcs(alertTemplateFuncs).Option("missingkey=error").Parse(part.text)
+		if err != nil {
+			return nil, err
+		}
+		if err = tmpl.Execute(&bytes.Buffer{}, sampleAlertData); err != nil {
+			return nil, err
+		}
+		*part.target = tmpl
+	}
+	return parsed, nil
+}
+
+// Render returns the title and the content by the templates, the parts without a template are from Format
+func (t *AlertTemplates) Render(filter MsgFilter, notifier, language string, msg *Msg) (title, content string) {
+	title, content = filter.Format(msg)
+
+	var (
+		best      *alertTemplate
+		bestScore = -1
+	)
+	for _, tmpl := range t.templates {
+		if score := tmpl.score(filter.Name(), notifier, language); score > bestScore {
+			best, bestScore = tmpl, score
+		}
+	}
+	if best == nil {
+		return
+	}
+
+	data := NewAlertData(filter, notifier, language, msg)
+	if best.title != nil {
+		title = executeAlertTemplate(best.title, data, title)
+	}
+	if best.content != nil {
+		content = executeAlertTemplate(best.content, data, content)
+	}
+	return
+}
+
+func executeAlertTemplate(tmpl *template.Template, data AlertData, fallback string) string {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		glog.Errorf("failed to render the %s of the alert of rule %s, ERR: %v", tmpl.Name(), data.Rule, err)
+		return fallback
+	}
+	return buf.String()
+}
+
+// FormatAlert formats the alert of the filter by the templates
+func FormatAlert(filter MsgFilter, notifier, language string, msg *Msg) (title, content string) {
+	return TheAlertTemplates.Render(filter, notifier, language, msg)
+}
+
+// ValidateTemplates checks the templates of the config
+func ValidateTemplates(c *config.Configuration) (errs []error) {
+	if _, err := NewAlertTemplates(c.Templates); err != nil {
+		errs = append(errs, err)
+	}
+	return
+}
+
+// truncate keeps the first n characters, ... is added if truncated
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
+
+func stripHTML(s string) string {
+	s = strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n").Replace(s)
+	return strings.TrimSpace(html.UnescapeString(htmlTagRegexp.ReplaceAllString(s, "")))
+}
+
+// highlight wraps the keywords with 【】
+func highlight(keywords []string, s string) string {
+	var pairs []string
+	for _, keyword := range keywords {
+		if keyword != "" {
+			pairs = append(pairs, keyword, "【"+keyword+"】")
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// relativeTime tells how long ago it was, in Chinese if the language is zh
+func relativeTime(t time.Time, language ...string) string {
+	var (
+		d  = time.Since(t)
+		zh = len(language) > 0 && language[0] == "zh"
+	)
+	switch {
+	case d < time.Minute:
+		if zh {
+			return "刚刚"
+		}
+		return "just now"
+	case d < time.Hour:
+		if zh {
+			return fmt.Sprintf("%d分钟前", int(d.Minutes()))
+		}
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		if zh {
+			return fmt.Sprintf("%d小时前", int(d.Hours()))
+		}
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	if zh {
+		return fmt.Sprintf("%d天前", int(d.Hours()/24))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestAlertTemplates(t *testing.T) {
+	templates, err := NewAlertTemplates([]config.Template{
+		{Title: "{{.Rule}} {{.Time}}"},
+		{Rule: "rate", Title: "{{with .Rating}}{{.Broker}} {{.Direction}} {{join $.Symbols \",\"}}{{end}}",
+			Content: "{{highlight .Keywords .Text | stripHTML | truncate 20}}"},
+		{Rule: "rate", Language: "en", Title: "{{.Rule}} by {{.Notifier}} in {{.Language}}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		rate  = NewRateMsgFilter()
+		other = NewKeywordMsgFilter(config.Rule{Name: "plug", Keywords: []string{"普拉格"}})
+		msg   = (&Msg{
+			Source:    FutuSourceName,
+			ID:        "1",
+			CreatedAt: time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC),
+			Text:      "<p>高盛：下调特斯拉(TSLA.US)评级至“中性”，目标价780美元</p>",
+		}).Extract()
+	)
+
+	title, content := templates.Render(rate, DefaultNotifierName, "", msg)
+	if title != "高盛 downgrade TSLA" || content != "高盛：下调特斯拉(TSLA.US)【评级..." {
+		t.Errorf("rate: %q %q", title, content)
+	}
+	if title, _ = templates.Render(rate, DefaultNotifierName, "en", msg); title != "rate by neuron in en" {
+		t.Errorf("rate in en: %q", title)
+	}
+	// The content is not in the template
+	title, content = templates.Render(other, DefaultNotifierName, "", msg)
+	if title != "plug 2020-12-01 08:00:00" || content != msg.Text {
+		t.Errorf("plug: %q %q", title, content)
+	}
+
+	for _, invalid := range []config.Template{
+		{Title: "{{.Rule"},
+		{Title: "{{.Unknown}}"},
+		{Content: "{{unknown .Text}}"},
+	} {
+		if _, err = NewAlertTemplates([]config.Template{invalid}); err == nil {
+			t.Errorf("expect the error of %+v", invalid)
+		}
+	}
+
+	// The valid ones are kept, the msg is there to validate
+	templates, err = NewAlertTemplates([]config.Template{
+		{Title: "{{.Unknown}}"},
+		{Title: "{{.Msg.Source}}/{{.Msg.ID}} {{.Msg.CreateTime}}"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "Templates[0]") || strings.Contains(err.Error(), "Templates[1]") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if title, _ = templates.Render(other, DefaultNotifierName, "", msg); title != "futu/1 " {
+		t.Errorf("msg: %q", title)
+	}
+}
+
+func TestAlertTemplateFuncs(t *testing.T) {
+	if got := stripHTML("<p>a&amp;b<br/>c</p>"); got != "a&b\nc" {
+		t.Errorf("stripHTML: %q", got)
+	}
+	if got := highlight([]string{"目标价"}, "上调目标价"); got != "上调【目标价】" {
+		t.Errorf("highlight: %q", got)
+	}
+	if got := truncate(2, "普拉格"); got != "普拉..." {
+		t.Errorf("truncate: %q", got)
+	}
+	if got := relativeTime(time.Now().Add(-3*time.Hour), "zh"); got != "3小时前" {
+		t.Errorf("relativeTime: %q", got)
+	}
+	if got := relativeTime(time.Now().Add(-5 * time.Minute)); got != "5m ago" {
+		t.Errorf("relativeTime: %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	AlertsDefaultLimit = 50
+)
+
+// GET /alerts?limit=50
+func (s *APIServer) listAlerts(w http.ResponseWriter, r *http.Request) {
+	limit := AlertsDefaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, TheAlertHistory.List(limit))
+}
+
+// GET /alerts/{id}
+func (s *APIServer) getAlert(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	record, hit := TheAlertHistory.Get(id)
+	if !hit {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown alert %d", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+// POST /alerts/{id}/ack stops the escalation of the alert
+func (s *APIServer) ackAlert(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	record, err := TheAlertHistory.Ack(id, time.Now())
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	ClustersDefaultLimit = 50
+)
+
+// GET /clusters?limit=50&all=false, only the clusters of more than one source unless all
+func (s *APIServer) listClusters(w http.ResponseWriter, r *http.Request) {
+	var (
+		query = r.URL.Query()
+		limit = ClustersDefaultLimit
+		all   bool
+		err   error
+	)
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if value := query.Get("all"); value != "" {
+		if all, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, TheMsgClusters.List(limit, !all))
+}
+
+// GET /clusters/{id}
+func (s *APIServer) getCluster(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	cluster, hit := TheMsgClusters.Get(id)
+	if !hit {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown cluster %d", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, cluster)
+}
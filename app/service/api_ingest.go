@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	IngestMaxBodyBytes = 8 << 20
+)
+
+// POST /ingest/{source} with "Authorization: Bearer <token>",
+// a msg {"id": "1", "created_at": "2020-12-01T00:30:00Z", "content": "..."} or an array of them
+func (s *APIServer) ingest(w http.ResponseWriter, r *http.Request) {
+	if !TheIngester.Enabled() {
+		writeError(w, http.StatusForbidden, fmt.Errorf("ingest is disabled"))
+		return
+	}
+	if !TheIngester.Authorized(r.Header.Get("Authorization")) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ingest"`)
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+		return
+	}
+	source := r.PathValue("source")
+	if err := TheIngester.CheckSource(source); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, IngestMaxBodyBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	msgs, err := decodeIngestMsgs(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errs := TheIngester.Validate(source, msgs); len(errs) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid msgs", "errors": errs})
+		return
+	}
+
+	result, err := TheIngester.Ingest(source, msgs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// decodeIngestMsgs reads a msg or an array of msgs
+func decodeIngestMsgs(body []byte) (msgs []*Msg, err error) {
+	body = bytes.TrimSpace(body)
+	switch {
+	case len(body) == 0:
+		return nil, fmt.Errorf("empty body")
+	case body[0] == '[':
+		err = json.Unmarshal(body, &msgs)
+	default:
+		var msg *Msg
+		err = json.Unmarshal(body, &msg)
+		msgs = []*Msg{msg}
+	}
+	if err == nil && len(msgs) == 0 {
+		err = fmt.Errorf("no msg")
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RuleDefaultSnoozeMinutes = 60
+)
+
+// GET /rules/replay?rules=rate,plug&source=futu&since=2020-12-01&until=2020-12-08&samples=3
+func (s *APIServer) replayRules(w http.ResponseWriter, r *http.Request) {
+	var (
+		query   = r.URL.Query()
+		names   []string
+		samples int
+		err     error
+	)
+
+	if value := query.Get("rules"); value != "" {
+		names = strings.Split(value, ",")
+	}
+	if value := query.Get("samples"); value != "" {
+		if samples, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	options, err := NewSearchOptions(query.Get("source"), query.Get("since"), query.Get("until"), 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	rules, err := GetRules(names)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := Replay(TheMsgStore, rules, options.Source, options.From, options.To, samples)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// GET /rules/snoozes
+func (s *APIServer) listSnoozes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, TheAlertRouter.GetSuppressor().Snoozes(time.Now()))
+}
+
+// POST /rules/{name}/snooze?minutes=60
+func (s *APIServer) snoozeRule(w http.ResponseWriter, r *http.Request) {
+	var (
+		name    = r.PathValue("name")
+		minutes = RuleDefaultSnoozeMinutes
+		err     error
+	)
+	if _, err = GetRule(name); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if value := r.URL.Query().Get("minutes"); value != "" {
+		if minutes, err = strconv.Atoi(value); err != nil || minutes <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid minutes %s", value))
+			return
+		}
+	}
+
+	until := time.Now().Add(time.Duration(minutes) * time.Minute)
+	TheAlertRouter.GetSuppressor().Snooze(name, until)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rule": name, "until": until})
+}
+
+// DELETE /rules/{name}/snooze
+func (s *APIServer) unsnoozeRule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !TheAlertRouter.GetSuppressor().Unsnooze(name) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("rule %s is not snoozed", name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
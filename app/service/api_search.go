@@ -0,0 +1,60 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/skeyic/monitoring/app/utils"
+)
+
+// NewSearchOptions parses the options from the API and the CLI, the times are in the zone of the sources
+func NewSearchOptions(source, since, until string, limit int) (options SearchOptions, err error) {
+	options.Source = source
+	options.Limit = limit
+	if since != "" {
+		if options.From, err = utils.NewSourceTimeParser(utils.ShanghaiLocation, time.Now()).Parse(since); err != nil {
+			return
+		}
+	}
+	if until != "" {
+		if options.To, err = utils.NewSourceTimeParser(utils.ShanghaiLocation, time.Now()).Parse(until); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GET /search?q=PLUG OR 普拉格&source=futu&since=2020-12-01&until=2020-12-02&limit=50
+func (s *APIServer) search(w http.ResponseWriter, r *http.Request) {
+	var (
+		query = r.URL.Query()
+		limit int
+		err   error
+	)
+
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	options, err := NewSearchOptions(query.Get("source"), query.Get("since"), query.Get("until"), limit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	msgs, err := TheSearchIndex.Search(query.Get("q"), options)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if msgs == nil {
+		msgs = []*Msg{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total": len(msgs),
+		"msgs":  msgs,
+	})
+}
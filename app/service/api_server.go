@@ -0,0 +1,120 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+var (
+	TheAPIServer = NewAPIServer(config.Config.API.Listen).Tokens(config.Config.API.Tokens)
+)
+
+// APIServer serves the HTTP API and the metrics on /debug/vars
+type APIServer struct {
+	listen string
+	tokens []string
+	mux    *http.ServeMux
+}
+
+func NewAPIServer(listen string) *APIServer {
+	s := &APIServer{
+		listen: listen,
+		mux:    http.NewServeMux(),
+	}
+	s.routes()
+	return s
+}
+
+// Tokens are required by the routes changing the state, which only accept the loopback if none
+func (s *APIServer) Tokens(tokens []string) *APIServer {
+	s.tokens = tokens
+	return s
+}
+
+func (s *APIServer) routes() {
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+	s.mux.HandleFunc("GET /search", s.search)
+	s.mux.HandleFunc("GET /rules/replay", s.replayRules)
+	s.mux.HandleFunc("GET /rules/snoozes", s.listSnoozes)
+	s.mux.HandleFunc("POST /rules/{name}/snooze", s.authorize(s.snoozeRule))
+	s.mux.HandleFunc("DELETE /rules/{name}/snooze", s.authorize(s.unsnoozeRule))
+	s.mux.HandleFunc("GET /subscriptions", s.listSubscriptions)
+	s.mux.HandleFunc("GET /subscriptions/{user}", s.getSubscription)
+	s.mux.HandleFunc("PUT /subscriptions/{user}", s.authorize(s.putSubscription))
+	s.mux.HandleFunc("DELETE /subscriptions/{user}", s.authorize(s.deleteSubscription))
+	s.mux.HandleFunc("GET /alerts", s.listAlerts)
+	s.mux.HandleFunc("GET /alerts/{id}", s.getAlert)
+	s.mux.HandleFunc("POST /alerts/{id}/ack", s.authorize(s.ackAlert))
+	s.mux.HandleFunc("GET /clusters", s.listClusters)
+	s.mux.HandleFunc("GET /clusters/{id}", s.getCluster)
+	s.mux.HandleFunc("POST /ingest/{source}", s.ingest)
+}
+
+// authorize requires a bearer token of the tokens, or the loopback if there is no token
+func (s *APIServer) authorize(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.tokens) == 0 {
+			if !isLoopback(r.RemoteAddr) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("only allowed from the loopback without API tokens"))
+				return
+			}
+		} else if !bearerAuthorized(r.Header.Get("Authorization"), s.tokens) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// bearerAuthorized checks the bearer token of the Authorization header is one of the tokens
+func bearerAuthorized(authorization string, tokens []string) bool {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *APIServer) Start() error {
+	glog.V(4).Infof("API SERVER LISTEN ON %s", s.listen)
+	return http.ListenAndServe(s.listen, s)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		glog.Errorf("failed to write the response, ERR: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
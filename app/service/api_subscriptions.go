@@ -0,0 +1,60 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+// GET /subscriptions
+func (s *APIServer) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, TheSubscriptions.List())
+}
+
+// GET /subscriptions/{user}
+func (s *APIServer) getSubscription(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	subscription, hit := TheSubscriptions.Get(user)
+	if !hit {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no subscription of %s", user))
+		return
+	}
+	writeJSON(w, http.StatusOK, subscription)
+}
+
+// PUT /subscriptions/{user} {"rules": ["rate"], "watchlists": ["holdings"], "notifiers": ["neuron"], "quiet_hours": "23:00-07:00"}
+func (s *APIServer) putSubscription(w http.ResponseWriter, r *http.Request) {
+	var subscription config.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	subscription.User = r.PathValue("user")
+
+	if err := ValidateSubscription(subscription); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := TheSubscriptions.Put(subscription); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, subscription)
+}
+
+// DELETE /subscriptions/{user}
+func (s *APIServer) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	hit, err := TheSubscriptions.Delete(user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !hit {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no subscription of %s", user))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+)
+
+// Collector fetches the msgs of a source page by page
+type Collector interface {
+	Name() string
+	// Fetch gets a page of the normalized msgs, the newest first
+	Fetch(page, pageSize int) ([]*Msg, error)
+	DefaultPageSize() int
+}
+
+// LiveCollector collects the new msgs by itself once started, and applies the rules to them
+type LiveCollector interface {
+	Collector
+	AddFilter(f MsgFilter)
+	Start()
+}
+
+// LiveCollectors are the configured ones besides Futu
+func LiveCollectors() (collectors []LiveCollector) {
+	for _, c := range TheFeedCollectors {
+		collectors = append(collectors, c)
+	}
+	for _, c := range TheRSSCollectors {
+		collectors = append(collectors, c)
+	}
+	for _, c := range TheHTMLCollectors {
+		collectors = append(collectors, c)
+	}
+	for _, c := range ThePushCollectors {
+		collectors = append(collectors, c)
+	}
+	for _, c := range TheFileCollectors {
+		collectors = append(collectors, c)
+	}
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].Name() < collectors[j].Name()
+	})
+	return
+}
+
+func Collectors() map[string]Collector {
+	collectors := map[string]Collector{
+		FutuSourceName:        TheFutuCollector,
+		SinaFinanceSourceName: TheSinaFinanceCollector,
+	}
+	for _, c := range LiveCollectors() {
+		collectors[c.Name()] = c
+	}
+	return collectors
+}
+
+func GetCollector(name string) (Collector, error) {
+	if c, hit := Collectors()[name]; hit {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown source: %s", name)
+}
+
+func CollectorNames() (names []string) {
+	for name := range Collectors() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Backfill saves the pages of the collector into the store without applying the rules,
+// returns the number of msgs not stored before
+func Backfill(c Collector, store MsgStore, pages, pageSize int) (total int, err error) {
+	for page := 0; page < pages; page++ {
+		msgs, err := c.Fetch(page, pageSize)
+		if err != nil {
+			return total, err
+		}
+		fresh := 0
+		for _, msg := range msgs {
+			if _, gErr := store.Get(msg.Source, msg.ID); gErr == ErrMsgNotFound {
+				fresh++
+			}
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		if err = store.Save(msgs); err != nil {
+			return total, err
+		}
+		total += fresh
+	}
+	return
+}
+
+// Poll fetches the pages of the collector until a page has no new msgs, at most maxPages,
+// saves and returns the new msgs
+func Poll(c Collector, store MsgStore, maxPages int) (fresh []*Msg, err error) {
+	for page := 0; page < maxPages; page++ {
+		msgs, fErr := c.Fetch(page, c.DefaultPageSize())
+		if fErr != nil {
+			err = fErr
+			break
+		}
+		n := len(fresh)
+		// The pages may overlap if the new msgs arrive between them
+		fresh = MergeMsgs(fresh, FreshMsgs(store, msgs))
+		if len(fresh) == n {
+			break
+		}
+	}
+	if len(fresh) > 0 {
+		if sErr := store.Save(fresh); sErr != nil && err == nil {
+			err = sErr
+		}
+	}
+	return
+}
+
+// Collected clusters the new msgs saved, before the rules are applied to them, and hands them to the sinks
+func Collected(msgs []*Msg) {
+	if len(msgs) == 0 {
+		return
+	}
+	for _, msg := range msgs {
+		TheMsgClusters.Add(msg)
+	}
+	TheESSink.AddMsgs(msgs)
+	TheSinks.Send(msgs)
+}
+
+// FreshMsgs are the msgs not in the store
+func FreshMsgs(store MsgStore, msgs []*Msg) (fresh []*Msg) {
+	for _, msg := range msgs {
+		if _, err := store.Get(msg.Source, msg.ID); err == ErrMsgNotFound {
+			fresh = append(fresh, msg)
+		}
+	}
+	return
+}
+
+// CollectFresh saves the msgs not in the store and applies the filters to them, from the newest
+func CollectFresh(store MsgStore, filters []MsgFilter, msgs []*Msg) (fresh []*Msg, err error) {
+	if fresh = FreshMsgs(store, MergeMsgs(nil, msgs)); len(fresh) == 0 {
+		return
+	}
+	if err = store.Save(fresh); err != nil {
+		return
+	}
+	Collected(fresh)
+	ApplyFilter(filters, fresh, TheAlertRouter.Route)
+	return
+}
+
+// startPolling calls poll every interval, skips if the previous one is still running
+func startPolling(name string, interval time.Duration, poll func() ([]*Msg, error)) {
+	var (
+		ticker = time.NewTicker(interval)
+		locker = &utils.AsyncLocker{}
+	)
+	for a := range ticker.C {
+		go func(a time.Time) {
+			if !locker.TryLock() {
+				glog.V(4).Infof("Another poll of %s is running, %s", name, a)
+				return
+			}
+			defer locker.Unlock()
+			fresh, err := poll()
+			glog.V(4).Infof("POLL %s: %d FRESH MSGS, ERR: %v", name, len(fresh), err)
+		}(a)
+	}
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+var (
+	defaultEntityAliases = map[string][]string{
+		"PLUG":      {"普拉格", "普拉格能源", "Plug Power"},
+		"TSLA":      {"特斯拉", "Tesla"},
+		"NIO":       {"蔚来", "蔚来汽车"},
+		"XPEV":      {"小鹏汽车", "XPeng"},
+		"LI":        {"理想汽车", "Li Auto"},
+		"BABA":      {"阿里巴巴", "Alibaba"},
+		"JD":        {"京东"},
+		"00700.HK":  {"腾讯", "腾讯控股", "Tencent"},
+		"03690.HK":  {"美团"},
+		"600519.SH": {"贵州茅台", "茅台"},
+		"300750.SZ": {"宁德时代"},
+	}
+
+	TheEntityRecognizer = NewEntityRecognizer().AddAliases(defaultEntityAliases)
+
+	// Watchlist name -> watchlist
+	TheWatchlists = make(map[string]*Watchlist)
+
+	symbolUSRegexp = regexp.MustCompile(`^[A-Z][A-Z.\-]{0,5}$`)
+	symbolHKRegexp = regexp.MustCompile(`^(\d{1,5})\.HK$`)
+	// The bare codes of Hong Kong are 5 digits, e.g. 00700
+	symbolHKCodeRegexp = regexp.MustCompile(`^\d{5}$`)
+	symbolAShareRegex  = regexp.MustCompile(`^(\d{6})(?:\.(SH|SS|SZ|BJ))?$`)
+
+	entityTickerRegexps = []*regexp.Regexp{
+		// $PLUG
+		regexp.MustCompile(`\$([A-Za-z]{1,5})\b`),
+		// PLUG.US, 00700.HK, 600519.SH
+		regexp.MustCompile(`\b([A-Z]{1,5}\.US|\d{4,5}\.HK|\d{6}\.(?:SH|SS|SZ|BJ))\b`),
+		// (00700)
+		regexp.MustCompile(`[（(]\s*(\d{5})\s*[)）]`),
+	}
+	// (PLUG), but not (CEO), see parenTicker
+	entityParenLettersRegexp = regexp.MustCompile(`[（(]\s*([A-Z]{1,5})\s*[)）]`)
+	// 某某科技(ABCD), Plug Power Inc. (PLUG)
+	entityCompanySuffixRegexp = regexp.MustCompile(`(?i)(?:公司|集团|控股|科技|能源|汽车|银行|证券|保险|医药|生物|电子|半导体|股份|\binc\.?|\bcorp\.?|\bcorporation|\bltd\.?|\bholdings|\bgroup)\s*$`)
+	// 600519, but not the amounts like 600519元 or 300000美元
+	entityAShareRegexp = regexp.MustCompile(`\b((?:60|68|00|30)\d{4})\b`)
+	entityAmountRegexp = regexp.MustCompile(`^(?:[.%元股手万亿人]|[美港欧日]元)`)
+	entityWordRegexp   = regexp.MustCompile(`\b[A-Z]{1,5}\b`)
+)
+
+func init() {
+	if config.Config.Entities.Aliases != "" {
+		if err := TheEntityRecognizer.LoadFile(config.Config.Entities.Aliases); err != nil {
+			glog.Errorf("failed to load the entity aliases %s, ERR: %v", config.Config.Entities.Aliases, err)
+		}
+	}
+	for _, watchlist := range config.Config.Watchlists {
+		w, err := LoadWatchlist(watchlist.Name, watchlist.File, TheEntityRecognizer)
+		if err != nil {
+			glog.Errorf("failed to load the watchlist %s, ERR: %v", watchlist.Name, err)
+			continue
+		}
+		TheWatchlists[watchlist.Name] = w
+	}
+}
+
+// NormalizeSymbol returns the canonical symbol: PLUG, 00700.HK, 600519.SH, or "" if it is not a symbol
+func NormalizeSymbol(symbol string) string {
+	symbol = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(symbol)), "$")
+	symbol = strings.TrimSuffix(symbol, ".US")
+
+	if m := symbolHKRegexp.FindStringSubmatch(symbol); m != nil {
+		return strings.Repeat("0", 5-len(m[1])) + m[1] + ".HK"
+	}
+	if symbolHKCodeRegexp.MatchString(symbol) {
+		return symbol + ".HK"
+	}
+	if m := symbolAShareRegex.FindStringSubmatch(symbol); m != nil {
+		switch {
+		case m[2] == "SS":
+			return m[1] + ".SH"
+		case m[2] != "":
+			return symbol
+		}
+		switch m[1][0] {
+		case '5', '6', '9':
+			return m[1] + ".SH"
+		case '0', '2', '3':
+			return m[1] + ".SZ"
+		case '4', '8':
+			return m[1] + ".BJ"
+		}
+		return ""
+	}
+	if symbolUSRegexp.MatchString(symbol) {
+		return symbol
+	}
+	return ""
+}
+
+// EntityRecognizer finds the tickers and the company names in the msgs
+type EntityRecognizer struct {
+	// Lowercase alias -> symbol
+	aliases map[string]string
+	// Sorted from the longest
+	aliasList []string
+	symbols   map[string]bool
+}
+
+func NewEntityRecognizer() *EntityRecognizer {
+	return &EntityRecognizer{
+		aliases: make(map[string]string),
+		symbols: make(map[string]bool),
+	}
+}
+
+// AddAliases adds the names of the symbols
+func (r *EntityRecognizer) AddAliases(aliases map[string][]string) *EntityRecognizer {
+	for symbol, names := range aliases {
+		canonical := NormalizeSymbol(symbol)
+		if canonical == "" {
+			glog.Warningf("skip the aliases of the invalid symbol %s", symbol)
+			continue
+		}
+		r.symbols[canonical] = true
+		for _, name := range names {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				r.aliases[name] = canonical
+			}
+		}
+	}
+
+	r.aliasList = r.aliasList[:0]
+	for alias := range r.aliases {
+		r.aliasList = append(r.aliasList, alias)
+	}
+	sort.Slice(r.aliasList, func(i, j int) bool {
+		if len(r.aliasList[i]) != len(r.aliasList[j]) {
+			return len(r.aliasList[i]) > len(r.aliasList[j])
+		}
+		return r.aliasList[i] < r.aliasList[j]
+	})
+	return r
+}
+
+// LoadFile adds the aliases of the file, one symbol per line: PLUG: 普拉格, 普拉格能源, Plug Power
+func (r *EntityRecognizer) LoadFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	aliases := make(map[string][]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		symbol, names, found := strings.Cut(text, ":")
+		if !found || NormalizeSymbol(symbol) == "" {
+			return fmt.Errorf("%s:%d: expect SYMBOL: alias, alias", fileName, line)
+		}
+		aliases[symbol] = append(aliases[symbol], strings.Split(names, ",")...)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	r.AddAliases(aliases)
+	return nil
+}
+
+// Symbol returns the canonical symbol of the symbol or the alias, "" if unknown
+func (r *EntityRecognizer) Symbol(value string) string {
+	if symbol, hit := r.aliases[strings.ToLower(strings.TrimSpace(value))]; hit {
+		return symbol
+	}
+	return NormalizeSymbol(value)
+}
+
+// Recognize returns the canonical symbols in the text, in the order they appear
+func (r *EntityRecognizer) Recognize(text string) (symbols []string) {
+	type found struct {
+		pos    int
+		symbol string
+	}
+	var all []found
+	add := func(pos int, value string) {
+		if symbol := NormalizeSymbol(value); symbol != "" {
+			all = append(all, found{pos, symbol})
+		}
+	}
+
+	for _, re := range entityTickerRegexps {
+		for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+			add(m[2], text[m[2]:m[3]])
+		}
+	}
+	for _, m := range entityParenLettersRegexp.FindAllStringSubmatchIndex(text, -1) {
+		if value := text[m[2]:m[3]]; r.parenTicker(text[:m[0]], value) {
+			add(m[2], value)
+		}
+	}
+	for _, m := range entityAShareRegexp.FindAllStringSubmatchIndex(text, -1) {
+		if !entityAmountRegexp.MatchString(text[m[3]:]) {
+			add(m[2], text[m[2]:m[3]])
+		}
+	}
+	// The known symbols without the marks
+	for _, m := range entityWordRegexp.FindAllStringIndex(text, -1) {
+		if r.symbols[text[m[0]:m[1]]] {
+			add(m[0], text[m[0]:m[1]])
+		}
+	}
+
+	lower := strings.ToLower(text)
+	for _, alias := range r.aliasList {
+		for start := 0; ; {
+			idx := strings.Index(lower[start:], alias)
+			if idx < 0 {
+				break
+			}
+			idx += start
+			start = idx + len(alias)
+			if isWordBoundary(lower, idx, start) {
+				all = append(all, found{idx, r.aliases[alias]})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].pos < all[j].pos
+	})
+	seen := make(map[string]bool)
+	for _, f := range all {
+		if !seen[f.symbol] {
+			seen[f.symbol] = true
+			symbols = append(symbols, f.symbol)
+		}
+	}
+	return
+}
+
+// parenTicker tells if the letters in the parentheses are a ticker: a known symbol or alias,
+// or the ones following a known company or a name like 某某科技
+func (r *EntityRecognizer) parenTicker(before, value string) bool {
+	if r.symbols[value] || r.aliases[strings.ToLower(value)] != "" {
+		return true
+	}
+	before = strings.ToLower(strings.TrimSpace(before))
+	for _, alias := range r.aliasList {
+		if strings.HasSuffix(before, alias) && isWordBoundary(before, len(before)-len(alias), len(before)) {
+			return true
+		}
+	}
+	return entityCompanySuffixRegexp.MatchString(before)
+}
+
+// isWordBoundary tells if text[start:end] is not a part of an English word
+func isWordBoundary(text string, start, end int) bool {
+	isWord := func(b byte) bool {
+		return b >= 'a' && b <= 'z' || b >= '0' && b <= '9'
+	}
+	if start > 0 && isWord(text[start-1]) && isWord(text[start]) {
+		return false
+	}
+	if end < len(text) && isWord(text[end]) && isWord(text[end-1]) {
+		return false
+	}
+	return true
+}
+
+// Watchlist is a named set of symbols referenced by the rules
+type Watchlist struct {
+	Name    string
+	symbols map[string]bool
+}
+
+func NewWatchlist(name string, symbols ...string) *Watchlist {
+	w := &Watchlist{
+		Name:    name,
+		symbols: make(map[string]bool),
+	}
+	for _, symbol := range symbols {
+		w.symbols[symbol] = true
+	}
+	return w
+}
+
+// LoadWatchlist reads the symbols or the aliases of the file, one per line
+func LoadWatchlist(name, fileName string, recognizer *EntityRecognizer) (*Watchlist, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	w := NewWatchlist(name)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		symbol := recognizer.Symbol(text)
+		if symbol == "" {
+			return nil, fmt.Errorf("%s:%d: unknown symbol %s", fileName, line, text)
+		}
+		w.symbols[symbol] = true
+	}
+	return w, scanner.Err()
+}
+
+// Match tells if any of the symbols is in the watchlist
+func (w *Watchlist) Match(symbols []string) bool {
+	for _, symbol := range symbols {
+		if w.symbols[symbol] {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watchlist) Symbols() (symbols []string) {
+	for symbol := range w.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return
+}
+
+// GetWatchlist returns the watchlist loaded from the config
+func GetWatchlist(name string) (*Watchlist, error) {
+	if w, hit := TheWatchlists[name]; hit {
+		return w, nil
+	}
+	return nil, fmt.Errorf("unknown watchlist: %s", name)
+}
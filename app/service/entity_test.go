@@ -0,0 +1,95 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestNormalizeSymbol(t *testing.T) {
+	for symbol, want := range map[string]string{
+		"PLUG":      "PLUG",
+		"$plug":     "PLUG",
+		"PLUG.US":   "PLUG",
+		"BRK.B":     "BRK.B",
+		"700.HK":    "00700.HK",
+		"00700.HK":  "00700.HK",
+		"00700":     "00700.HK",
+		"600519":    "600519.SH",
+		"600519.SS": "600519.SH",
+		"000001":    "000001.SZ",
+		"300750.SZ": "300750.SZ",
+		"普拉格":       "",
+		"TOOLONG":   "",
+	} {
+		if got := NormalizeSymbol(symbol); got != want {
+			t.Errorf("NormalizeSymbol(%s) = %s, want %s", symbol, got, want)
+		}
+	}
+}
+
+func TestEntityRecognizer(t *testing.T) {
+	recognizer := NewEntityRecognizer().AddAliases(map[string][]string{
+		"PLUG":     {"普拉格", "Plug Power"},
+		"00700.HK": {"腾讯"},
+		"AMD":      {"超威半导体"},
+	})
+	for text, want := range map[string][]string{
+		"普拉格能源大涨，氢能源板块走强":                             {"PLUG"},
+		"摩根士丹利：上调普拉格能源(PLUG.US)评级至“增持”":               {"PLUG"},
+		"腾讯控股(00700.HK)与贵州茅台600519同时创新高":              {"00700.HK", "600519.SH"},
+		"$TSLA and Plug Power rallied, AMD fell":      {"TSLA", "PLUG", "AMD"},
+		"AMDX rallied, Plug Powerful is not a ticker": nil,
+		"成交额达600519元":                                 nil,
+		"目标价300000美元，募资600000港元":                      nil,
+		"腾讯(00700)与小米集团（01810）":                       {"00700.HK", "01810.HK"},
+		"公司首席执行官(CEO)表示，(AI)需求强劲":                     nil,
+		"超威半导体(AMD)与某某科技(ABCD)":                       {"AMD", "ABCD"},
+		"Plug Power (PLUG) and Acme Holdings (ACME)":  {"PLUG", "ACME"},
+	} {
+		if got := recognizer.Recognize(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestWatchlistRule(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		fileName = filepath.Join(dir, "holdings.txt")
+	)
+	if err := os.WriteFile(fileName, []byte("# holdings\n普拉格\n700.HK\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := LoadWatchlist("holdings", fileName, TheEntityRecognizer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Symbols(); !reflect.DeepEqual(got, []string{"00700.HK", "PLUG"}) {
+		t.Fatalf("symbols %v", got)
+	}
+
+	TheWatchlists["holdings"] = w
+	defer delete(TheWatchlists, "holdings")
+
+	rule := NewKeywordMsgFilter(config.Rule{Name: "holdings", Watchlists: []string{"holdings"}})
+	for text, want := range map[string]bool{
+		"普拉格能源涨超10%":       true,
+		"腾讯控股(00700.HK)回购": true,
+		"特斯拉交付创新高":         false,
+	} {
+		if got := rule.Match((&Msg{Text: text}).Extract()); got != want {
+			t.Errorf("%s: got %v, want %v", text, got, want)
+		}
+	}
+
+	if err = os.WriteFile(fileName, []byte("不存在的公司\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadWatchlist("holdings", fileName, TheEntityRecognizer); err == nil {
+		t.Error("expect the error of the unknown symbol")
+	}
+}
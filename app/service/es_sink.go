@@ -0,0 +1,394 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	ESIndexDateLayout = "2006.01.02"
+)
+
+var (
+	TheESSink = newESSink()
+
+	esSinkMetrics = expvar.NewMap("es_sink")
+
+	esMsgMappings = map[string]interface{}{
+		"source":          map[string]string{"type": "keyword"},
+		"id":              map[string]string{"type": "keyword"},
+		"create_time_str": map[string]string{"type": "keyword"},
+		"created_at":      map[string]string{"type": "date"},
+		"content":         map[string]string{"type": "text"},
+		"symbols":         map[string]string{"type": "keyword"},
+	}
+	esAlertMappings = map[string]interface{}{
+		"id":         map[string]string{"type": "long"},
+		"rule":       map[string]string{"type": "keyword"},
+		"priority":   map[string]string{"type": "keyword"},
+		"user":       map[string]string{"type": "keyword"},
+		"source":     map[string]string{"type": "keyword"},
+		"msg_id":     map[string]string{"type": "keyword"},
+		"cluster":    map[string]string{"type": "long"},
+		"sources":    map[string]string{"type": "keyword"},
+		"title":      map[string]string{"type": "text"},
+		"content":    map[string]string{"type": "text"},
+		"created_at": map[string]string{"type": "date"},
+		"acked_at":   map[string]string{"type": "date"},
+		"escalated":  map[string]string{"type": "boolean"},
+		"timeline": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"time":     map[string]string{"type": "date"},
+				"type":     map[string]string{"type": "keyword"},
+				"notifier": map[string]string{"type": "keyword"},
+				"error":    map[string]string{"type": "text"},
+			},
+		},
+	}
+)
+
+func newESSink() *ESSink {
+	es := config.Config.Elasticsearch
+	return NewESSink(es.URL, es.IndexPrefix).Auth(es.Username, es.Password).
+		Batch(es.BatchSize, es.BatchBytes, time.Duration(es.FlushSeconds)*time.Second).
+		Retries(es.MaxRetries).QueueSize(es.QueueSize)
+}
+
+type esDoc struct {
+	index   string
+	id      string
+	body    []byte
+	retries int
+}
+
+// esBulkResponse is the result of each action of a bulk request, in order
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// ESSink indexes the msgs and the alert records into Elasticsearch by the _bulk API, the daily indices are
+// created by the index templates. The docs are buffered and sent in batches, the ones rejected for the moment
+// are retried by the next flush.
+type ESSink struct {
+	url        string
+	prefix     string
+	headers    map[string]string
+	batchSize  int
+	batchBytes int
+	interval   time.Duration
+	maxRetries int
+	queueSize  int
+
+	lock sync.Mutex
+	docs []*esDoc
+	full chan struct{}
+
+	// One flush at a time
+	flushLock sync.Mutex
+	templated bool
+}
+
+func NewESSink(url, prefix string) *ESSink {
+	return &ESSink{
+		url:        strings.TrimSuffix(url, "/"),
+		prefix:     prefix,
+		headers:    map[string]string{"Content-Type": "application/x-ndjson"},
+		batchSize:  500,
+		batchBytes: 5 << 20,
+		interval:   5 * time.Second,
+		maxRetries: 3,
+		queueSize:  10000,
+		full:       make(chan struct{}, 1),
+	}
+}
+
+// Auth sets the basic auth, none if the username is empty
+func (s *ESSink) Auth(username, password string) *ESSink {
+	if username != "" {
+		s.headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	return s
+}
+
+func (s *ESSink) Batch(size, bytes int, interval time.Duration) *ESSink {
+	s.batchSize, s.batchBytes, s.interval = size, bytes, interval
+	return s
+}
+
+func (s *ESSink) Retries(maxRetries int) *ESSink {
+	s.maxRetries = maxRetries
+	return s
+}
+
+func (s *ESSink) QueueSize(queueSize int) *ESSink {
+	s.queueSize = queueSize
+	return s
+}
+
+func (s *ESSink) Enabled() bool {
+	return s.url != ""
+}
+
+// Pending is the number of the docs not indexed yet
+func (s *ESSink) Pending() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.docs)
+}
+
+func (s *ESSink) MsgIndex(createdAt time.Time) string {
+	return s.prefix + "-msgs-" + createdAt.UTC().Format(ESIndexDateLayout)
+}
+
+func (s *ESSink) AlertIndex(createdAt time.Time) string {
+	return s.prefix + "-alerts-" + createdAt.UTC().Format(ESIndexDateLayout)
+}
+
+// AddMsgs queues the msgs, indexed by source:id so that they are not duplicated
+func (s *ESSink) AddMsgs(msgs []*Msg) {
+	if !s.Enabled() {
+		return
+	}
+	docs := make([]*esDoc, 0, len(msgs))
+	for _, msg := range msgs {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			glog.Errorf("failed to marshal msg %s/%s, ERR: %v", msg.Source, msg.ID, err)
+			continue
+		}
+		docs = append(docs, &esDoc{index: s.MsgIndex(msg.CreatedAt), id: msg.Source + ":" + msg.ID, body: body})
+	}
+	s.push(docs)
+}
+
+// AddAlert queues the record, which replaces the one indexed before as the acks and the deliveries are added
+func (s *ESSink) AddAlert(record AlertRecord) {
+	if !s.Enabled() {
+		return
+	}
+	body, err := json.Marshal(record)
+	if err != nil {
+		glog.Errorf("failed to marshal alert %d, ERR: %v", record.ID, err)
+		return
+	}
+	// The IDs of the history start from 1 after restarting
+	id := fmt.Sprintf("%d-%d", record.CreatedAt.UnixNano(), record.ID)
+	s.push([]*esDoc{{index: s.AlertIndex(record.CreatedAt), id: id, body: body}})
+}
+
+func (s *ESSink) push(docs []*esDoc) {
+	if len(docs) == 0 {
+		return
+	}
+	s.lock.Lock()
+	s.docs = append(s.docs, docs...)
+	s.trim()
+	full := len(s.docs) >= s.batchSize
+	s.lock.Unlock()
+
+	if full {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest docs over the queue size
+func (s *ESSink) trim() {
+	if over := len(s.docs) - s.queueSize; s.queueSize > 0 && over > 0 {
+		glog.Errorf("ES SINK FULL, DROP THE OLDEST %d DOCS", over)
+		esSinkMetrics.Add("dropped", int64(over))
+		s.docs = s.docs[over:]
+	}
+}
+
+// take removes a batch from the queue, within the batch size and bytes but at least a doc
+func (s *ESSink) take() (batch []*esDoc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var size int
+	for len(batch) < len(s.docs) && len(batch) < s.batchSize {
+		doc := s.docs[len(batch)]
+		if len(batch) > 0 && size+len(doc.body) > s.batchBytes {
+			break
+		}
+		size += len(doc.body)
+		batch = append(batch, doc)
+	}
+	s.docs = s.docs[len(batch):]
+	return
+}
+
+// requeue puts the docs back in front, the retried ones are dropped after the max retries
+func (s *ESSink) requeue(docs []*esDoc, retried bool) {
+	kept := docs[:0]
+	for _, doc := range docs {
+		if retried {
+			if doc.retries++; doc.retries > s.maxRetries {
+				glog.Errorf("ES SINK DROP DOC %s/%s AFTER %d RETRIES", doc.index, doc.id, s.maxRetries)
+				esSinkMetrics.Add("dropped", 1)
+				continue
+			}
+			esSinkMetrics.Add("retried", 1)
+		}
+		kept = append(kept, doc)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.docs = append(kept, s.docs...)
+	s.trim()
+}
+
+func (s *ESSink) send(method, path string, body []byte, headers map[string]string) (int, string, error) {
+	return utils.SendRequestWithHeaders(method, s.url+path, bytes.NewBuffer(body), headers)
+}
+
+// PutTemplates creates or updates the index templates of the daily indices
+func (s *ESSink) PutTemplates() error {
+	headers := map[string]string{"Content-Type": "application/json"}
+	if auth, hit := s.headers["Authorization"]; hit {
+		headers["Authorization"] = auth
+	}
+	for name, mappings := range map[string]map[string]interface{}{
+		s.prefix + "-msgs":   esMsgMappings,
+		s.prefix + "-alerts": esAlertMappings,
+	} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"index_patterns": []string{name + "-*"},
+			"template": map[string]interface{}{
+				"mappings": map[string]interface{}{"properties": mappings},
+			},
+		})
+		rCode, rBody, err := s.send(http.MethodPut, "/_index_template/"+name, body, headers)
+		if err != nil {
+			return err
+		}
+		if rCode != http.StatusOK {
+			return fmt.Errorf("failed to put the index template %s, CODE: %d, BODY: %s", name, rCode, rBody)
+		}
+	}
+	return nil
+}
+
+// Flush sends the queued docs in batches, stops at a failed request or a batch partially failed
+func (s *ESSink) Flush() error {
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	if !s.templated {
+		if err := s.PutTemplates(); err != nil {
+			return err
+		}
+		s.templated = true
+	}
+
+	for {
+		batch := s.take()
+		if len(batch) == 0 {
+			return nil
+		}
+		failed, err := s.bulk(batch)
+		if err != nil {
+			s.requeue(batch, false)
+			return err
+		}
+		esSinkMetrics.Add("indexed", int64(len(batch)-len(failed)))
+		if len(failed) > 0 {
+			s.requeue(failed, true)
+			return nil
+		}
+	}
+}
+
+// bulk indexes the batch, returns the docs to retry, which are rejected for the moment
+func (s *ESSink) bulk(batch []*esDoc) (failed []*esDoc, err error) {
+	var body bytes.Buffer
+	for _, doc := range batch {
+		action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": doc.index, "_id": doc.id}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.body)
+		body.WriteByte('\n')
+	}
+
+	rCode, rBody, err := s.send(http.MethodPost, "/_bulk", body.Bytes(), s.headers)
+	if err != nil {
+		return nil, err
+	}
+	if rCode != http.StatusOK {
+		return nil, fmt.Errorf("bulk of %d docs failed, CODE: %d, BODY: %s", len(batch), rCode, rBody)
+	}
+
+	var resp esBulkResponse
+	if err = json.Unmarshal([]byte(rBody), &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Errors {
+		return nil, nil
+	}
+	if len(resp.Items) != len(batch) {
+		return nil, fmt.Errorf("bulk of %d docs responded %d items", len(batch), len(resp.Items))
+	}
+	for idx, item := range resp.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < http.StatusMultipleChoices:
+			case result.Status == http.StatusTooManyRequests || result.Status >= http.StatusInternalServerError:
+				failed = append(failed, batch[idx])
+			default:
+				reason := ""
+				if result.Error != nil {
+					reason = result.Error.Type + ": " + result.Error.Reason
+				}
+				glog.Errorf("ES SINK DROP DOC %s/%s, STATUS: %d, %s", batch[idx].index, batch[idx].id, result.Status, reason)
+				esSinkMetrics.Add("dropped", 1)
+			}
+		}
+	}
+	return failed, nil
+}
+
+func (s *ESSink) Start() {
+	s.Run(context.Background())
+}
+
+// Run flushes every interval or when a batch is full, and the rest when ctx is done
+func (s *ESSink) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Flush(); err != nil {
+				glog.Errorf("failed to flush the es sink, %d docs left, ERR: %v", s.Pending(), err)
+			}
+			return
+		case <-ticker.C:
+		case <-s.full:
+		}
+		if err := s.Flush(); err != nil {
+			glog.Errorf("failed to flush the es sink, ERR: %v", err)
+		}
+	}
+}
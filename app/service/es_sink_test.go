@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// esServer stands in for Elasticsearch, rejects the docs by the IDs given for the status once
+type esServer struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	templates map[string]string
+	// _index/_id -> the doc
+	docs     map[string]string
+	bulks    []int
+	reject   map[string]int
+	down     bool
+	username string
+}
+
+func newESServer() *esServer {
+	s := &esServer{
+		templates: make(map[string]string),
+		docs:      make(map[string]string),
+		reject:    make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *esServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// No credentials unless given
+	if username, _, _ := r.BasicAuth(); username != s.username {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_index_template/"):
+		s.templates[strings.TrimPrefix(r.URL.Path, "/_index_template/")] = string(body)
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var (
+			scanner = bufio.NewScanner(strings.NewReader(string(body)))
+			items   []string
+			errors  bool
+		)
+		for scanner.Scan() {
+			var action struct {
+				Index struct {
+					Index string `json:"_index"`
+					ID    string `json:"_id"`
+				} `json:"index"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+			key := action.Index.Index + "/" + action.Index.ID
+			if status, hit := s.reject[action.Index.ID]; hit {
+				delete(s.reject, action.Index.ID)
+				errors = true
+				items = append(items, fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"rejected","reason":"test"}}}`, status))
+				continue
+			}
+			s.docs[key] = scanner.Text()
+			items = append(items, `{"index":{"status":201}}`)
+		}
+		s.bulks = append(s.bulks, len(items))
+		fmt.Fprintf(w, `{"errors":%v,"items":[%s]}`, errors, strings.Join(items, ","))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *esServer) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.docs)
+}
+
+func testESMsgs(count int) (msgs []*Msg) {
+	start := time.Date(2020, 12, 1, 23, 58, 0, 0, time.UTC)
+	for idx := 0; idx < count; idx++ {
+		msgs = append(msgs, &Msg{Source: "futu", ID: fmt.Sprint(idx), CreatedAt: start.Add(time.Duration(idx) * time.Minute), Text: "快讯"})
+	}
+	return
+}
+
+func TestESSink(t *testing.T) {
+	server := newESServer()
+	defer server.Close()
+	server.username = "elastic-writer"
+
+	sink := NewESSink(server.URL, "mon").Auth("elastic-writer", "secret").Batch(2, 1<<20, time.Hour).Retries(1)
+	server.reject["futu:1"] = http.StatusTooManyRequests
+	server.reject["futu:2"] = http.StatusBadRequest
+	sink.AddMsgs(testESMsgs(5))
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// futu:1 is retried by the next flush with futu:2, which is dropped
+	if server.count() != 1 || sink.Pending() != 4 {
+		t.Fatalf("%d indexed, %d pending, bulks %v", server.count(), sink.Pending(), server.bulks)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if server.count() != 4 || sink.Pending() != 0 || fmt.Sprint(server.bulks) != "[2 2 2]" {
+		t.Errorf("%d indexed, %d pending, bulks %v", server.count(), sink.Pending(), server.bulks)
+	}
+
+	// Daily indices
+	for _, key := range []string{"mon-msgs-2020.12.01/futu:0", "mon-msgs-2020.12.01/futu:1", "mon-msgs-2020.12.02/futu:4"} {
+		if _, hit := server.docs[key]; !hit {
+			t.Errorf("missing %s: %v", key, server.docs)
+		}
+	}
+	if template := server.templates["mon-msgs"]; !strings.Contains(template, `"index_patterns":["mon-msgs-*"]`) ||
+		!strings.Contains(template, `"created_at":{"type":"date"}`) {
+		t.Errorf("template %s", template)
+	}
+	if _, hit := server.templates["mon-alerts"]; !hit {
+		t.Errorf("templates %v", server.templates)
+	}
+
+	// Retried at most once
+	server.reject["futu:0"] = http.StatusServiceUnavailable
+	sink.AddMsgs(testESMsgs(1))
+	sink.Flush()
+	server.reject["futu:0"] = http.StatusServiceUnavailable
+	sink.Flush()
+	if sink.Pending() != 0 {
+		t.Errorf("%d pending", sink.Pending())
+	}
+}
+
+func TestESSinkBatch(t *testing.T) {
+	server := newESServer()
+	defer server.Close()
+
+	// By bytes
+	sink := NewESSink(server.URL, "mon").Batch(100, 250, time.Hour)
+	sink.AddMsgs(testESMsgs(4))
+	if err := sink.Flush(); err != nil || fmt.Sprint(server.bulks) != "[2 2]" {
+		t.Errorf("bulks %v, %v", server.bulks, err)
+	}
+
+	// By size and time
+	server.bulks = nil
+	sink = NewESSink(server.URL, "mon").Batch(3, 1<<20, 500*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		sink.Run(ctx)
+		close(stopped)
+	}()
+	sink.AddMsgs(testESMsgs(3))
+	time.Sleep(100 * time.Millisecond)
+	server.lock.Lock()
+	if fmt.Sprint(server.bulks) != "[3]" {
+		t.Errorf("full batch: %v", server.bulks)
+	}
+	server.lock.Unlock()
+
+	history := NewAlertHistory(10).Observe(sink.AddAlert)
+	id := history.Add(&AlertRecord{Rule: "rate", User: "alice", CreatedAt: time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)})
+	history.Ack(id, time.Now())
+	time.Sleep(time.Second)
+	server.lock.Lock()
+	doc := server.docs[fmt.Sprintf("mon-alerts-2020.12.01/%d-1", time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC).UnixNano())]
+	server.lock.Unlock()
+	if !strings.Contains(doc, `"acked_at"`) {
+		t.Errorf("alert %s", doc)
+	}
+
+	// The rest is flushed when stopped, kept while the cluster is down
+	server.lock.Lock()
+	server.down = true
+	server.lock.Unlock()
+	sink.AddMsgs(testESMsgs(1))
+	cancel()
+	<-stopped
+	if sink.Pending() != 1 {
+		t.Errorf("%d pending", sink.Pending())
+	}
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	FeedPaginationPage   = "page"
+	FeedPaginationCursor = "cursor"
+	FeedPaginationMaxID  = "max_id"
+
+	FeedDefaultPageSize = 50
+	FeedDefaultInterval = time.Minute
+	// The pages polled at most each time, the older msgs are left to backfill
+	FeedMaxPollPages = 10
+)
+
+var (
+	TheFeedCollectors = newFeedCollectors(config.Config.Feeds)
+)
+
+func newFeedCollectors(feeds []config.Feed) map[string]*FeedCollector {
+	collectors := make(map[string]*FeedCollector)
+	for _, feed := range feeds {
+		c, err := NewFeedCollector(feed)
+		if err != nil {
+			glog.Errorf("failed to create the feed %s, ERR: %v", feed.Name, err)
+			continue
+		}
+		collectors[feed.Name] = c
+	}
+	return collectors
+}
+
+// FeedCollector collects the msgs of a JSON feed by the config
+type FeedCollector struct {
+	feed     config.Feed
+	location *time.Location
+	store    MsgStore
+	filters  []MsgFilter
+
+	lock sync.Mutex
+	// Of cursor and max_id, cursors[i] is the one to fetch page i
+	cursors []string
+}
+
+func NewFeedCollector(feed config.Feed) (*FeedCollector, error) {
+	location := utils.ShanghaiLocation
+	if feed.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(feed.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	if feed.PageSize <= 0 {
+		feed.PageSize = FeedDefaultPageSize
+	}
+	if feed.IntervalSeconds <= 0 {
+		feed.IntervalSeconds = int(FeedDefaultInterval / time.Second)
+	}
+	return &FeedCollector{
+		feed:     feed,
+		location: location,
+		store:    TheMsgStore,
+	}, nil
+}
+
+func (c *FeedCollector) Store(store MsgStore) *FeedCollector {
+	c.store = store
+	return c
+}
+
+func (c *FeedCollector) AddFilter(f MsgFilter) {
+	c.filters = append(c.filters, f)
+}
+
+func (c *FeedCollector) Name() string {
+	return c.feed.Name
+}
+
+func (c *FeedCollector) DefaultPageSize() int {
+	return c.feed.PageSize
+}
+
+// Fetch gets the page, the pages before it are fetched first to know its cursor if not known
+func (c *FeedCollector) Fetch(page, pageSize int) (msgs []*Msg, err error) {
+	if c.feed.Pagination == "" || c.feed.Pagination == FeedPaginationPage {
+		msgs, _, err = c.fetch(strconv.Itoa(c.feed.FirstPage+page), "", pageSize)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Always from the newest
+	if page == 0 || len(c.cursors) == 0 {
+		c.cursors = []string{""}
+	}
+	for len(c.cursors) <= page {
+		last := len(c.cursors) - 1
+		if last > 0 && c.cursors[last] == "" {
+			// No more pages
+			return nil, nil
+		}
+		if _, err = c.fetchCursor(last, pageSize); err != nil {
+			return
+		}
+	}
+	if page > 0 && c.cursors[page] == "" {
+		return nil, nil
+	}
+	return c.fetchCursor(page, pageSize)
+}
+
+// fetchCursor fetches the page of the known cursor and keeps the cursor of the next page
+func (c *FeedCollector) fetchCursor(page, pageSize int) (msgs []*Msg, err error) {
+	msgs, next, err := c.fetch(strconv.Itoa(c.feed.FirstPage+page), c.cursors[page], pageSize)
+	if err != nil {
+		return
+	}
+	if len(c.cursors) == page+1 {
+		c.cursors = append(c.cursors, next)
+	}
+	return
+}
+
+// fetch gets a page, next is the cursor or the max ID of the next page
+func (c *FeedCollector) fetch(page, cursor string, pageSize int) (msgs []*Msg, next string, err error) {
+	uri := strings.NewReplacer(
+		"{page}", page,
+		"{page_size}", strconv.Itoa(pageSize),
+		"{cursor}", url.QueryEscape(cursor),
+		"{max_id}", url.QueryEscape(cursor),
+	).Replace(c.feed.URL)
+
+	rCode, rBody, err := utils.SendRequestWithHeaders(http.MethodGet, uri, nil, c.feed.Headers)
+	if err != nil {
+		return nil, "", err
+	}
+	if rCode != http.StatusOK {
+		return nil, "", fmt.Errorf("feed %s responded %d: %s", c.feed.Name, rCode, rBody)
+	}
+	return c.parse([]byte(rBody))
+}
+
+// parse reads the msgs and the cursor of the next page from the response
+func (c *FeedCollector) parse(body []byte) (msgs []*Msg, next string, err error) {
+	list, _, _, err := jsonparser.Get(body, c.feed.ListPath...)
+	if err != nil {
+		return nil, "", fmt.Errorf("feed %s: failed to get the list %v, ERR: %v", c.feed.Name, c.feed.ListPath, err)
+	}
+
+	parser := utils.NewSourceTimeParser(c.location, time.Now())
+	_, err = jsonparser.ArrayEach(list, func(item []byte, dataType jsonparser.ValueType, offset int, _ error) {
+		msg, pErr := c.parseItem(item, parser)
+		if pErr != nil {
+			glog.Warningf("feed %s: skip the item at %d, ERR: %v", c.feed.Name, offset, pErr)
+			return
+		}
+		msgs = append(msgs, msg)
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("feed %s: the list is not an array, ERR: %v", c.feed.Name, err)
+	}
+
+	switch c.feed.Pagination {
+	case FeedPaginationCursor:
+		next, _ = getString(body, c.feed.CursorPath...)
+	case FeedPaginationMaxID:
+		if len(msgs) > 0 {
+			// The list is the newest first
+			next = msgs[len(msgs)-1].ID
+		}
+	}
+	return msgs, next, nil
+}
+
+func (c *FeedCollector) parseItem(item []byte, parser *utils.SourceTimeParser) (*Msg, error) {
+	var (
+		msg = &Msg{Source: c.feed.Name}
+		err error
+	)
+	if msg.ID, err = getString(item, strings.Split(c.feed.IDField, ".")...); err != nil || msg.ID == "" {
+		return nil, fmt.Errorf("missing id %s", c.feed.IDField)
+	}
+	if msg.Text, err = getString(item, strings.Split(c.feed.TextField, ".")...); err != nil {
+		return nil, fmt.Errorf("missing text %s", c.feed.TextField)
+	}
+	if msg.CreateTime, err = getString(item, strings.Split(c.feed.TimeField, ".")...); err != nil {
+		return nil, fmt.Errorf("missing time %s", c.feed.TimeField)
+	}
+
+	var t time.Time
+	if c.feed.TimeFormat != "" {
+		t, err = time.ParseInLocation(c.feed.TimeFormat, msg.CreateTime, c.location)
+	} else {
+		t, err = parser.Parse(msg.CreateTime)
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg.CreatedAt = t.UTC()
+	return msg.Extract(), nil
+}
+
+// getString gets a string or a number as a string
+func getString(data []byte, keys ...string) (string, error) {
+	value, dataType, _, err := jsonparser.Get(data, keys...)
+	if err != nil {
+		return "", err
+	}
+	switch dataType {
+	case jsonparser.String:
+		return jsonparser.ParseString(value)
+	case jsonparser.Number:
+		return string(value), nil
+	case jsonparser.Null:
+		return "", nil
+	}
+	return "", fmt.Errorf("%v is a %s", keys, dataType)
+}
+
+// Poll collects the new msgs, stores them and applies the filters
+func (c *FeedCollector) Poll() (fresh []*Msg, err error) {
+	fresh, err = Poll(c, c.store, FeedMaxPollPages)
+	Collected(fresh)
+	ApplyFilter(c.filters, fresh, TheAlertRouter.Route)
+	return
+}
+
+// Start polls the feed every interval
+func (c *FeedCollector) Start() {
+	startPolling(c.Name(), time.Duration(c.feed.IntervalSeconds)*time.Second, c.Poll)
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+// feedServer serves 5 items from the newest, by page, cursor or max_id
+func feedServer(t *testing.T) *httptest.Server {
+	var items []string
+	for id := 5; id >= 1; id-- {
+		items = append(items, fmt.Sprintf(`{"ext":{"id":%d},"ts":"2020-12-01T08:0%d:00","body":"快讯 \"%d\""}`, id, id, id))
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var (
+			query    = r.URL.Query()
+			size, _  = strconv.Atoi(query.Get("size"))
+			start    int
+			nextPage string
+		)
+		switch {
+		case query.Has("page"):
+			page, _ := strconv.Atoi(query.Get("page"))
+			start = (page - 1) * size
+		case query.Get("cursor") != "":
+			start, _ = strconv.Atoi(strings.TrimPrefix(query.Get("cursor"), "c"))
+		case query.Get("max_id") != "":
+			maxID, _ := strconv.Atoi(query.Get("max_id"))
+			start = 5 - maxID + 1
+		}
+		start = min(start, len(items))
+		end := min(start+size, len(items))
+		if end < len(items) {
+			nextPage = fmt.Sprintf("c%d", end)
+		}
+		fmt.Fprintf(w, `{"data":{"list":[%s],"next":"%s"}}`, strings.Join(items[start:end], ","), nextPage)
+	}))
+}
+
+func TestFeedCollector(t *testing.T) {
+	server := feedServer(t)
+	defer server.Close()
+
+	base := config.Feed{
+		Name:       "test",
+		Headers:    map[string]string{"X-Token": "secret"},
+		ListPath:   []string{"data", "list"},
+		IDField:    "ext.id",
+		TimeField:  "ts",
+		TextField:  "body",
+		TimeFormat: "2006-01-02T15:04:05",
+	}
+	for _, c := range []struct {
+		pagination, query string
+		firstPage         int
+	}{
+		{FeedPaginationPage, "page={page}&size={page_size}", 1},
+		{FeedPaginationCursor, "cursor={cursor}&size={page_size}", 0},
+		{FeedPaginationMaxID, "max_id={max_id}&size={page_size}", 0},
+	} {
+		feed := base
+		feed.URL = server.URL + "/live?" + c.query
+		feed.Pagination = c.pagination
+		feed.FirstPage = c.firstPage
+		feed.CursorPath = []string{"data", "next"}
+		collector, err := NewFeedCollector(feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Page 1 first, the cursor is found by page 0
+		var ids []string
+		for _, page := range []int{1, 0, 1, 2} {
+			msgs, err := collector.Fetch(page, 2)
+			if err != nil {
+				t.Fatalf("%s page %d: %v", c.pagination, page, err)
+			}
+			for _, msg := range msgs {
+				ids = append(ids, msg.ID)
+			}
+		}
+		if got := strings.Join(ids, ","); got != "3,2,5,4,3,2,1" {
+			t.Errorf("%s: %s", c.pagination, got)
+		}
+	}
+
+	feed := base
+	feed.URL = server.URL + "/live?page={page}&size={page_size}"
+	feed.FirstPage = 1
+	collector, _ := NewFeedCollector(feed)
+	msgs, err := collector.Fetch(0, 1)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("%v, %v", msgs, err)
+	}
+	if msg := msgs[0]; msg.Source != "test" || msg.Text != `快讯 "5"` || !msg.CreatedAt.Equal(time.Date(2020, 12, 1, 0, 5, 0, 0, time.UTC)) {
+		t.Errorf("msg %+v", msg)
+	}
+
+	feed.Headers = nil
+	collector, _ = NewFeedCollector(feed)
+	if _, err = collector.Fetch(0, 1); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expect 401, got %v", err)
+	}
+}
+
+func TestFeedCollectorPoll(t *testing.T) {
+	server := feedServer(t)
+	defer server.Close()
+
+	var (
+		store        = NewFileMsgStore(t.TempDir())
+		collector, _ = NewFeedCollector(config.Feed{
+			Name:       "test",
+			URL:        server.URL + "/live?cursor={cursor}&size={page_size}",
+			Headers:    map[string]string{"X-Token": "secret"},
+			ListPath:   []string{"data", "list"},
+			IDField:    "ext.id",
+			TimeField:  "ts",
+			TextField:  "body",
+			TimeFormat: "2006-01-02T15:04:05",
+			Pagination: FeedPaginationCursor,
+			CursorPath: []string{"data", "next"},
+			PageSize:   2,
+		})
+	)
+	collector.Store(store)
+	if err := store.Save([]*Msg{{Source: "test", ID: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, err := Poll(collector, store, FeedMaxPollPages)
+	if err != nil || len(fresh) != 4 {
+		t.Fatalf("%d fresh, %v", len(fresh), err)
+	}
+	// Stops at the first page without new msgs
+	if fresh, _ = Poll(collector, store, FeedMaxPollPages); len(fresh) != 0 {
+		t.Errorf("%d fresh again", len(fresh))
+	}
+}
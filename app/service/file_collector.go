@@ -0,0 +1,361 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	FileDefaultPattern  = "*.jsonl"
+	FileDefaultInterval = 10 * time.Second
+)
+
+var (
+	TheFileCollectors = newFileCollectors(config.Config.FileSources)
+)
+
+func newFileCollectors(sources []config.FileSource) map[string]*FileCollector {
+	collectors := make(map[string]*FileCollector)
+	for _, source := range sources {
+		collectors[source.Name] = NewFileCollector(source)
+	}
+	return collectors
+}
+
+// fileState tells if a file in the directory is changed since read
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// FileCollector collects the msgs of the exported format by tailing a file of JSON lines,
+// or reading the new files of a directory
+type FileCollector struct {
+	source  config.FileSource
+	store   MsgStore
+	filters []MsgFilter
+	now     func() time.Time
+
+	lock    sync.Mutex
+	started bool
+	// Of the tailed file
+	tailed os.FileInfo
+	offset int64
+	// Of the directory
+	read map[string]fileState
+}
+
+func NewFileCollector(source config.FileSource) *FileCollector {
+	if source.Pattern == "" {
+		source.Pattern = FileDefaultPattern
+	}
+	if source.IntervalSeconds <= 0 {
+		source.IntervalSeconds = int(FileDefaultInterval / time.Second)
+	}
+	source.Path = filepath.Clean(source.Path)
+	return &FileCollector{
+		source: source,
+		store:  TheMsgStore,
+		now:    time.Now,
+		read:   make(map[string]fileState),
+	}
+}
+
+func (c *FileCollector) Store(store MsgStore) *FileCollector {
+	c.store = store
+	return c
+}
+
+func (c *FileCollector) AddFilter(f MsgFilter) {
+	c.filters = append(c.filters, f)
+}
+
+func (c *FileCollector) Name() string {
+	return c.source.Name
+}
+
+// DefaultPageSize is 0 as all the msgs are a single page
+func (c *FileCollector) DefaultPageSize() int {
+	return 0
+}
+
+// Fetch reads all the msgs of the file or the directory as page 0, the newest first
+func (c *FileCollector) Fetch(page, pageSize int) (msgs []*Msg, err error) {
+	if page > 0 {
+		return nil, nil
+	}
+	info, err := os.Stat(c.source.Path)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		var data []byte
+		if data, err = os.ReadFile(c.source.Path); err != nil {
+			return
+		}
+		msgs = c.decode(data, c.source.Path)
+	} else {
+		var files []string
+		if files, err = c.files(); err != nil {
+			return
+		}
+		for _, file := range files {
+			data, rErr := os.ReadFile(file)
+			if rErr != nil {
+				glog.Warningf("file %s: skip %s, ERR: %v", c.Name(), file, rErr)
+				continue
+			}
+			msgs = append(msgs, c.decode(data, file)...)
+		}
+	}
+
+	sort.Stable(Msgs(msgs))
+	if pageSize > 0 && len(msgs) > pageSize {
+		msgs = msgs[:pageSize]
+	}
+	return
+}
+
+// files are the ones of the pattern in the directory by name, but the hidden ones being written
+func (c *FileCollector) files() (files []string, err error) {
+	entries, err := os.ReadDir(c.source.Path)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !c.matched(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(c.source.Path, entry.Name()))
+	}
+	return
+}
+
+func (c *FileCollector) matched(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	matched, _ := filepath.Match(c.source.Pattern, name)
+	return matched
+}
+
+// decode reads a JSON array, or JSON lines which may be a single msg, the invalid lines are skipped
+func (c *FileCollector) decode(data []byte, file string) (msgs []*Msg) {
+	var items []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			glog.Warningf("file %s: skip %s, ERR: %v", c.Name(), file, err)
+			return nil
+		}
+	} else if json.Valid(trimmed) {
+		// A msg of multiple lines
+		items = append(items, trimmed)
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				items = append(items, append(json.RawMessage(nil), line...))
+			}
+		}
+	}
+
+	for idx, item := range items {
+		msg, err := c.parse(item)
+		if err != nil {
+			glog.Warningf("file %s: skip the msg %d of %s, ERR: %v", c.Name(), idx, file, err)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return
+}
+
+// parse reads a msg of the exported format, the source is the collector's if not given
+func (c *FileCollector) parse(item []byte) (*Msg, error) {
+	msg := &Msg{}
+	if err := json.Unmarshal(item, msg); err != nil {
+		return nil, err
+	}
+	if msg.Source == "" {
+		msg.Source = c.source.Name
+	}
+	if msg.ID = strings.TrimSpace(msg.ID); msg.ID == "" {
+		return nil, fmt.Errorf("missing id")
+	}
+	if strings.TrimSpace(msg.Text) == "" {
+		return nil, fmt.Errorf("missing content of %s", msg.ID)
+	}
+	if msg.CreatedAt.IsZero() {
+		t, err := ParseRSSTime(strings.TrimSpace(msg.CreateTime))
+		if err != nil {
+			glog.V(4).Infof("file %s: use the read time of msg %s, ERR: %v", c.Name(), msg.ID, err)
+			t = c.now()
+		}
+		msg.CreatedAt = t
+	}
+	msg.CreatedAt = msg.CreatedAt.UTC()
+	if msg.Rating == nil && msg.Symbols == nil {
+		msg.Extract()
+	}
+	return msg, nil
+}
+
+// Poll reads the new lines of the file or the new files of the directory,
+// stores the new msgs and applies the filters
+func (c *FileCollector) Poll() (fresh []*Msg, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	info, err := os.Stat(c.source.Path)
+	if os.IsNotExist(err) {
+		// Rotated, or not created yet
+		c.tailed, c.offset, c.started = nil, 0, true
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	var msgs []*Msg
+	if info.IsDir() {
+		msgs, err = c.pollDir()
+	} else {
+		msgs, err = c.pollFile(info)
+	}
+	c.started = true
+	if err != nil || len(msgs) == 0 {
+		return
+	}
+	return CollectFresh(c.store, c.filters, msgs)
+}
+
+// pollFile reads the complete lines after the offset, from the start if the file is truncated or replaced
+func (c *FileCollector) pollFile(info os.FileInfo) (msgs []*Msg, err error) {
+	if c.tailed == nil || !os.SameFile(c.tailed, info) || info.Size() < c.offset {
+		c.offset = 0
+		if !c.started && c.source.FromEnd {
+			c.offset = info.Size()
+		}
+	}
+	c.tailed = info
+	if info.Size() == c.offset {
+		return
+	}
+
+	f, err := os.Open(c.source.Path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.NewSectionReader(f, c.offset, info.Size()-c.offset))
+	if err != nil {
+		return
+	}
+	// The last line may be being written
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return
+	}
+	c.offset += int64(end + 1)
+	return c.decode(data[:end+1], c.source.Path), nil
+}
+
+// pollDir reads the files new or changed since the last time
+func (c *FileCollector) pollDir() (msgs []*Msg, err error) {
+	files, err := c.files()
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		info, sErr := os.Stat(file)
+		if sErr != nil {
+			continue
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if read, hit := c.read[file]; hit && read == state {
+			continue
+		}
+		c.read[file] = state
+		if !c.started && c.source.FromEnd {
+			continue
+		}
+
+		data, rErr := os.ReadFile(file)
+		if rErr != nil {
+			glog.Warningf("file %s: skip %s, ERR: %v", c.Name(), file, rErr)
+			continue
+		}
+		msgs = append(msgs, c.decode(data, file)...)
+	}
+	return
+}
+
+func (c *FileCollector) Start() {
+	c.Run(context.Background())
+}
+
+// Run polls on the file system events and every interval until ctx is done
+func (c *FileCollector) Run(ctx context.Context) {
+	poll := func() {
+		fresh, err := c.Poll()
+		if err != nil {
+			glog.Errorf("file %s: failed to poll, ERR: %v", c.Name(), err)
+		} else if len(fresh) > 0 {
+			glog.V(4).Infof("FILE %s: %d FRESH MSGS", c.Name(), len(fresh))
+		}
+	}
+
+	// The parent directory is watched to follow the rotated file
+	var (
+		dir    = c.source.Path
+		events <-chan fsnotify.Event
+	)
+	if info, err := os.Stat(c.source.Path); err != nil || !info.IsDir() {
+		dir = filepath.Dir(c.source.Path)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(dir)
+	}
+	if err != nil {
+		glog.Warningf("file %s: failed to watch %s, poll every interval only, ERR: %v", c.Name(), dir, err)
+	} else {
+		events = watcher.Events
+	}
+
+	ticker := time.NewTicker(time.Duration(c.source.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	poll()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			poll()
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if event.Name == c.source.Path || (dir == c.source.Path && c.matched(filepath.Base(event.Name))) {
+				poll()
+			}
+		}
+	}
+}
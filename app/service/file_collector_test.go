@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func testFileLine(id int) string {
+	return fmt.Sprintf(`{"id":"%d","created_at":"2020-12-01T00:0%d:00Z","content":"快讯 %d"}`+"\n", id, id, id)
+}
+
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileCollectorTail(t *testing.T) {
+	var (
+		dir       = t.TempDir()
+		path      = filepath.Join(dir, "capture.jsonl")
+		store     = NewFileMsgStore(t.TempDir())
+		collector = NewFileCollector(config.FileSource{Name: "capture", Path: path}).Store(store)
+		poll      = func(expected int) {
+			t.Helper()
+			if fresh, err := collector.Poll(); err != nil || len(fresh) != expected {
+				t.Fatalf("%d fresh, expect %d, %v", len(fresh), expected, err)
+			}
+		}
+	)
+
+	// Not created yet
+	poll(0)
+	appendFile(t, path, testFileLine(1)+"not json\n"+`{"source":"futu","id":"9","content":"other source"}`+"\n")
+	poll(2)
+	if msg, err := store.Get("capture", "1"); err != nil || !msg.CreatedAt.Equal(time.Date(2020, 12, 1, 0, 1, 0, 0, time.UTC)) {
+		t.Errorf("%+v, %v", msg, err)
+	}
+	if _, err := store.Get(FutuSourceName, "9"); err != nil {
+		t.Error(err)
+	}
+
+	// The last line is being written
+	line := testFileLine(2)
+	appendFile(t, path, line[:10])
+	poll(0)
+	appendFile(t, path, line[10:])
+	poll(1)
+
+	// Rotated, the lines of the new file are read from the start
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	poll(0)
+	appendFile(t, path, testFileLine(2)+testFileLine(3))
+	poll(1)
+
+	// Truncated
+	if err := os.WriteFile(path, []byte(testFileLine(4)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	poll(1)
+
+	msgs, err := collector.Fetch(0, 0)
+	if err != nil || len(msgs) != 1 || msgs[0].ID != "4" {
+		t.Errorf("%v, %v", msgs, err)
+	}
+
+	// The existing lines are skipped
+	collector = NewFileCollector(config.FileSource{Name: "capture", Path: path, FromEnd: true}).Store(NewFileMsgStore(t.TempDir()))
+	poll(0)
+	appendFile(t, path, testFileLine(5))
+	poll(1)
+}
+
+func TestFileCollectorDir(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		store = NewFileMsgStore(t.TempDir())
+		// Moved into the directory when completed
+		write = func(name, data string) {
+			tmp := filepath.Join(dir, "."+name)
+			if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	)
+	write("old.jsonl", testFileLine(1))
+
+	collector := NewFileCollector(config.FileSource{Name: "drop", Path: dir, Pattern: "*.json*", FromEnd: true, IntervalSeconds: 60}).Store(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go collector.Run(ctx)
+
+	// Wait for the first poll skipping the existing file
+	time.Sleep(200 * time.Millisecond)
+	write("batch.jsonl", testFileLine(2)+testFileLine(3))
+	write("array.json", "[\n"+testFileLine(4)+"]")
+	write("msg.json", "{\n  \"id\": \"5\",\n  \"create_time_str\": \"2020-12-01 08:05:00\",\n  \"content\": \"快讯 5\"\n}")
+	write("ignored.txt", testFileLine(6))
+
+	// Only by the file system events as the interval is long
+	waitStored(t, store, "drop", 4)
+	for _, id := range []string{"1", "6"} {
+		if _, err := store.Get("drop", id); err != ErrMsgNotFound {
+			t.Errorf("msg %s: %v", id, err)
+		}
+	}
+	if msg, err := store.Get("drop", "5"); err != nil || !msg.CreatedAt.Equal(time.Date(2020, 12, 1, 0, 5, 0, 0, time.UTC)) {
+		t.Errorf("%+v, %v", msg, err)
+	}
+
+	msgs, err := collector.Fetch(0, 2)
+	if err != nil || len(msgs) != 2 || msgs[0].ID != "5" {
+		t.Errorf("%v, %v", msgs, err)
+	}
+}
@@ -1,25 +1,30 @@
 package service
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"github.com/buger/jsonparser"
 	"github.com/golang/glog"
 	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
 	"net/http"
 	"os"
 	"sort"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 )
 
 const (
+	FutuSourceName           = "futu"
 	FutuBaseURL              = "https://news.futunn.com/main/live-list?page=%d&page_size=%d"
 	FutuDefaultPageSize      = 50
 	FutuDefaultInitMsgNum    = 10000
 	FutuDefaultLoadInterval  = 1 * time.Minute
 	TheFutuCollectorFileName = "TheFutuCollector.data.test"
+	// The pages loaded at most in a round, the window may drop the old pages before reaching the init msg num
+	FutuMaxLoadPages = FutuDefaultInitMsgNum / FutuDefaultPageSize
 )
 
 // If do not look back, just check the new message
@@ -30,79 +35,63 @@ var (
 		InitMsgNum(FutuDefaultPageSize)
 )
 
-type FutuMsgFilter interface {
-	Match(msg *FutuMsg) bool
-	Alert(msg *FutuMsg) error
-}
-
-type RateFutuMsgFilter struct {
-}
-
-func NewRateFutuMsgFilter() RateFutuMsgFilter {
-	return RateFutuMsgFilter{}
-}
-
-func (r RateFutuMsgFilter) Match(msg *FutuMsg) bool {
-	if strings.Contains(msg.RichText, "目标价") && strings.Contains(msg.RichText, "评级") {
-		glog.V(4).Infof("MATCH RULE MSG: %+v\n", msg)
-		return true
-	}
-	return false
-}
-
-// just used to dedup before fixing the duplicate alert issue
-var (
-	previousMsg *FutuMsg
-)
-
-func (r RateFutuMsgFilter) Alert(msg *FutuMsg) error {
-	glog.V(4).Infof("ALERT MSG: %+v\n", msg)
-	if previousMsg != nil && previousMsg.RichText == msg.RichText {
-		glog.Warningf("Same as previous alert %+v, current: %+v,skip", previousMsg, msg)
-		return nil
-	}
-	previousMsg = msg
-	return utils.SendAlertV2(fmt.Sprintf("Rate "+msg.CreateTime), msg.RichText)
+type FutuMsg struct {
+	CommentID int64 `json:"idx"`
+	// The original string from Futu, time only for the messages of the day
+	CreateTime string `json:"create_time_str"`
+	// UTC
+	CreatedAt time.Time `json:"created_at"`
+	RichText  string    `json:"content"`
 }
 
-type TestFutuMsgFilter struct {
+func (s *FutuMsg) ID() int64 {
+	return s.CommentID
 }
 
-func NewTestFutuMsgFilter() TestFutuMsgFilter {
-	return TestFutuMsgFilter{}
+func (s *FutuMsg) ToMsg() *Msg {
+	return (&Msg{
+		Source:     FutuSourceName,
+		ID:         strconv.FormatInt(s.CommentID, 10),
+		CreateTime: s.CreateTime,
+		CreatedAt:  s.CreatedAt,
+		Text:       s.RichText,
+	}).Extract()
 }
 
-func (r TestFutuMsgFilter) Match(msg *FutuMsg) bool {
-	if strings.Contains(msg.RichText, "的") {
-		glog.V(4).Infof("MATCH RULE MSG: %+v\n", msg)
-		return true
+func NewFutuMsgFromMsg(msg *Msg) (*FutuMsg, error) {
+	id, err := strconv.ParseInt(msg.ID, 10, 64)
+	if err != nil {
+		return nil, err
 	}
-	return false
-}
-
-func (r TestFutuMsgFilter) Alert(msg *FutuMsg) error {
-	return utils.SendAlertV2(fmt.Sprintf("Test "+msg.CreateTime), msg.RichText)
+	return &FutuMsg{
+		CommentID:  id,
+		CreateTime: msg.CreateTime,
+		CreatedAt:  msg.CreatedAt,
+		RichText:   msg.Text,
+	}, nil
 }
 
-type FutuMsg struct {
-	CommentID  int64  `json:"idx"`
-	CreateTime string `json:"create_time_str"`
-	RichText   string `json:"content"`
+// LocalTime is the create time in the zone of Futu
+func (s *FutuMsg) LocalTime() string {
+	return utils.FormatSourceTime(s.CreatedAt)
 }
 
-func (s *FutuMsg) ID() int64 {
-	return s.CommentID
-}
+type FutuMsgs []*FutuMsg
 
-func (s *FutuMsg) AutoMigrate() {
-	if strings.Contains(s.CreateTime, "-") {
-		return
+// ResolveTime parses the create time of the descend msgs, reference is the time of the msg just newer than the list
+func (s FutuMsgs) ResolveTime(reference time.Time) time.Time {
+	parser := utils.NewSourceTimeParser(utils.ShanghaiLocation, reference)
+	for _, msg := range s {
+		t, err := parser.Parse(msg.CreateTime)
+		if err != nil {
+			glog.Warningf("failed to parse the create time of msg %d, ERR: %v", msg.CommentID, err)
+			continue
+		}
+		msg.CreatedAt = t.UTC()
 	}
-	s.CreateTime = time.Now().Format("2006-01-02") + " " + s.CreateTime
+	return parser.Reference()
 }
 
-type FutuMsgs []*FutuMsg
-
 func (s FutuMsgs) Len() int {
 	return len(s)
 }
@@ -123,18 +112,38 @@ type FutuCollector struct {
 	lookBack bool
 
 	msgLock *sync.RWMutex
-	Msgs    FutuMsgs
+	// The latest msgs, the older ones are in the store
+	Msgs  *utils.Window[int64, *FutuMsg]
+	store MsgStore
 
-	filters []FutuMsgFilter
+	filters []MsgFilter
 }
 
 func NewFutuCollector(fileName string) *FutuCollector {
 	return &FutuCollector{
 		fileName: fileName,
 		msgLock:  &sync.RWMutex{},
+		Msgs:     newFutuMsgWindow(config.Config.Window.MaxCount, time.Duration(config.Config.Window.MaxAgeHours)*time.Hour),
+		store:    TheMsgStore,
 	}
 }
 
+func newFutuMsgWindow(maxCount int, maxAge time.Duration) *utils.Window[int64, *FutuMsg] {
+	return utils.NewWindow(maxCount, (*FutuMsg).ID, cmp.Compare[int64]).
+		MaxAge(maxAge, func(msg *FutuMsg) time.Time { return msg.CreatedAt })
+}
+
+// Window replaces the in-memory window, the msgs in it are dropped
+func (c *FutuCollector) Window(maxCount int, maxAge time.Duration) *FutuCollector {
+	c.Msgs = newFutuMsgWindow(maxCount, maxAge)
+	return c
+}
+
+func (c *FutuCollector) Store(store MsgStore) *FutuCollector {
+	c.store = store
+	return c
+}
+
 func (c *FutuCollector) InitMsgNum(initMsgNum int) *FutuCollector {
 	c.initMsgNum = initMsgNum
 	return c
@@ -145,11 +154,27 @@ func (c *FutuCollector) LookBack(lookBack bool) *FutuCollector {
 	return c
 }
 
+func (c *FutuCollector) Name() string {
+	return FutuSourceName
+}
+
+func (c *FutuCollector) DefaultPageSize() int {
+	return FutuDefaultPageSize
+}
+
+func (c *FutuCollector) Fetch(page, pageSize int) (msgs []*Msg, err error) {
+	futuMsgs, _, err := c.GetMsgs(page, pageSize, time.Now())
+	for _, msg := range futuMsgs {
+		msgs = append(msgs, msg.ToMsg())
+	}
+	return
+}
+
 func (c *FutuCollector) Start() (err error) {
 	return c.Process()
 }
 
-func (c *FutuCollector) AddFilter(f FutuMsgFilter) {
+func (c *FutuCollector) AddFilter(f MsgFilter) {
 	c.filters = append(c.filters, f)
 }
 
@@ -217,18 +242,33 @@ func checkDuplicate(msgs FutuMsgs) bool {
 func (c *FutuCollector) Validation() (result bool) {
 	c.msgLock.RLock()
 	defer c.msgLock.RUnlock()
-	return checkDuplicate(c.Msgs)
+	return checkDuplicate(c.Msgs.Items())
+}
+
+// GetMsg reads the window first, then the store
+func (c *FutuCollector) GetMsg(id int64) (*FutuMsg, error) {
+	c.msgLock.RLock()
+	msg, hit := c.Msgs.Get(id)
+	c.msgLock.RUnlock()
+	if hit {
+		return msg, nil
+	}
+
+	stored, err := c.store.Get(FutuSourceName, strconv.FormatInt(id, 10))
+	if err != nil {
+		return nil, err
+	}
+	return NewFutuMsgFromMsg(stored)
 }
 
 func (c *FutuCollector) SaveToFile() (err error) {
+	c.msgLock.RLock()
 	data, _ := json.Marshal(c)
+	c.msgLock.RUnlock()
 	return utils.SaveToFile(c.fileName, data)
 }
 
 func (c *FutuCollector) LoadFromFile() (err error) {
-	// We do not want to save & load again
-	return
-
 	data, err := utils.ReadFromFile(c.fileName)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -237,24 +277,41 @@ func (c *FutuCollector) LoadFromFile() (err error) {
 		}
 		return
 	}
-	err = json.Unmarshal(data, &c)
+	var saved struct {
+		Msgs FutuMsgs
+	}
+	err = json.Unmarshal(data, &saved)
 	if err != nil {
 		return
 	}
-	sort.Sort(c.Msgs)
-	glog.V(4).Infof("LoadFromFile: TOTAL %d MSGS\n", len(c.Msgs))
+	sort.Sort(saved.Msgs)
+	for _, msg := range saved.Msgs {
+		// The files saved before we keep the UTC time
+		if msg.CreatedAt.IsZero() {
+			FutuMsgs{msg}.ResolveTime(time.Now())
+		}
+	}
+
+	c.msgLock.Lock()
+	fresh := c.Msgs.Merge(saved.Msgs)
+	c.msgLock.Unlock()
+
+	err = c.saveToStore(c.unstored(fresh))
+	glog.V(4).Infof("LoadFromFile: TOTAL %d MSGS\n", len(saved.Msgs))
 	return
 }
 
-func (c *FutuCollector) GetMsgs(page, pageSize int) (msgs []*FutuMsg, err error) {
+// GetMsgs gets a page of msgs, reference is the time resolved of the previous page, next is the one of this page
+func (c *FutuCollector) GetMsgs(page, pageSize int, reference time.Time) (msgs []*FutuMsg, next time.Time, err error) {
 	var (
 		url = fmt.Sprintf(FutuBaseURL, page, pageSize)
 	)
+	next = reference
 
 	rCode, rBody, rErr := utils.SendRequest(http.MethodGet, url, nil)
 	if rErr != nil {
 		glog.V(4).Infof("HTTP ERROR: %v, CODE: %d, BODY: %s\n", rErr, rCode, rBody)
-		return
+		return nil, next, rErr
 	}
 
 	msgSource, _, _, err := jsonparser.Get([]byte(rBody), "data", "list")
@@ -269,36 +326,44 @@ func (c *FutuCollector) GetMsgs(page, pageSize int) (msgs []*FutuMsg, err error)
 		return
 	}
 
-	for _, msg := range msgs {
-		//glog.V(4).Infof("IDX: %d, MSG: %+v\n", idx, msg)
-		msg.AutoMigrate()
-	}
+	next = FutuMsgs(msgs).ResolveTime(reference)
 
-	return msgs, err
+	return msgs, next, err
 }
 
 func (c *FutuCollector) MergeMsgs(sourceMsgs, newMsgs []*FutuMsg) (lastMsgs []*FutuMsg) {
 	// We know the msg list are descend
+	return utils.MergeDescendObjects(sourceMsgs, newMsgs)
+}
 
-	var (
-		sourceObjects, newObjects []utils.ToMergeObject
-	)
-
-	for _, msg := range sourceMsgs {
-		sourceObjects = append(sourceObjects, msg)
+// unstored are the msgs not in the store, the window misses the stored ones after restarting
+func (c *FutuCollector) unstored(msgs []*FutuMsg) (result []*FutuMsg) {
+	for _, msg := range msgs {
+		if _, err := c.store.Get(FutuSourceName, strconv.FormatInt(msg.CommentID, 10)); err == ErrMsgNotFound {
+			result = append(result, msg)
+		}
 	}
+	return
+}
 
-	for _, msg := range newMsgs {
-		newObjects = append(newObjects, msg)
+func (c *FutuCollector) saveToStore(msgs []*FutuMsg) error {
+	if len(msgs) == 0 {
+		return nil
 	}
-
-	lastObjects := utils.MergeDescendObjects(sourceObjects, newObjects)
-
-	for _, msg := range lastObjects {
-		lastMsgs = append(lastMsgs, msg.(*FutuMsg))
+	toSave := make([]*Msg, 0, len(msgs))
+	for _, msg := range msgs {
+		toSave = append(toSave, msg.ToMsg())
+	}
+	if err := c.store.Save(toSave); err != nil {
+		return err
 	}
+	Collected(toSave)
+	return nil
+}
 
-	return
+// AnalysisDate is the date bucket of the msgs, in the zone of the sources
+func AnalysisDate(createdAt time.Time) string {
+	return createdAt.In(utils.ShanghaiLocation).Format("2006-01-02")
 }
 
 func (c *FutuCollector) Analysis() {
@@ -307,11 +372,12 @@ func (c *FutuCollector) Analysis() {
 		dateMap        = make(map[string][]*FutuMsg)
 	)
 	c.msgLock.RLock()
-	msgsToAnalysis = c.Msgs
+	msgsToAnalysis = c.Msgs.Items()
 	c.msgLock.RUnlock()
 
 	for _, msg := range msgsToAnalysis {
-		dateMap[strings.Fields(msg.CreateTime)[0]] = append(dateMap[strings.Fields(msg.CreateTime)[0]], msg)
+		date := AnalysisDate(msg.CreatedAt)
+		dateMap[date] = append(dateMap[date], msg)
 	}
 
 	for key, value := range dateMap {
@@ -320,60 +386,60 @@ func (c *FutuCollector) Analysis() {
 }
 
 func (c *FutuCollector) ApplyFilter(msgsToAnalysis []*FutuMsg) {
+	msgs := make([]*Msg, 0, len(msgsToAnalysis))
 	for _, msg := range msgsToAnalysis {
-		glog.V(8).Infof("ApplyFilter CHECKING: %+v", msg)
-		for _, theFilter := range c.filters {
-			if theFilter.Match(msg) {
-				theFilter.Alert(msg)
-			}
-		}
+		msgs = append(msgs, msg.ToMsg())
 	}
+	ApplyFilter(c.filters, msgs, TheAlertRouter.Route)
 }
 
 func (c *FutuCollector) Load() (err error) {
 	var (
-		i              = 0
-		msgsBeforeLoad []*FutuMsg
+		i         = 0
+		reference = time.Now()
 	)
 
 	c.msgLock.RLock()
-	msgsBeforeLoad = c.Msgs
-	c.msgLock.RUnlock()
-
 	var (
-		initial = len(msgsBeforeLoad) == 0
+		initial = c.Msgs.Len() == 0
 	)
+	c.msgLock.RUnlock()
 
 	for {
-		msgsThisRound, err := c.GetMsgs(i, FutuDefaultPageSize)
+		// The time of the msgs failed to parse is zero, so the next page goes on from the last one resolved
+		msgsThisRound, next, err := c.GetMsgs(i, FutuDefaultPageSize, reference)
 		if err != nil {
 			return err
 		}
-		msgsLengthBeforeMerge := len(msgsBeforeLoad)
-		msgsBeforeLoad = c.MergeMsgs(msgsBeforeLoad, msgsThisRound)
-		//if !checkDuplicate(msgsBeforeLoad) {
-		//	glog.V(4).Infof("FATAL, WE HAVE DUPLICATE RECORD\n")
-		//	return nil
-		//}
-		msgsLengthAfterMerge := len(msgsBeforeLoad)
-
-		glog.V(8).Infof("ApplyFilter checking [:%d]", msgsLengthAfterMerge-msgsLengthBeforeMerge)
-		c.ApplyFilter(msgsBeforeLoad[:msgsLengthAfterMerge-msgsLengthBeforeMerge])
+		reference = next
 
 		c.msgLock.Lock()
-		c.Msgs = msgsBeforeLoad
-		glog.V(4).Infof("Load more data, current: %d\n", msgsLengthAfterMerge)
+		freshMsgs := c.Msgs.Merge(msgsThisRound)
+		current := c.Msgs.Len()
 		c.msgLock.Unlock()
 
-		glog.V(4).Infof("INIT: %v, CURRENT: %d, BEFORE: %d", initial, msgsLengthAfterMerge, msgsLengthBeforeMerge)
+		unstored := c.unstored(freshMsgs)
+		if err := c.saveToStore(unstored); err != nil {
+			glog.Errorf("failed to save %d msgs to the store, ERR: %v", len(unstored), err)
+		}
+
+		glog.V(8).Infof("ApplyFilter checking %d fresh msgs", len(unstored))
+		c.ApplyFilter(unstored)
+
+		glog.V(4).Infof("INIT: %v, CURRENT: %d, FRESH: %d", initial, current, len(freshMsgs))
+
+		if initial && current >= c.initMsgNum {
+			glog.V(4).Infof("Reach the max init msg num, initMsgNum: %d, current: %d", c.initMsgNum, current)
+			break
+		}
 
-		if initial && msgsLengthAfterMerge >= c.initMsgNum {
-			glog.V(4).Infof("Reach the max init msg num, initMsgNum: %d, current: %d", c.initMsgNum, msgsLengthAfterMerge)
+		if !initial && len(freshMsgs) < FutuDefaultPageSize {
+			glog.V(4).Infof("Catch up the msgs, current: %d, fresh: %d", current, len(freshMsgs))
 			break
 		}
 
-		if !initial && msgsLengthAfterMerge-msgsLengthBeforeMerge < FutuDefaultPageSize {
-			glog.V(4).Infof("Catch up the msgs, current: %d, previous: %d", msgsLengthAfterMerge, msgsLengthBeforeMerge)
+		if i+1 >= FutuMaxLoadPages {
+			glog.Warningf("Reach the max load pages: %d, current: %d", FutuMaxLoadPages, current)
 			break
 		}
 
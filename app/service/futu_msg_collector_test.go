@@ -3,6 +3,9 @@ package service
 import (
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/app/utils"
 )
 
 func TestFutuCollector_Load(t *testing.T) {
@@ -39,7 +42,7 @@ func TestTheFutuCollectorInit(t *testing.T) {
 
 	TheFutuCollector.Start()
 
-	fmt.Printf("TOTAL %d MSGS\n", len(TheFutuCollector.Msgs))
+	fmt.Printf("TOTAL %d MSGS\n", TheFutuCollector.Msgs.Len())
 
 	err = TheFutuCollector.Load()
 	if err != nil {
@@ -47,7 +50,7 @@ func TestTheFutuCollectorInit(t *testing.T) {
 		return
 	}
 
-	fmt.Printf("TOTAL %d MSGS\n", len(TheFutuCollector.Msgs))
+	fmt.Printf("TOTAL %d MSGS\n", TheFutuCollector.Msgs.Len())
 
 	<-make(chan struct{}, 1)
 
@@ -65,7 +68,7 @@ func TestTheFutuCollectorInit(t *testing.T) {
 }
 
 func TestTheFutuCollectorKeepRefresh(t *testing.T) {
-	TheFutuCollector.AddFilter(NewRateFutuMsgFilter())
+	TheFutuCollector.AddFilter(NewRateMsgFilter())
 	err := TheFutuCollector.Start()
 	if err != nil {
 		fmt.Printf("ERR: %v\n", err)
@@ -86,3 +89,33 @@ func TestTheFutuCollectorKeepRefresh(t *testing.T) {
 	//}
 	<-make(chan struct{}, 1)
 }
+
+func TestFutuMsgsResolveTime(t *testing.T) {
+	var (
+		now  = time.Date(2020, 12, 3, 0, 1, 0, 0, utils.ShanghaiLocation)
+		msgs = FutuMsgs{
+			{CommentID: 3, CreateTime: "00:00"},
+			{CommentID: 2, CreateTime: "23:58"},
+			{CommentID: 1, CreateTime: "2020-12-02 23:50:00"},
+		}
+	)
+
+	msgs.ResolveTime(now)
+
+	for idx, expected := range []string{"2020-12-03 00:00:00", "2020-12-02 23:58:00", "2020-12-02 23:50:00"} {
+		if msgs[idx].LocalTime() != expected {
+			t.Errorf("MSG %d, expected %s, got %s", msgs[idx].CommentID, expected, msgs[idx].LocalTime())
+		}
+		if msgs[idx].CreatedAt.Location() != time.UTC {
+			t.Errorf("MSG %d is not stored in UTC", msgs[idx].CommentID)
+		}
+	}
+	if msgs[1].CreateTime != "23:58" {
+		t.Errorf("the original string is changed: %s", msgs[1].CreateTime)
+	}
+	// The next page goes on from the last msg resolved
+	next := FutuMsgs{{CommentID: 4, CreateTime: "23:30"}, {CommentID: 5, CreateTime: "broken"}}.ResolveTime(now)
+	if utils.FormatSourceTime(next) != "2020-12-02 23:30:00" {
+		t.Errorf("unexpected reference of the next page: %s", next)
+	}
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+const (
+	HTMLDefaultInterval = 5 * time.Minute
+)
+
+var (
+	TheHTMLCollectors = newHTMLCollectors(config.Config.HTMLPages)
+)
+
+func newHTMLCollectors(pages []config.HTMLPage) map[string]*HTMLCollector {
+	collectors := make(map[string]*HTMLCollector)
+	for _, page := range pages {
+		c, err := NewHTMLCollector(page)
+		if err != nil {
+			glog.Errorf("failed to create the html page %s, ERR: %v", page.Name, err)
+			continue
+		}
+		collectors[page.Name] = c
+	}
+	return collectors
+}
+
+// HTMLCollector scrapes the items of an HTML page by the CSS selectors, the unchanged page is not parsed again
+type HTMLCollector struct {
+	page     config.HTMLPage
+	base     *url.URL
+	location *time.Location
+	encoding encoding.Encoding
+	store    MsgStore
+	filters  []MsgFilter
+	now      func() time.Time
+
+	lock sync.Mutex
+	// Of the page collected last time
+	etag         string
+	lastModified string
+	digest       string
+}
+
+func NewHTMLCollector(page config.HTMLPage) (*HTMLCollector, error) {
+	c := &HTMLCollector{
+		page:     page,
+		location: utils.ShanghaiLocation,
+		store:    TheMsgStore,
+		now:      time.Now,
+	}
+	var err error
+	if c.base, err = url.Parse(page.URL); err != nil {
+		return nil, err
+	}
+	if page.Timezone != "" {
+		if c.location, err = time.LoadLocation(page.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	if page.Charset != "" {
+		if c.encoding, _ = charset.Lookup(page.Charset); c.encoding == nil {
+			return nil, fmt.Errorf("unknown charset %s", page.Charset)
+		}
+	}
+	if c.page.IntervalSeconds <= 0 {
+		c.page.IntervalSeconds = int(HTMLDefaultInterval / time.Second)
+	}
+	return c, nil
+}
+
+func (c *HTMLCollector) Store(store MsgStore) *HTMLCollector {
+	c.store = store
+	return c
+}
+
+func (c *HTMLCollector) AddFilter(f MsgFilter) {
+	c.filters = append(c.filters, f)
+}
+
+func (c *HTMLCollector) Name() string {
+	return c.page.Name
+}
+
+// DefaultPageSize is 0 as the page is of any size
+func (c *HTMLCollector) DefaultPageSize() int {
+	return 0
+}
+
+// Fetch scrapes all the items of the page as page 0, there is no other page
+func (c *HTMLCollector) Fetch(page, pageSize int) (msgs []*Msg, err error) {
+	if page > 0 {
+		return nil, nil
+	}
+	msgs, err = c.fetch(false)
+	if pageSize > 0 && len(msgs) > pageSize {
+		msgs = msgs[:pageSize]
+	}
+	return
+}
+
+// fetch scrapes the items, none if changed and the page is the same as the last time
+func (c *HTMLCollector) fetch(changed bool) (msgs []*Msg, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	headers := map[string]string{"Accept": "text/html"}
+	for key, value := range c.page.Headers {
+		headers[key] = value
+	}
+	if changed {
+		if c.etag != "" {
+			headers["If-None-Match"] = c.etag
+		}
+		if c.lastModified != "" {
+			headers["If-Modified-Since"] = c.lastModified
+		}
+	}
+
+	rCode, rBody, rHeader, err := utils.SendRequestForHeader(http.MethodGet, c.page.URL, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	switch rCode {
+	case http.StatusNotModified:
+		glog.V(4).Infof("HTML %s IS NOT MODIFIED", c.page.Name)
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("html %s responded %d", c.page.Name, rCode)
+	}
+
+	// Some servers do not support the conditional GET
+	sum := sha1.Sum([]byte(rBody))
+	digest := hex.EncodeToString(sum[:])
+	if changed && digest == c.digest {
+		glog.V(4).Infof("HTML %s IS NOT CHANGED", c.page.Name)
+		return nil, nil
+	}
+
+	if msgs, err = c.parse([]byte(rBody), rHeader.Get("Content-Type")); err != nil {
+		return nil, err
+	}
+	if changed {
+		c.etag, c.lastModified, c.digest = rHeader.Get("ETag"), rHeader.Get("Last-Modified"), digest
+	}
+	return msgs, nil
+}
+
+// decode converts the page into UTF-8 by the configured charset, or the one in the content type or the page
+func (c *HTMLCollector) decode(body []byte, contentType string) (io.Reader, error) {
+	if c.encoding != nil {
+		return c.encoding.NewDecoder().Reader(bytes.NewReader(body)), nil
+	}
+	return charset.NewReader(bytes.NewReader(body), contentType)
+}
+
+func (c *HTMLCollector) parse(body []byte, contentType string) (msgs []*Msg, err error) {
+	reader, err := c.decode(body, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("html %s: %v", c.page.Name, err)
+	}
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("html %s: %v", c.page.Name, err)
+	}
+
+	base := c.base
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, uErr := c.base.Parse(href); uErr == nil {
+			base = u
+		}
+	}
+
+	parser := utils.NewSourceTimeParser(c.location, c.now())
+	doc.Find(c.page.ItemSelector).Each(func(idx int, item *goquery.Selection) {
+		msg, pErr := c.parseItem(item, base, parser)
+		if pErr != nil {
+			glog.Warningf("html %s: skip the item %d, ERR: %v", c.page.Name, idx, pErr)
+			return
+		}
+		msgs = append(msgs, msg)
+	})
+	return msgs, nil
+}
+
+func (c *HTMLCollector) parseItem(item *goquery.Selection, base *url.URL, parser *utils.SourceTimeParser) (*Msg, error) {
+	var (
+		title = selectText(item, c.page.TitleSelector)
+		link  string
+		msg   = &Msg{
+			Source: c.page.Name,
+			ID:     selectText(item, c.page.IDSelector),
+		}
+	)
+	if title == "" {
+		return nil, fmt.Errorf("missing title")
+	}
+	// Unlike the ID and the title, no link or time of the empty selectors
+	if c.page.LinkSelector != "" {
+		link = selectText(item, c.page.LinkSelector)
+	}
+	if c.page.TimeSelector != "" {
+		msg.CreateTime = selectText(item, c.page.TimeSelector)
+	}
+	if link != "" {
+		u, err := base.Parse(link)
+		if err != nil {
+			return nil, err
+		}
+		link = u.String()
+	}
+	if c.page.IDSelector == "" && c.page.LinkSelector != "" {
+		msg.ID = link
+	}
+	if msg.ID == "" {
+		return nil, fmt.Errorf("missing id of %s", title)
+	}
+
+	msg.Text = title
+	if link != "" {
+		msg.Text += "\n" + link
+	}
+
+	var (
+		t   time.Time
+		err = fmt.Errorf("no time selector")
+	)
+	switch {
+	case c.page.TimeSelector == "":
+	case c.page.TimeFormat != "":
+		t, err = time.ParseInLocation(c.page.TimeFormat, msg.CreateTime, c.location)
+	default:
+		t, err = parser.Parse(msg.CreateTime)
+	}
+	if err != nil {
+		glog.V(4).Infof("html %s: use the fetch time of item %s, ERR: %v", c.page.Name, msg.ID, err)
+		t = c.now()
+	}
+	msg.CreatedAt = t.UTC()
+	return msg.Extract(), nil
+}
+
+// selectText returns the text of the element by the selector, or the attribute of selector@attr,
+// the selection itself if the selector is empty
+func selectText(s *goquery.Selection, selector string) string {
+	var attr string
+	if idx := strings.LastIndex(selector, "@"); idx >= 0 {
+		selector, attr = strings.TrimSpace(selector[:idx]), selector[idx+1:]
+	}
+	if selector != "" {
+		s = s.Find(selector).First()
+	}
+	if attr != "" {
+		value, _ := s.Attr(attr)
+		return strings.TrimSpace(value)
+	}
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+// Poll scrapes the page if changed, stores the new items and applies the filters
+func (c *HTMLCollector) Poll() (fresh []*Msg, err error) {
+	msgs, err := c.fetch(true)
+	if err != nil {
+		return
+	}
+	return CollectFresh(c.store, c.filters, msgs)
+}
+
+// Start polls the page every interval
+func (c *HTMLCollector) Start() {
+	startPolling(c.Name(), time.Duration(c.page.IntervalSeconds)*time.Second, c.Poll)
+}
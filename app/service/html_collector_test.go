@@ -0,0 +1,122 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+const (
+	testHTMLPage = `<html><head><meta charset="%s"><title>公告</title></head><body>
+<ul class="notices">
+  <li data-id="n2"><a href="/notice/2.html">腾讯控股：关于回购股份的公告</a><span class="date">2020-12-01 08:30</span></li>
+  <li data-id="n1"><a href="1.html"> 某公司 停牌公告 </a><span class="date">2020-12-01 08:10</span></li>
+  <li class="empty"></li>
+</ul>
+</body></html>`
+)
+
+func TestHTMLCollector(t *testing.T) {
+	var (
+		utf8Page   = strings.ReplaceAll(testHTMLPage, "%s", "utf-8")
+		gbkPage, _ = simplifiedchinese.GBK.NewEncoder().String(strings.ReplaceAll(testHTMLPage, "%s", "gbk"))
+		server     = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/gbk/list.html":
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(gbkPage))
+			default:
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write([]byte(utf8Page))
+			}
+		}))
+		page = config.HTMLPage{
+			Name:          "exchange",
+			ItemSelector:  "ul.notices > li",
+			TitleSelector: "a",
+			LinkSelector:  "a@href",
+			TimeSelector:  ".date",
+		}
+	)
+	defer server.Close()
+
+	for _, path := range []string{"/utf8/list.html", "/gbk/list.html"} {
+		page.URL = server.URL + path
+		collector, err := NewHTMLCollector(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs, err := collector.Fetch(0, 0)
+		if err != nil || len(msgs) != 2 {
+			t.Fatalf("%s: %v, %v", path, msgs, err)
+		}
+		dir := strings.TrimSuffix(path, "list.html")
+		if msg := msgs[0]; msg.ID != server.URL+"/notice/2.html" || msg.Text != "腾讯控股：关于回购股份的公告\n"+msg.ID ||
+			!msg.CreatedAt.Equal(time.Date(2020, 12, 1, 0, 30, 0, 0, time.UTC)) || len(msg.Symbols) != 1 {
+			t.Errorf("%s: msg %+v", path, msg)
+		}
+		if msg := msgs[1]; msg.ID != server.URL+dir+"1.html" || !strings.HasPrefix(msg.Text, "某公司 停牌公告\n") {
+			t.Errorf("%s: msg %+v", path, msg)
+		}
+	}
+
+	// The attribute of the item itself as the ID
+	page.URL = server.URL + "/utf8/list.html"
+	page.IDSelector = "@data-id"
+	collector, _ := NewHTMLCollector(page)
+	collector.Store(NewFileMsgStore(t.TempDir()))
+	fresh, err := collector.Poll()
+	if err != nil || len(fresh) != 2 || fresh[0].ID != "n2" {
+		t.Fatalf("%v, %v", fresh, err)
+	}
+	// The page is not changed
+	if fresh, err = collector.Poll(); err != nil || len(fresh) != 0 {
+		t.Errorf("%v, %v", fresh, err)
+	}
+	utf8Page = strings.Replace(utf8Page, `<li class="empty"></li>`, `<li data-id="n0"><a href="0.html">复牌公告</a></li>`, 1)
+	if fresh, err = collector.Poll(); err != nil || len(fresh) != 1 || fresh[0].ID != "n0" {
+		t.Errorf("%v, %v", fresh, err)
+	}
+}
+
+func TestHTMLCollectorNoLinkOrTime(t *testing.T) {
+	var (
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(strings.ReplaceAll(testHTMLPage, "%s", "utf-8")))
+		}))
+		now = time.Date(2020, 12, 1, 1, 0, 0, 0, time.UTC)
+	)
+	defer server.Close()
+
+	collector, err := NewHTMLCollector(config.HTMLPage{
+		Name:          "exchange",
+		URL:           server.URL,
+		ItemSelector:  "ul.notices > li",
+		IDSelector:    "@data-id",
+		TitleSelector: "a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.now = func() time.Time { return now }
+	msgs, err := collector.Fetch(0, 0)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("%v, %v", msgs, err)
+	}
+	// Neither the text of the item as the link, nor as the time
+	if msg := msgs[0]; msg.ID != "n2" || msg.Text != "腾讯控股：关于回购股份的公告" || msg.CreateTime != "" || !msg.CreatedAt.Equal(now) {
+		t.Errorf("msg %+v", msg)
+	}
+}
+
+func TestHTMLCollectorCharset(t *testing.T) {
+	if _, err := NewHTMLCollector(config.HTMLPage{Name: "bad", URL: "http://example.com", Charset: "latin-9x"}); err == nil {
+		t.Error("expect the error of the unknown charset")
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	// The msgs created later than now by more than it are rejected
+	IngestMaxClockSkew = 10 * time.Minute
+)
+
+var (
+	TheIngester = NewIngester(config.Config.Ingest.Tokens, config.Config.Ingest.Sources, config.Config.Ingest.MaxBatch)
+
+	ingestSourcePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+)
+
+// IngestResult tells what are done to the msgs of a request
+type IngestResult struct {
+	Source     string   `json:"source"`
+	Received   int      `json:"received"`
+	Accepted   int      `json:"accepted"`
+	Duplicates int      `json:"duplicates"`
+	IDs        []string `json:"ids,omitempty"`
+}
+
+// IngestError is the invalid msg of the index in the batch
+type IngestError struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// Ingester accepts the normalized msgs of the other systems into the same pipeline as the collected ones
+type Ingester struct {
+	tokens   []string
+	sources  map[string]bool
+	maxBatch int
+	store    MsgStore
+	filters  []MsgFilter
+	now      func() time.Time
+
+	// Serializes the requests, the same msg may be sent concurrently
+	lock sync.Mutex
+}
+
+func NewIngester(tokens, sources []string, maxBatch int) *Ingester {
+	i := &Ingester{
+		tokens:   tokens,
+		maxBatch: maxBatch,
+		store:    TheMsgStore,
+		now:      time.Now,
+	}
+	if len(sources) > 0 {
+		i.sources = make(map[string]bool)
+		for _, source := range sources {
+			i.sources[source] = true
+		}
+	}
+	return i
+}
+
+func (i *Ingester) Store(store MsgStore) *Ingester {
+	i.store = store
+	return i
+}
+
+func (i *Ingester) AddFilter(f MsgFilter) {
+	i.filters = append(i.filters, f)
+}
+
+// Enabled is false if there is no token
+func (i *Ingester) Enabled() bool {
+	return len(i.tokens) > 0
+}
+
+// Authorized checks the bearer token of the Authorization header
+func (i *Ingester) Authorized(authorization string) bool {
+	return bearerAuthorized(authorization, i.tokens)
+}
+
+// CheckSource rejects the invalid names, and the sources of the collectors to keep them apart
+func (i *Ingester) CheckSource(source string) error {
+	if !ingestSourcePattern.MatchString(source) {
+		return fmt.Errorf("invalid source %s", source)
+	}
+	if i.sources != nil {
+		if !i.sources[source] {
+			return fmt.Errorf("source %s is not allowed", source)
+		}
+		return nil
+	}
+	if _, err := GetCollector(source); err == nil {
+		return fmt.Errorf("source %s is collected", source)
+	}
+	return nil
+}
+
+// Validate normalizes the msgs of the source, all of them are rejected if any is invalid
+func (i *Ingester) Validate(source string, msgs []*Msg) (errs []IngestError) {
+	if len(msgs) > i.maxBatch {
+		return []IngestError{{Index: -1, Error: fmt.Sprintf("%d msgs exceed the max batch %d", len(msgs), i.maxBatch)}}
+	}
+
+	latest := i.now().Add(IngestMaxClockSkew)
+	for idx, msg := range msgs {
+		if err := i.validate(source, msg, latest); err != nil {
+			var id string
+			if msg != nil {
+				id = msg.ID
+			}
+			errs = append(errs, IngestError{Index: idx, ID: id, Error: err.Error()})
+		}
+	}
+	return
+}
+
+func (i *Ingester) validate(source string, msg *Msg, latest time.Time) (err error) {
+	if msg == nil {
+		return fmt.Errorf("null msg")
+	}
+	if msg.Source != "" && msg.Source != source {
+		return fmt.Errorf("source %s is not %s", msg.Source, source)
+	}
+	msg.Source = source
+	if msg.ID = strings.TrimSpace(msg.ID); msg.ID == "" {
+		return fmt.Errorf("missing id")
+	}
+	if msg.Text = strings.TrimSpace(msg.Text); msg.Text == "" {
+		return fmt.Errorf("missing content")
+	}
+	if msg.CreatedAt.IsZero() {
+		if msg.CreatedAt, err = ParseRSSTime(strings.TrimSpace(msg.CreateTime)); err != nil {
+			return fmt.Errorf("missing created_at, %v", err)
+		}
+	}
+	if msg.CreatedAt.After(latest) {
+		return fmt.Errorf("created_at %s is in the future", msg.CreatedAt.Format(time.RFC3339))
+	}
+	msg.CreatedAt = msg.CreatedAt.UTC()
+	if msg.CreateTime == "" {
+		msg.CreateTime = msg.LocalTime()
+	}
+	msg.Extract()
+	return nil
+}
+
+// Ingest stores the valid msgs not seen before and applies the filters to them
+func (i *Ingester) Ingest(source string, msgs []*Msg) (result IngestResult, err error) {
+	result = IngestResult{Source: source, Received: len(msgs)}
+
+	// The same msg may be sent more than once in a batch
+	var (
+		seen   = make(map[string]bool)
+		unique []*Msg
+	)
+	for _, msg := range msgs {
+		if !seen[msg.ID] {
+			seen[msg.ID] = true
+			unique = append(unique, msg)
+		}
+	}
+
+	i.lock.Lock()
+	fresh, err := CollectFresh(i.store, i.filters, unique)
+	i.lock.Unlock()
+	if err != nil {
+		return
+	}
+	result.Accepted = len(fresh)
+	result.Duplicates = len(msgs) - len(fresh)
+	for _, msg := range fresh {
+		result.IDs = append(result.IDs, msg.ID)
+	}
+	glog.V(4).Infof("INGEST %s: %d RECEIVED, %d ACCEPTED", source, result.Received, result.Accepted)
+	return
+}
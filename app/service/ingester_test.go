@@ -0,0 +1,90 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestAPIIngest(t *testing.T) {
+	defer func(ingester *Ingester, router *AlertRouter) {
+		TheIngester, TheAlertRouter = ingester, router
+	}(TheIngester, TheAlertRouter)
+
+	var (
+		store         = NewFileMsgStore(t.TempDir())
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		alice         = &fakeNotifier{name: "alice"}
+		server        = NewAPIServer("")
+		do            = func(path, token, body string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			server.ServeHTTP(recorder, r)
+			return recorder
+		}
+	)
+	subscriptions.Load([]config.Subscription{{User: "alice", Notifiers: []string{"alice"}}})
+	TheAlertRouter = NewAlertRouter(subscriptions).Notifier(alice).History(NewAlertHistory(10)).
+		Clusters(NewMsgClusters(10*time.Minute, 0.6, 10))
+
+	TheIngester = NewIngester(nil, nil, 2)
+	if recorder := do("/ingest/scraper", "secret", `{}`); recorder.Code != http.StatusForbidden {
+		t.Errorf("disabled: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	TheIngester = NewIngester([]string{"secret"}, nil, 2).Store(store)
+	TheIngester.AddFilter(NewKeywordMsgFilter(config.Rule{Name: "fed", Keywords: []string{"美联储"}}))
+	for _, c := range []struct {
+		path, token, body string
+		code              int
+	}{
+		{"/ingest/scraper", "", `{}`, http.StatusUnauthorized},
+		{"/ingest/scraper", "wrong", `{}`, http.StatusUnauthorized},
+		{"/ingest/" + FutuSourceName, "secret", `{}`, http.StatusForbidden},
+		{"/ingest/.hidden", "secret", `{}`, http.StatusForbidden},
+		{"/ingest/scraper", "secret", `{"id":`, http.StatusBadRequest},
+		{"/ingest/scraper", "secret", `[]`, http.StatusBadRequest},
+		{"/ingest/scraper", "secret", `[{},{},{}]`, http.StatusBadRequest},
+		{"/ingest/scraper", "secret", `{"id":"1","created_at":"2020-12-01T00:30:00Z"}`, http.StatusBadRequest},
+		{"/ingest/scraper", "secret", `{"id":"1","content":"美联储","create_time_str":"2999-01-01 00:00:00"}`, http.StatusBadRequest},
+		{"/ingest/scraper", "secret", `{"source":"sina","id":"1","content":"美联储","created_at":"2020-12-01T00:30:00Z"}`, http.StatusBadRequest},
+	} {
+		if recorder := do(c.path, c.token, c.body); recorder.Code != c.code {
+			t.Errorf("%s %s: %d %s", c.path, c.body, recorder.Code, recorder.Body.String())
+		}
+	}
+	if sources, _ := store.Sources(); len(sources) != 0 {
+		t.Errorf("sources %v", sources)
+	}
+
+	// A msg, then a batch with it again
+	recorder := do("/ingest/scraper", "secret", `{"id":"1","content":"美联储宣布加息","create_time_str":"2020-12-01 08:30:00"}`)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"accepted":1`) {
+		t.Fatalf("msg: %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = do("/ingest/scraper", "secret", `[{"id":"1","content":"美联储宣布加息","created_at":"2020-12-01T00:30:00Z"},
+		{"id":"2","content":"腾讯控股回购股份","created_at":"2020-12-01T00:31:00Z"}]`)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"accepted":1,"duplicates":1,"ids":["2"]`) {
+		t.Fatalf("batch: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	msg, err := store.Get("scraper", "1")
+	if err != nil || !msg.CreatedAt.Equal(time.Date(2020, 12, 1, 0, 30, 0, 0, time.UTC)) {
+		t.Errorf("%+v, %v", msg, err)
+	}
+	if msg, err = store.Get("scraper", "2"); err != nil || len(msg.Symbols) != 1 {
+		t.Errorf("%+v, %v", msg, err)
+	}
+	// Alerted once by the rule
+	if len(alice.titles) != 1 {
+		t.Errorf("alice %v", alice.titles)
+	}
+}
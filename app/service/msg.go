@@ -0,0 +1,80 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/skeyic/monitoring/app/utils"
+)
+
+// Msg is the normalized msg of all the sources, used by the store and the rules
+type Msg struct {
+	Source string `json:"source"`
+	ID     string `json:"id"`
+	// The original string from the source
+	CreateTime string `json:"create_time_str"`
+	// UTC
+	CreatedAt time.Time `json:"created_at"`
+	Text      string    `json:"content"`
+	// Extracted from the text
+	Rating *RatingChange `json:"rating,omitempty"`
+	// The canonical symbols mentioned
+	Symbols []string `json:"symbols,omitempty"`
+}
+
+// Extract fills the structured fields extracted from the text
+func (s *Msg) Extract() *Msg {
+	text := stripHTML(s.Text)
+	s.Rating = ExtractRatingChange(text)
+	s.Symbols = TheEntityRecognizer.Recognize(text)
+	return s
+}
+
+// LocalTime is the create time in the zone of the sources
+func (s *Msg) LocalTime() string {
+	return utils.FormatSourceTime(s.CreatedAt)
+}
+
+// Msgs are sorted from the newest to the oldest
+type Msgs []*Msg
+
+func (s Msgs) Len() int {
+	return len(s)
+}
+
+func (s Msgs) Less(i, j int) bool {
+	return s[i].CreatedAt.After(s[j].CreatedAt)
+}
+
+func (s Msgs) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// msgKey orders the msgs by the create time, then the source and the numeric ID
+type msgKey struct {
+	createdAt int64
+	source    string
+	id        string
+}
+
+func keyOfMsg(msg *Msg) msgKey {
+	return msgKey{createdAt: msg.CreatedAt.UnixNano(), source: msg.Source, id: msg.ID}
+}
+
+func compareMsgKeys(a, b msgKey) int {
+	return cmp.Or(cmp.Compare(a.createdAt, b.createdAt), strings.Compare(a.source, b.source), utils.CompareNumericStrings(a.id, b.id))
+}
+
+// MergeMsgs merges the new msgs into the source ones from the newest, the same msgs are merged into the new one
+func MergeMsgs(sourceMsgs, newMsgs []*Msg) []*Msg {
+	descend := func(msgs []*Msg) []*Msg {
+		msgs = slices.Clone(msgs)
+		slices.SortStableFunc(msgs, func(a, b *Msg) int {
+			return compareMsgKeys(keyOfMsg(b), keyOfMsg(a))
+		})
+		return msgs
+	}
+	return utils.MergeDescendFunc(descend(sourceMsgs), descend(newMsgs), keyOfMsg, compareMsgKeys)
+}
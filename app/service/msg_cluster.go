@@ -0,0 +1,189 @@
+package service
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+var (
+	TheMsgClusters = NewMsgClusters(
+		time.Duration(config.Config.Dedup.WindowMinutes)*time.Minute,
+		config.Config.Dedup.Similarity,
+		config.Config.Dedup.HistorySize,
+	)
+)
+
+// ClusterMember is a msg of the cluster
+type ClusterMember struct {
+	Source    string    `json:"source"`
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// To the first msg
+	Similarity float64 `json:"similarity"`
+}
+
+// MsgCluster is the same story carried by the different sources
+type MsgCluster struct {
+	ID int64 `json:"id"`
+	// Of the first msg
+	Text      string          `json:"text"`
+	CreatedAt time.Time       `json:"created_at"`
+	Sources   []string        `json:"sources"`
+	Members   []ClusterMember `json:"members"`
+
+	signature utils.MinHash
+	// Rule/user -> source/ID of the msg alerted
+	alerted map[string]string
+}
+
+func (c *MsgCluster) copy() MsgCluster {
+	cp := *c
+	cp.alerted = nil
+	cp.Sources = append([]string{}, c.Sources...)
+	cp.Members = append([]ClusterMember{}, c.Members...)
+	return cp
+}
+
+// MsgClusters clusters the near-duplicate msgs of the different sources created in a window
+type MsgClusters struct {
+	lock       sync.RWMutex
+	window     time.Duration
+	similarity float64
+	maxCount   int
+	// From the oldest
+	clusters []*MsgCluster
+	byID     map[int64]*MsgCluster
+	// Source/ID -> cluster
+	byMsg  map[string]*MsgCluster
+	lastID int64
+}
+
+// NewMsgClusters creates the clusters, the msgs are never clustered if window is 0
+func NewMsgClusters(window time.Duration, similarity float64, maxCount int) *MsgClusters {
+	return &MsgClusters{
+		window:     window,
+		similarity: similarity,
+		maxCount:   maxCount,
+		byID:       make(map[int64]*MsgCluster),
+		byMsg:      make(map[string]*MsgCluster),
+	}
+}
+
+// Add puts the msg into the most similar cluster of the other sources, or a new one.
+// first tells if the msg is the first one of the cluster, adding the same msg again changes nothing.
+func (c *MsgClusters) Add(msg *Msg) (cluster MsgCluster, first bool) {
+	if c.window <= 0 {
+		return MsgCluster{}, true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := msg.Source + "/" + msg.ID
+	if hit, ok := c.byMsg[key]; ok {
+		return hit.copy(), hit.Members[0].Source == msg.Source && hit.Members[0].ID == msg.ID
+	}
+
+	var (
+		signature = utils.NewMinHash(stripHTML(msg.Text))
+		best      *MsgCluster
+		bestScore float64
+		member    = ClusterMember{Source: msg.Source, ID: msg.ID, CreatedAt: msg.CreatedAt, Similarity: 1}
+	)
+	for _, candidate := range c.clusters {
+		if d := msg.CreatedAt.Sub(candidate.CreatedAt); d >= c.window || d <= -c.window || slices.Contains(candidate.Sources, msg.Source) {
+			continue
+		}
+		if score := signature.Similarity(candidate.signature); score >= c.similarity && score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+
+	if best != nil {
+		member.Similarity = bestScore
+		best.Sources = append(best.Sources, msg.Source)
+		best.Members = append(best.Members, member)
+		c.byMsg[key] = best
+		return best.copy(), false
+	}
+
+	c.lastID++
+	best = &MsgCluster{
+		ID:        c.lastID,
+		Text:      msg.Text,
+		CreatedAt: msg.CreatedAt,
+		Sources:   []string{msg.Source},
+		Members:   []ClusterMember{member},
+		signature: signature,
+		alerted:   make(map[string]string),
+	}
+	c.clusters = append(c.clusters, best)
+	c.byID[best.ID] = best
+	c.byMsg[key] = best
+	for c.maxCount > 0 && len(c.clusters) > c.maxCount {
+		oldest := c.clusters[0]
+		delete(c.byID, oldest.ID)
+		for _, m := range oldest.Members {
+			delete(c.byMsg, m.Source+"/"+m.ID)
+		}
+		c.clusters[0] = nil
+		c.clusters = c.clusters[1:]
+	}
+	return best.copy(), true
+}
+
+// Alert tells if the rule has not alerted the user of the cluster by another msg, and records the msg has.
+// The msgs not clustered are always alerted.
+func (c *MsgClusters) Alert(id int64, rule, user string, msg *Msg) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cluster, hit := c.byID[id]
+	if !hit {
+		return true
+	}
+	var (
+		key    = rule + "/" + user
+		msgKey = msg.Source + "/" + msg.ID
+	)
+	if alerted, ok := cluster.alerted[key]; ok && alerted != msgKey {
+		return false
+	}
+	cluster.alerted[key] = msgKey
+	return true
+}
+
+func (c *MsgClusters) Get(id int64) (MsgCluster, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if cluster, hit := c.byID[id]; hit {
+		return cluster.copy(), true
+	}
+	return MsgCluster{}, false
+}
+
+// Of returns the cluster of the msg
+func (c *MsgClusters) Of(source, id string) (MsgCluster, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if cluster, hit := c.byMsg[source+"/"+id]; hit {
+		return cluster.copy(), true
+	}
+	return MsgCluster{}, false
+}
+
+// List returns the latest clusters from the newest, only the ones of more than one source if multiSource
+func (c *MsgClusters) List(limit int, multiSource bool) (clusters []MsgCluster) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for idx := len(c.clusters) - 1; idx >= 0 && (limit <= 0 || len(clusters) < limit); idx-- {
+		if multiSource && len(c.clusters[idx].Sources) < 2 {
+			continue
+		}
+		clusters = append(clusters, c.clusters[idx].copy())
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestMsgClusters(t *testing.T) {
+	var (
+		clusters = NewMsgClusters(10*time.Minute, 0.6, 10)
+		now      = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+		futu     = &Msg{Source: FutuSourceName, ID: "1", CreatedAt: now, Text: "美联储宣布维持利率不变，符合市场预期"}
+		sina     = &Msg{Source: SinaFinanceSourceName, ID: "a", CreatedAt: now.Add(30 * time.Second), Text: "【美联储维持利率不变 符合市场预期】"}
+	)
+	if _, first := clusters.Add(futu); !first {
+		t.Fatal("the first msg")
+	}
+	cluster, first := clusters.Add(sina)
+	if first || cluster.ID != 1 || strings.Join(cluster.Sources, ",") != "futu,sina" {
+		t.Fatalf("cluster %+v", cluster)
+	}
+	// Added again
+	if cluster, first = clusters.Add(futu); !first || len(cluster.Members) != 2 {
+		t.Errorf("cluster %+v", cluster)
+	}
+
+	for _, msg := range []*Msg{
+		// The same source
+		{Source: FutuSourceName, ID: "2", CreatedAt: now, Text: "美联储宣布维持利率不变，符合市场预期"},
+		// Out of the window
+		{Source: "rss", ID: "x", CreatedAt: now.Add(time.Hour), Text: "美联储宣布维持利率不变，符合市场预期"},
+		// Not similar
+		{Source: "rss", ID: "y", CreatedAt: now, Text: "腾讯控股：拟回购不超过10亿港元股份"},
+	} {
+		if cluster, first = clusters.Add(msg); !first {
+			t.Errorf("%s/%s is added to %+v", msg.Source, msg.ID, cluster)
+		}
+	}
+	if list := clusters.List(0, true); len(list) != 1 || list[0].ID != 1 {
+		t.Errorf("multi-source clusters %+v", list)
+	}
+	if list := clusters.List(2, false); len(list) != 2 || list[0].ID != 4 {
+		t.Errorf("clusters %+v", list)
+	}
+}
+
+func TestAlertRouterDedup(t *testing.T) {
+	var (
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		history       = NewAlertHistory(10)
+		alice         = &fakeNotifier{name: "alice"}
+		bob           = &fakeNotifier{name: "bob"}
+		clusters      = NewMsgClusters(10*time.Minute, 0.6, 10)
+		router        = NewAlertRouter(subscriptions).Notifier(alice).Notifier(bob).History(history).Clusters(clusters)
+		fed           = NewKeywordMsgFilter(config.Rule{Name: "fed", Keywords: []string{"美联储"}})
+		rate          = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"利率"}})
+		now           = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+		futu          = &Msg{Source: FutuSourceName, ID: "1", CreatedAt: now, Text: "美联储宣布维持利率不变，符合市场预期"}
+		sina          = &Msg{Source: SinaFinanceSourceName, ID: "a", CreatedAt: now, Text: "【美联储维持利率不变 符合市场预期】"}
+	)
+	subscriptions.Load([]config.Subscription{
+		{User: "alice", Notifiers: []string{"alice"}},
+		{User: "bob", Notifiers: []string{"bob"}},
+	})
+	// Clustered when collected
+	clusters.Add(futu)
+	clusters.Add(sina)
+
+	router.Route(fed, futu)
+	router.Route(fed, sina)
+	if len(alice.titles) != 1 || len(bob.titles) != 1 {
+		t.Fatalf("alice %v, bob %v", alice.titles, bob.titles)
+	}
+	for _, record := range history.List(0) {
+		if strings.Join(record.Sources, ",") != "futu,sina" {
+			t.Errorf("record %+v", record)
+		}
+	}
+
+	// Not alerted by the other rule yet, though the first msg is
+	router.Route(rate, sina)
+	router.Route(rate, futu)
+	if len(alice.titles) != 2 || len(bob.titles) != 2 {
+		t.Errorf("alice %v, bob %v", alice.titles, bob.titles)
+	}
+}
+
+func TestAPIClusters(t *testing.T) {
+	defer func(clusters *MsgClusters) { TheMsgClusters = clusters }(TheMsgClusters)
+	TheMsgClusters = NewMsgClusters(10*time.Minute, 0.6, 10)
+	TheMsgClusters.Add(&Msg{Source: FutuSourceName, ID: "1", Text: "美联储宣布维持利率不变"})
+	TheMsgClusters.Add(&Msg{Source: SinaFinanceSourceName, ID: "a", Text: "美联储宣布维持利率不变"})
+
+	var (
+		server = NewAPIServer("")
+		do     = func(path string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			return recorder
+		}
+	)
+	if recorder := do("/clusters"); !strings.Contains(recorder.Body.String(), `"sources":["futu","sina"]`) {
+		t.Errorf("clusters: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := do("/clusters/1"); recorder.Code != http.StatusOK {
+		t.Errorf("cluster: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := do("/clusters/2"); recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown cluster: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
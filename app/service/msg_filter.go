@@ -0,0 +1,121 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+)
+
+// MsgFilter is a rule applied to the msgs of all the sources
+type MsgFilter interface {
+	Name() string
+	Match(msg *Msg) bool
+	// Format returns the title and the content of the alert
+	Format(msg *Msg) (title, content string)
+	Alert(msg *Msg) error
+}
+
+// ApplyFilter calls matched for every msg matched by a filter
+func ApplyFilter(filters []MsgFilter, msgs []*Msg, matched func(filter MsgFilter, msg *Msg)) {
+	for _, msg := range msgs {
+		glog.V(8).Infof("ApplyFilter CHECKING: %+v", msg)
+		for _, theFilter := range filters {
+			if theFilter.Match(msg) {
+				matched(theFilter, msg)
+			}
+		}
+	}
+}
+
+// AlertMatched sends the alert of the filter
+func AlertMatched(filter MsgFilter, msg *Msg) {
+	if err := filter.Alert(msg); err != nil {
+		glog.Errorf("failed to alert msg %s/%s of rule %s, ERR: %v", msg.Source, msg.ID, filter.Name(), err)
+	}
+}
+
+type RateMsgFilter struct {
+}
+
+func NewRateMsgFilter() RateMsgFilter {
+	return RateMsgFilter{}
+}
+
+func (r RateMsgFilter) Name() string {
+	return "rate"
+}
+
+func (r RateMsgFilter) Match(msg *Msg) bool {
+	if strings.Contains(msg.Text, "目标价") && strings.Contains(msg.Text, "评级") {
+		glog.V(4).Infof("MATCH RULE MSG: %+v\n", msg)
+		return true
+	}
+	return false
+}
+
+// just used to dedup before fixing the duplicate alert issue
+var (
+	previousMsg     *Msg
+	previousMsgLock sync.Mutex
+)
+
+// sameAsPreviousMsg checks the msg against the previous one alerted, and keeps it as the previous one if not the same
+func sameAsPreviousMsg(msg *Msg) bool {
+	previousMsgLock.Lock()
+	defer previousMsgLock.Unlock()
+	if previousMsg != nil && previousMsg.Text == msg.Text {
+		glog.Warningf("Same as previous alert %+v, current: %+v,skip", previousMsg, msg)
+		return true
+	}
+	previousMsg = msg
+	return false
+}
+
+func (r RateMsgFilter) Alert(msg *Msg) error {
+	glog.V(4).Infof("ALERT MSG: %+v\n", msg)
+	if sameAsPreviousMsg(msg) {
+		return nil
+	}
+	return utils.SendAlertV2(FormatAlert(r, DefaultNotifierName, "", msg))
+}
+
+func (r RateMsgFilter) Keywords() []string {
+	return []string{"目标价", "评级"}
+}
+
+func (r RateMsgFilter) Format(msg *Msg) (title, content string) {
+	if msg.Rating != nil {
+		return fmt.Sprintf("Rate %s %s", msg.Rating, msg.LocalTime()), msg.Text
+	}
+	return fmt.Sprintf("Rate " + msg.LocalTime()), msg.Text
+}
+
+type TestMsgFilter struct {
+}
+
+func NewTestMsgFilter() TestMsgFilter {
+	return TestMsgFilter{}
+}
+
+func (r TestMsgFilter) Name() string {
+	return "test"
+}
+
+func (r TestMsgFilter) Match(msg *Msg) bool {
+	if strings.Contains(msg.Text, "的") {
+		glog.V(4).Infof("MATCH RULE MSG: %+v\n", msg)
+		return true
+	}
+	return false
+}
+
+func (r TestMsgFilter) Format(msg *Msg) (title, content string) {
+	return fmt.Sprintf("Test " + msg.LocalTime()), msg.Text
+}
+
+func (r TestMsgFilter) Alert(msg *Msg) error {
+	return utils.SendAlertV2(FormatAlert(r, DefaultNotifierName, "", msg))
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	MsgStoreFileSuffix = ".jsonl"
+)
+
+var (
+	theFileMsgStore = NewFileMsgStore(config.Config.Store.Dir)
+	// TheSQLStore if Store.Backend is sql, theFileMsgStore otherwise
+	theBackendMsgStore = newBackendMsgStore()
+	// The saved msgs are searchable
+	TheMsgStore MsgStore = NewIndexedMsgStore(theBackendMsgStore, TheSearchIndex)
+
+	ErrMsgNotFound = errors.New("msg not found")
+)
+
+// MsgStore keeps all the collected msgs, the collectors only keep a window of them in memory
+type MsgStore interface {
+	// Save skips the msgs already stored
+	Save(msgs []*Msg) error
+	Get(source, id string) (*Msg, error)
+	// Scan calls fn for the msgs of the source created in [from, to), all the sources if source is empty.
+	// Zero from or to means no limit. Stops when fn returns false.
+	Scan(source string, from, to time.Time, fn func(msg *Msg) bool) error
+	Sources() ([]string, error)
+}
+
+// FileMsgStore appends the msgs to a JSON lines file per source, indexed by ID in a file next to it
+type FileMsgStore struct {
+	dir string
+
+	lock *sync.Mutex
+}
+
+func NewFileMsgStore(dir string) *FileMsgStore {
+	return &FileMsgStore{
+		dir:  dir,
+		lock: &sync.Mutex{},
+	}
+}
+
+func (s *FileMsgStore) fileName(source string) string {
+	return filepath.Join(s.dir, source+MsgStoreFileSuffix)
+}
+
+func (s *FileMsgStore) indexName(source string) string {
+	return filepath.Join(s.dir, source+MsgIndexFileSuffix)
+}
+
+func (s *FileMsgStore) scanFile(source string, fn func(offset int64, msg *Msg) bool) error {
+	file, err := os.Open(s.fileName(source))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	_, err = scanMsgs(file, 0, source, fn)
+	return err
+}
+
+// scanMsgs calls fn for the msgs of the lines read from the offset, returns the offset after the last whole line
+func scanMsgs(r io.Reader, offset int64, name string, fn func(offset int64, msg *Msg) bool) (end int64, err error) {
+	var (
+		reader = bufio.NewReader(r)
+	)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			msg := &Msg{}
+			if uErr := json.Unmarshal(line, msg); uErr != nil {
+				glog.Warningf("MSG STORE: skip broken line at %d of %s, ERR: %v", offset, name, uErr)
+			} else if !fn(offset, msg) {
+				return offset, nil
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+	}
+}
+
+func (s *FileMsgStore) Save(msgs []*Msg) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		bySource = make(map[string][]*Msg)
+	)
+	for _, msg := range msgs {
+		bySource[msg.Source] = append(bySource[msg.Source], msg)
+	}
+
+	for source, sourceMsgs := range bySource {
+		if err = s.save(source, sourceMsgs); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *FileMsgStore) save(source string, msgs []*Msg) (err error) {
+	if err = os.MkdirAll(s.dir, 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(s.fileName(source), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	index, err := openMsgIndex(s.fileName(source), s.indexName(source))
+	if err != nil {
+		return
+	}
+	defer index.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+
+	type written struct {
+		id     string
+		offset int64
+	}
+	var (
+		offset   = info.Size()
+		seen     = make(map[string]bool)
+		appended []written
+	)
+	writer := bufio.NewWriter(file)
+	for _, msg := range msgs {
+		if seen[msg.ID] {
+			continue
+		}
+		seen[msg.ID] = true
+		if _, lErr := index.Lookup(msg.ID); lErr != ErrMsgNotFound {
+			if lErr != nil {
+				return lErr
+			}
+			continue
+		}
+
+		data, _ := json.Marshal(msg)
+		data = append(data, '\n')
+		if _, err = writer.Write(data); err != nil {
+			return
+		}
+		appended = append(appended, written{id: msg.ID, offset: offset})
+		offset += int64(len(data))
+	}
+	if err = writer.Flush(); err != nil {
+		return
+	}
+
+	// Indexed only once written, the lines written partly are indexed by the next open if whole
+	for _, msg := range appended {
+		if err = index.Insert(msg.id, msg.offset); err != nil {
+			return
+		}
+	}
+	return index.Commit(offset)
+}
+
+func (s *FileMsgStore) Get(source, id string) (msg *Msg, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	index, err := openMsgIndex(s.fileName(source), s.indexName(source))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrMsgNotFound
+		}
+		return
+	}
+	defer index.Close()
+
+	return index.Lookup(id)
+}
+
+func (s *FileMsgStore) Scan(source string, from, to time.Time, fn func(msg *Msg) bool) (err error) {
+	var (
+		sources = []string{source}
+		stopped bool
+	)
+	if source == "" {
+		if sources, err = s.Sources(); err != nil {
+			return
+		}
+	}
+
+	for _, source := range sources {
+		err = s.scanFile(source, func(offset int64, msg *Msg) bool {
+			if !from.IsZero() && msg.CreatedAt.Before(from) {
+				return true
+			}
+			if !to.IsZero() && !msg.CreatedAt.Before(to) {
+				return true
+			}
+			stopped = !fn(msg)
+			return !stopped
+		})
+		if err != nil || stopped {
+			return
+		}
+	}
+	return
+}
+
+func (s *FileMsgStore) Sources() (sources []string, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), MsgStoreFileSuffix) {
+			sources = append(sources, strings.TrimSuffix(entry.Name(), MsgStoreFileSuffix))
+		}
+	}
+	sort.Strings(sources)
+	return
+}
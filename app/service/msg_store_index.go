@@ -0,0 +1,288 @@
+package service
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+
+	"github.com/golang/glog"
+)
+
+const (
+	MsgIndexFileSuffix = ".idx"
+
+	msgIndexMagic      = 0x31584449_47534d // MSGIDX1
+	msgIndexHeaderSize = 32
+	msgIndexSlotSize   = 16
+	msgIndexMinSlots   = 1024
+)
+
+var (
+	errBrokenMsgIndex = errors.New("broken msg index")
+)
+
+// msgIndex maps the msg IDs of a source to the offsets of their lines in the msgs file. It is an open
+// addressing hash table kept in a file next to the msgs, so the IDs are never all held in memory.
+//
+// The header is the magic, the number of the slots, the number of the IDs and the size of the msgs file
+// indexed. Each slot is the hash of the ID, 0 if empty, and the offset of the line.
+type msgIndex struct {
+	path string
+	file *os.File
+	data *os.File
+
+	slots int64
+	count int64
+	size  int64
+}
+
+// openMsgIndex opens the index of the msgs file, built again if it is missing or broken.
+// The msgs appended since the last update, e.g. by a crash before indexing them, are indexed.
+func openMsgIndex(dataPath, path string) (idx *msgIndex, err error) {
+	data, err := os.Open(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	idx = &msgIndex{path: path, file: file, data: data}
+
+	info, err := data.Stat()
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+
+	if hErr := idx.readHeader(); hErr != nil || idx.size > info.Size() {
+		glog.Warningf("MSG INDEX: build %s again, ERR: %v", path, hErr)
+		if err = idx.reset(msgIndexMinSlots); err != nil {
+			idx.Close()
+			return nil, err
+		}
+	}
+
+	if idx.size < info.Size() {
+		if err = idx.indexFrom(idx.size); err != nil {
+			idx.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+func (idx *msgIndex) Close() error {
+	idx.data.Close()
+	return idx.file.Close()
+}
+
+func msgIDHash(id string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	// 0 is the empty slot
+	return 1
+}
+
+func (idx *msgIndex) readHeader() error {
+	var buf [msgIndexHeaderSize]byte
+	if _, err := idx.file.ReadAt(buf[:], 0); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(buf[0:]) != msgIndexMagic {
+		return errBrokenMsgIndex
+	}
+	idx.slots = int64(binary.LittleEndian.Uint64(buf[8:]))
+	idx.count = int64(binary.LittleEndian.Uint64(buf[16:]))
+	idx.size = int64(binary.LittleEndian.Uint64(buf[24:]))
+
+	info, err := idx.file.Stat()
+	if err != nil {
+		return err
+	}
+	if idx.slots < msgIndexMinSlots || idx.count*2 > idx.slots ||
+		info.Size() != msgIndexHeaderSize+idx.slots*msgIndexSlotSize {
+		return errBrokenMsgIndex
+	}
+	return nil
+}
+
+func (idx *msgIndex) writeHeader() error {
+	var buf [msgIndexHeaderSize]byte
+	binary.LittleEndian.PutUint64(buf[0:], msgIndexMagic)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(idx.slots))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(idx.count))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(idx.size))
+	_, err := idx.file.WriteAt(buf[:], 0)
+	return err
+}
+
+// reset empties the index with the slots
+func (idx *msgIndex) reset(slots int64) (err error) {
+	if err = idx.file.Truncate(0); err != nil {
+		return
+	}
+	if err = idx.file.Truncate(msgIndexHeaderSize + slots*msgIndexSlotSize); err != nil {
+		return
+	}
+	idx.slots, idx.count, idx.size = slots, 0, 0
+	return idx.writeHeader()
+}
+
+// indexFrom indexes the msgs from the offset to the end of the msgs file
+func (idx *msgIndex) indexFrom(offset int64) (err error) {
+	var (
+		reader   = io.NewSectionReader(idx.data, offset, 1<<62)
+		indexErr error
+	)
+	end, err := scanMsgs(reader, offset, idx.path, func(offset int64, msg *Msg) bool {
+		if _, indexErr = idx.Lookup(msg.ID); indexErr == ErrMsgNotFound {
+			indexErr = idx.Insert(msg.ID, offset)
+		}
+		return indexErr == nil
+	})
+	if err = cmp.Or(err, indexErr); err != nil {
+		return
+	}
+	glog.V(4).Infof("MSG INDEX: %d MSGS IN %s", idx.count, idx.path)
+	return idx.Commit(end)
+}
+
+func (idx *msgIndex) slot(i int64) (hash uint64, offset int64, err error) {
+	var buf [msgIndexSlotSize]byte
+	if _, err = idx.file.ReadAt(buf[:], msgIndexHeaderSize+i*msgIndexSlotSize); err != nil {
+		return
+	}
+	return binary.LittleEndian.Uint64(buf[0:]), int64(binary.LittleEndian.Uint64(buf[8:])), nil
+}
+
+func (idx *msgIndex) setSlot(i int64, hash uint64, offset int64) error {
+	var buf [msgIndexSlotSize]byte
+	binary.LittleEndian.PutUint64(buf[0:], hash)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(offset))
+	_, err := idx.file.WriteAt(buf[:], msgIndexHeaderSize+i*msgIndexSlotSize)
+	return err
+}
+
+// msgAt reads the msg of the line at the offset of the msgs file
+func (idx *msgIndex) msgAt(offset int64) (msg *Msg, err error) {
+	line, err := bufio.NewReader(io.NewSectionReader(idx.data, offset, 1<<62)).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	msg = &Msg{}
+	err = json.Unmarshal(line, msg)
+	return
+}
+
+// Lookup reads the msg of the ID, ErrMsgNotFound if not indexed
+func (idx *msgIndex) Lookup(id string) (*Msg, error) {
+	var (
+		hash = msgIDHash(id)
+		i    = int64(hash % uint64(idx.slots))
+	)
+	for probed := int64(0); probed < idx.slots; probed++ {
+		slotHash, offset, err := idx.slot(i)
+		if err != nil {
+			return nil, err
+		}
+		if slotHash == 0 {
+			break
+		}
+		if slotHash == hash {
+			// Collided hashes are told apart by the msg itself
+			msg, err := idx.msgAt(offset)
+			if err != nil {
+				return nil, err
+			}
+			if msg.ID == id {
+				return msg, nil
+			}
+		}
+		i = (i + 1) % idx.slots
+	}
+	return nil, ErrMsgNotFound
+}
+
+// Insert indexes the msg not indexed yet at the offset, the table is doubled once half full
+func (idx *msgIndex) Insert(id string, offset int64) (err error) {
+	if (idx.count+1)*2 > idx.slots {
+		if err = idx.grow(); err != nil {
+			return
+		}
+	}
+	if err = idx.put(msgIDHash(id), offset); err != nil {
+		return
+	}
+	idx.count++
+	return idx.writeHeader()
+}
+
+func (idx *msgIndex) put(hash uint64, offset int64) error {
+	for i := int64(hash % uint64(idx.slots)); ; i = (i + 1) % idx.slots {
+		slotHash, _, err := idx.slot(i)
+		if err != nil {
+			return err
+		}
+		if slotHash == 0 {
+			return idx.setSlot(i, hash, offset)
+		}
+	}
+}
+
+// grow moves the slots to a table twice as large, written aside then renamed so a crash leaves either one
+func (idx *msgIndex) grow() (err error) {
+	file, err := os.OpenFile(idx.path+".tmp", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		return
+	}
+	grown := &msgIndex{path: idx.path, file: file, data: idx.data}
+	if err = grown.reset(idx.slots * 2); err != nil {
+		file.Close()
+		return
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(idx.file, msgIndexHeaderSize, idx.slots*msgIndexSlotSize))
+	for i := int64(0); i < idx.slots; i++ {
+		var buf [msgIndexSlotSize]byte
+		if _, err = io.ReadFull(reader, buf[:]); err != nil {
+			file.Close()
+			return
+		}
+		if hash := binary.LittleEndian.Uint64(buf[0:]); hash != 0 {
+			if err = grown.put(hash, int64(binary.LittleEndian.Uint64(buf[8:]))); err != nil {
+				file.Close()
+				return
+			}
+		}
+	}
+	grown.count, grown.size = idx.count, idx.size
+	if err = grown.writeHeader(); err != nil {
+		file.Close()
+		return
+	}
+	if err = os.Rename(file.Name(), idx.path); err != nil {
+		file.Close()
+		return
+	}
+
+	idx.file.Close()
+	idx.file, idx.slots = file, grown.slots
+	glog.V(4).Infof("MSG INDEX: %s GROWN TO %d SLOTS", idx.path, idx.slots)
+	return
+}
+
+// Commit records the msgs file is indexed up to the size
+func (idx *msgIndex) Commit(size int64) error {
+	idx.size = size
+	return idx.writeHeader()
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/skeyic/monitoring/app/utils"
+)
+
+const (
+	msgImportBatchSize = 1000
+)
+
+// ExportMsgs writes the stored msgs as JSON lines
+func ExportMsgs(store MsgStore, w io.Writer, source string, from, to time.Time) (total int, err error) {
+	var (
+		writer = bufio.NewWriter(w)
+		wErr   error
+	)
+	err = store.Scan(source, from, to, func(msg *Msg) bool {
+		data, _ := json.Marshal(msg)
+		if _, wErr = writer.Write(append(data, '\n')); wErr != nil {
+			return false
+		}
+		total++
+		return true
+	})
+	if err == nil {
+		err = wErr
+	}
+	if err != nil {
+		return
+	}
+	return total, writer.Flush()
+}
+
+// ImportMsgs reads the JSON lines written by ExportMsgs, or the data file saved by a collector
+// (TheFutuCollector.data, TheSinaFinanceCollector.data) whose source must be given.
+func ImportMsgs(store MsgStore, r io.Reader, source string) (total int, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	var msgs []*Msg
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`{"Msgs"`)) {
+		msgs, err = decodeCollectorDataFile(data, source)
+	} else {
+		msgs, err = decodeMsgLines(data, source)
+	}
+	if err != nil {
+		return
+	}
+
+	for start := 0; start < len(msgs); start += msgImportBatchSize {
+		end := min(start+msgImportBatchSize, len(msgs))
+		if err = store.Save(msgs[start:end]); err != nil {
+			return
+		}
+		total = end
+	}
+	return
+}
+
+// ImportFileStore saves the msgs of the JSON lines files of FileMsgStore in dir, e.g. into the SQL store
+func ImportFileStore(store MsgStore, dir string) (total int, err error) {
+	var (
+		batch []*Msg
+		sErr  error
+	)
+	err = NewFileMsgStore(dir).Scan("", time.Time{}, time.Time{}, func(msg *Msg) bool {
+		if batch = append(batch, msg); len(batch) < msgImportBatchSize {
+			return true
+		}
+		if sErr = store.Save(batch); sErr != nil {
+			return false
+		}
+		total, batch = total+len(batch), nil
+		return true
+	})
+	if err == nil {
+		err = sErr
+	}
+	if err != nil || len(batch) == 0 {
+		return
+	}
+	if err = store.Save(batch); err == nil {
+		total += len(batch)
+	}
+	return
+}
+
+// ReadMsgs reads the JSON lines written by ExportMsgs, source is used for the msgs without one
+func ReadMsgs(r io.Reader, source string) (msgs []*Msg, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return decodeMsgLines(data, source)
+}
+
+func decodeMsgLines(data []byte, source string) (msgs []*Msg, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		msg := &Msg{}
+		if err = json.Unmarshal(scanner.Bytes(), msg); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if msg.Source == "" {
+			msg.Source = source
+		}
+		if msg.Source == "" || msg.ID == "" {
+			return nil, fmt.Errorf("line %d: missing source or id", line)
+		}
+		if msg.Rating == nil && msg.Symbols == nil {
+			msg.Extract()
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, scanner.Err()
+}
+
+func decodeCollectorDataFile(data []byte, source string) (msgs []*Msg, err error) {
+	switch source {
+	case FutuSourceName:
+		var saved struct {
+			Msgs FutuMsgs
+		}
+		if err = json.Unmarshal(data, &saved); err != nil {
+			return
+		}
+		sort.Sort(saved.Msgs)
+		saved.Msgs.ResolveTime(time.Now())
+		for _, msg := range saved.Msgs {
+			msgs = append(msgs, msg.ToMsg())
+		}
+	case SinaFinanceSourceName:
+		var saved struct {
+			Msgs SinaFinanceMsgs
+		}
+		if err = json.Unmarshal(data, &saved); err != nil {
+			return
+		}
+		sort.Sort(saved.Msgs)
+		parser := utils.NewSourceTimeParser(utils.ShanghaiLocation, time.Now())
+		for _, msg := range saved.Msgs {
+			if t, pErr := parser.Parse(msg.CreateTime); pErr == nil {
+				msg.CreatedAt = t.UTC()
+			}
+			msgs = append(msgs, msg.ToMsg())
+		}
+	default:
+		return nil, fmt.Errorf("the source of the data file is required: %v", CollectorNames())
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+type fakeCollector struct {
+	pages [][]*Msg
+}
+
+func (c *fakeCollector) Name() string {
+	return "fake"
+}
+
+func (c *fakeCollector) DefaultPageSize() int {
+	return 2
+}
+
+func (c *fakeCollector) Fetch(page, pageSize int) ([]*Msg, error) {
+	if page < len(c.pages) {
+		return c.pages[page], nil
+	}
+	return nil, nil
+}
+
+func TestExportImportMsgs(t *testing.T) {
+	var (
+		now    = time.Date(2020, 12, 2, 8, 0, 0, 0, time.UTC)
+		source = NewFileMsgStore(t.TempDir())
+		target = NewFileMsgStore(t.TempDir())
+		buffer = &bytes.Buffer{}
+	)
+	source.Save([]*Msg{
+		{Source: "futu", ID: "2", CreatedAt: now, Text: "a \"quoted\"\ntext"},
+		{Source: "futu", ID: "1", CreatedAt: now.Add(-time.Hour), Text: "b"},
+	})
+
+	total, err := ExportMsgs(source, buffer, "futu", now.Add(-time.Minute), time.Time{})
+	if err != nil || total != 1 {
+		t.Fatalf("unexpected export: %d, %v", total, err)
+	}
+
+	if total, err = ImportMsgs(target, buffer, ""); err != nil || total != 1 {
+		t.Fatalf("unexpected import: %d, %v", total, err)
+	}
+	if msg, err := target.Get("futu", "2"); err != nil || msg.Text != "a \"quoted\"\ntext" {
+		t.Errorf("unexpected msg: %+v, %v", msg, err)
+	}
+}
+
+func TestImportCollectorDataFile(t *testing.T) {
+	var (
+		store = NewFileMsgStore(t.TempDir())
+		data  = `{"Msgs":[{"commentid":"1000","create_time":"2020-12-08 15:04:05","rich_text":"普拉格"},{"commentid":"999","create_time":"2020-12-08 15:00:00","rich_text":"PLUG"}]}`
+	)
+
+	if _, err := ImportMsgs(store, strings.NewReader(data), ""); err == nil {
+		t.Errorf("expected error without the source")
+	}
+
+	total, err := ImportMsgs(store, strings.NewReader(data), SinaFinanceSourceName)
+	if err != nil || total != 2 {
+		t.Fatalf("unexpected import: %d, %v", total, err)
+	}
+	msg, err := store.Get(SinaFinanceSourceName, "1000")
+	if err != nil || msg.CreatedAt != time.Date(2020, 12, 8, 7, 4, 5, 0, time.UTC) {
+		t.Errorf("unexpected msg: %+v, %v", msg, err)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	var (
+		store     = NewFileMsgStore(t.TempDir())
+		collector = &fakeCollector{pages: [][]*Msg{
+			{{Source: "fake", ID: "4"}, {Source: "fake", ID: "3"}},
+			{{Source: "fake", ID: "2"}, {Source: "fake", ID: "1"}},
+		}}
+	)
+	store.Save([]*Msg{{Source: "fake", ID: "4"}})
+
+	total, err := Backfill(collector, store, 10, 2)
+	if err != nil || total != 3 {
+		t.Errorf("unexpected backfill: %d, %v", total, err)
+	}
+}
+
+func TestPollOverlappedPages(t *testing.T) {
+	var (
+		now       = time.Date(2020, 12, 2, 8, 0, 0, 0, time.UTC)
+		store     = NewFileMsgStore(t.TempDir())
+		collector = &fakeCollector{pages: [][]*Msg{
+			{{Source: "fake", ID: "1000", CreatedAt: now}, {Source: "fake", ID: "999", CreatedAt: now}},
+			// A new msg arrived, 999 is on the next page again
+			{{Source: "fake", ID: "999", CreatedAt: now}, {Source: "fake", ID: "998", CreatedAt: now.Add(-time.Minute)}},
+		}}
+	)
+
+	fresh, err := Poll(collector, store, 10)
+	var ids []string
+	for _, msg := range fresh {
+		ids = append(ids, msg.ID)
+	}
+	if err != nil || strings.Join(ids, ",") != "1000,999,998" {
+		t.Errorf("unexpected fresh msgs: %v, %v", ids, err)
+	}
+}
+
+func TestMergeMsgsProperty(t *testing.T) {
+	toMsgs := func(ids []uint8) (msgs []*Msg) {
+		for _, id := range ids {
+			// Some of them are created at the same time
+			msgs = append(msgs, &Msg{Source: "fake", ID: strconv.Itoa(int(id)), CreatedAt: time.Unix(int64(id/4), 0)})
+		}
+		return
+	}
+	property := func(a, b []uint8) bool {
+		merged := MergeMsgs(toMsgs(a), toMsgs(b))
+		seen := make(map[string]bool)
+		for idx, msg := range merged {
+			if seen[msg.ID] || idx > 0 && compareMsgKeys(keyOfMsg(merged[idx-1]), keyOfMsg(msg)) <= 0 {
+				return false
+			}
+			seen[msg.ID] = true
+		}
+		for _, id := range append(a, b...) {
+			if !seen[strconv.Itoa(int(id))] {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileMsgStore(t *testing.T) {
+	var (
+		now   = time.Date(2020, 12, 2, 8, 0, 0, 0, time.UTC)
+		store = NewFileMsgStore(t.TempDir())
+		msgs  = []*Msg{
+			{Source: "futu", ID: "3", CreatedAt: now, Text: "目标价"},
+			{Source: "futu", ID: "2", CreatedAt: now.Add(-time.Hour), Text: "评级"},
+			{Source: "sina", ID: "1000", CreatedAt: now.Add(-2 * time.Hour), Text: "PLUG"},
+		}
+	)
+
+	if err := store.Save(msgs); err != nil {
+		t.Fatal(err)
+	}
+	// Saved again, skipped
+	if err := store.Save(msgs[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := store.Get("futu", "2")
+	if err != nil || msg.Text != "评级" {
+		t.Errorf("unexpected msg: %+v, err: %v", msg, err)
+	}
+	if _, err = store.Get("futu", "1"); err != ErrMsgNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	// A new store reads the files again
+	store = NewFileMsgStore(store.dir)
+	var scanned []string
+	err = store.Scan("", now.Add(-90*time.Minute), time.Time{}, func(msg *Msg) bool {
+		scanned = append(scanned, msg.Source+"/"+msg.ID)
+		return true
+	})
+	if err != nil || len(scanned) != 2 {
+		t.Errorf("unexpected scan: %v, err: %v", scanned, err)
+	}
+
+	sources, _ := store.Sources()
+	if len(sources) != 2 || sources[0] != "futu" {
+		t.Errorf("unexpected sources: %v", sources)
+	}
+}
+
+func TestFutuCollectorGetMsgFromStore(t *testing.T) {
+	var (
+		now       = time.Now().UTC()
+		collector = NewFutuCollector("").Store(NewFileMsgStore(t.TempDir())).Window(2, time.Hour)
+		msgs      = []*FutuMsg{
+			{CommentID: 3, CreatedAt: now},
+			{CommentID: 2, CreatedAt: now},
+			{CommentID: 1, CreatedAt: now, RichText: "old"},
+		}
+	)
+
+	collector.saveToStore(collector.Msgs.Merge(msgs))
+
+	if collector.Msgs.Len() != 2 {
+		t.Fatalf("expected the window bounded to 2, got %d", collector.Msgs.Len())
+	}
+	msg, err := collector.GetMsg(1)
+	if err != nil || msg.RichText != "old" {
+		t.Errorf("expected the old msg from the store, got %+v, err: %v", msg, err)
+	}
+}
+
+func TestFutuCollectorUnstoredAfterRestart(t *testing.T) {
+	var (
+		now   = time.Now().UTC()
+		store = NewFileMsgStore(t.TempDir())
+		msgs  = []*FutuMsg{
+			{CommentID: 3, CreatedAt: now},
+			{CommentID: 2, CreatedAt: now},
+		}
+	)
+	store.Save([]*Msg{msgs[0].ToMsg()})
+
+	// The window is empty after restarting, the stored msg is not fresh
+	collector := NewFutuCollector("").Store(store).Window(10, time.Hour)
+	unstored := collector.unstored(collector.Msgs.Merge(msgs))
+	if len(unstored) != 1 || unstored[0].CommentID != 2 {
+		t.Errorf("unexpected unstored msgs: %v", unstored)
+	}
+}
+
+func TestFileMsgStoreIndex(t *testing.T) {
+	var (
+		now   = time.Date(2020, 12, 2, 8, 0, 0, 0, time.UTC)
+		store = NewFileMsgStore(t.TempDir())
+		num   = 3 * msgIndexMinSlots
+	)
+
+	// Grown several times
+	for i := 0; i < num; i += 100 {
+		var msgs []*Msg
+		for j := i; j < i+100 && j < num; j++ {
+			msgs = append(msgs, &Msg{Source: "futu", ID: strconv.Itoa(j), CreatedAt: now, Text: strconv.Itoa(j)})
+		}
+		// Duplicated in the batch
+		msgs = append(msgs, msgs[0])
+		if err := store.Save(msgs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []string{"0", "1023", "2047", strconv.Itoa(num - 1)} {
+		if msg, err := store.Get("futu", id); err != nil || msg.Text != id {
+			t.Errorf("unexpected msg %s: %+v, err: %v", id, msg, err)
+		}
+	}
+	if _, err := store.Get("futu", strconv.Itoa(num)); err != ErrMsgNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	var lines int
+	store.Scan("futu", time.Time{}, time.Time{}, func(msg *Msg) bool {
+		lines++
+		return true
+	})
+	if lines != num {
+		t.Errorf("expected %d lines, got %d", num, lines)
+	}
+
+	// Written but not indexed, e.g. crashed before indexing
+	file, err := os.OpenFile(store.fileName("futu"), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"source":"futu","id":"tail","content":"tail"}` + "\n")
+	file.Close()
+	if msg, err := store.Get("futu", "tail"); err != nil || msg.Text != "tail" {
+		t.Errorf("unexpected tail msg: %+v, err: %v", msg, err)
+	}
+
+	// Built again if missing
+	if err := os.Remove(store.indexName("futu")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := store.Get("futu", "1500"); err != nil || msg.Text != "1500" {
+		t.Errorf("unexpected msg after rebuilding: %+v, err: %v", msg, err)
+	}
+	if err := store.Save([]*Msg{{Source: "futu", ID: "1500"}}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := store.Get("futu", "1500"); msg.Text != "1500" {
+		t.Errorf("expected the stored msg kept, got %+v", msg)
+	}
+}
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	PushTypeWebSocket = "websocket"
+	PushTypeSSE       = "sse"
+)
+
+var (
+	ThePushCollectors = newPushCollectors(config.Config.PushFeeds)
+)
+
+func newPushCollectors(feeds []config.PushFeed) map[string]*PushCollector {
+	collectors := make(map[string]*PushCollector)
+	for _, feed := range feeds {
+		c, err := NewPushCollector(feed)
+		if err != nil {
+			glog.Errorf("failed to create the push feed %s, ERR: %v", feed.Name, err)
+			continue
+		}
+		collectors[feed.Name] = c
+	}
+	return collectors
+}
+
+// PushCollector keeps a WebSocket or SSE connection to a source pushing the msgs,
+// reconnects with backoff and resumes from the last event, and polls the feed while disconnected.
+type PushCollector struct {
+	push config.PushFeed
+	// Parses the events, and polls if there is a URL
+	feed    *FeedCollector
+	store   MsgStore
+	filters []MsgFilter
+
+	// Serializes the pushed and the polled msgs
+	collectLock sync.Mutex
+	lastEventID atomic.Value
+	connected   atomic.Bool
+}
+
+func NewPushCollector(push config.PushFeed) (*PushCollector, error) {
+	if push.Type != PushTypeWebSocket && push.Type != PushTypeSSE {
+		return nil, fmt.Errorf("unknown push type %s", push.Type)
+	}
+	if push.MinBackoffSeconds <= 0 {
+		push.MinBackoffSeconds = 1
+	}
+	if push.MaxBackoffSeconds < push.MinBackoffSeconds {
+		push.MaxBackoffSeconds = push.MinBackoffSeconds
+	}
+	feed, err := NewFeedCollector(push.Feed)
+	if err != nil {
+		return nil, err
+	}
+	c := &PushCollector{
+		push:  push,
+		feed:  feed,
+		store: TheMsgStore,
+	}
+	c.lastEventID.Store("")
+	return c, nil
+}
+
+func (c *PushCollector) Store(store MsgStore) *PushCollector {
+	c.store = store
+	c.feed.Store(store)
+	return c
+}
+
+func (c *PushCollector) AddFilter(f MsgFilter) {
+	c.filters = append(c.filters, f)
+	c.feed.AddFilter(f)
+}
+
+func (c *PushCollector) Name() string {
+	return c.push.Name
+}
+
+func (c *PushCollector) DefaultPageSize() int {
+	return c.feed.DefaultPageSize()
+}
+
+// Fetch gets a page of the polled feed, nothing if there is no URL to poll
+func (c *PushCollector) Fetch(page, pageSize int) ([]*Msg, error) {
+	if c.push.URL == "" {
+		return nil, nil
+	}
+	return c.feed.Fetch(page, pageSize)
+}
+
+func (c *PushCollector) Connected() bool {
+	return c.connected.Load()
+}
+
+// LastEventID is the ID of the last event, sent to resume when reconnecting
+func (c *PushCollector) LastEventID() string {
+	return c.lastEventID.Load().(string)
+}
+
+func (c *PushCollector) Start() {
+	c.Run(context.Background())
+}
+
+// Run keeps connected until ctx is done, and polls while disconnected
+func (c *PushCollector) Run(ctx context.Context) {
+	if c.push.URL != "" {
+		go c.fallback(ctx)
+	}
+
+	var (
+		minBackoff = time.Duration(c.push.MinBackoffSeconds) * time.Second
+		maxBackoff = time.Duration(c.push.MaxBackoffSeconds) * time.Second
+		backoff    = minBackoff
+	)
+	for ctx.Err() == nil {
+		var (
+			received bool
+			err      error
+		)
+		switch c.push.Type {
+		case PushTypeWebSocket:
+			received, err = c.runWebSocket(ctx)
+		case PushTypeSSE:
+			received, err = c.runSSE(ctx)
+		}
+		c.connected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = minBackoff
+		}
+		glog.Warningf("push %s is down, reconnect in %s, ERR: %v", c.Name(), backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// fallback polls the feed while disconnected
+func (c *PushCollector) fallback(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(c.feed.feed.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.Connected() {
+				continue
+			}
+			c.collectLock.Lock()
+			fresh, err := c.feed.Poll()
+			c.collectLock.Unlock()
+			glog.V(4).Infof("POLL %s WHILE DISCONNECTED: %d FRESH MSGS, ERR: %v", c.Name(), len(fresh), err)
+		}
+	}
+}
+
+// handle parses an event of an item or a list of items, and collects the new msgs
+func (c *PushCollector) handle(data []byte, eventID string) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return
+	}
+	if data[0] == '{' && len(c.push.ListPath) == 0 {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+	msgs, _, err := c.feed.parse(data)
+	if err != nil {
+		glog.Warningf("push %s: skip the event, ERR: %v", c.Name(), err)
+		return
+	}
+	if eventID == "" && len(msgs) > 0 {
+		eventID = msgs[0].ID
+	}
+	if eventID != "" {
+		c.lastEventID.Store(eventID)
+	}
+
+	c.collectLock.Lock()
+	defer c.collectLock.Unlock()
+	if _, err = CollectFresh(c.store, c.filters, msgs); err != nil {
+		glog.Errorf("push %s: failed to collect %d msgs, ERR: %v", c.Name(), len(msgs), err)
+	}
+}
+
+func (c *PushCollector) heartbeat() time.Duration {
+	return time.Duration(c.push.HeartbeatSeconds) * time.Second
+}
+
+func (c *PushCollector) headers() http.Header {
+	header := http.Header{}
+	for key, value := range c.push.Headers {
+		header.Set(key, value)
+	}
+	if id := c.LastEventID(); id != "" {
+		header.Set("Last-Event-ID", id)
+	}
+	return header
+}
+
+// runWebSocket reads the messages until the connection is down, received tells if any message is received
+func (c *PushCollector) runWebSocket(ctx context.Context) (received bool, err error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.push.PushURL, c.headers())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if c.push.Subscribe != "" {
+		subscribe := strings.ReplaceAll(c.push.Subscribe, "{last_event_id}", c.LastEventID())
+		if err = conn.WriteMessage(websocket.TextMessage, []byte(subscribe)); err != nil {
+			return false, err
+		}
+	}
+	c.connected.Store(true)
+	glog.V(4).Infof("PUSH %s CONNECTED", c.Name())
+
+	heartbeat := c.heartbeat()
+	extend := func() {
+		if heartbeat > 0 {
+			conn.SetReadDeadline(time.Now().Add(heartbeat))
+		}
+	}
+	extend()
+	conn.SetPongHandler(func(string) error { extend(); return nil })
+	if heartbeat > 0 {
+		// The pongs tell the connection is alive when there is no msg
+		go func() {
+			ticker := time.NewTicker(heartbeat / 2)
+			defer ticker.Stop()
+			for range ticker.C {
+				if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)) != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		_, data, rErr := conn.ReadMessage()
+		if rErr != nil {
+			return received, rErr
+		}
+		received = true
+		extend()
+		c.handle(data, "")
+	}
+}
+
+// runSSE reads the events until the stream is down, received tells if anything is received
+func (c *PushCollector) runSSE(ctx context.Context) (received bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.push.PushURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header = c.headers()
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("push %s responded %d", c.Name(), resp.StatusCode)
+	}
+	c.connected.Store(true)
+	glog.V(4).Infof("PUSH %s CONNECTED", c.Name())
+
+	// The comments and the empty lines are the heartbeats
+	var watchdog *time.Timer
+	if heartbeat := c.heartbeat(); heartbeat > 0 {
+		watchdog = time.AfterFunc(heartbeat, cancel)
+		defer watchdog.Stop()
+	}
+
+	var (
+		scanner = bufio.NewScanner(resp.Body)
+		data    bytes.Buffer
+		id      string
+	)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		received = true
+		if watchdog != nil {
+			watchdog.Reset(c.heartbeat())
+		}
+		line := scanner.Text()
+		if line == "" {
+			// The end of an event
+			// The ID is kept for the next events until another id field
+			if data.Len() > 0 {
+				c.handle(data.Bytes(), id)
+				data.Reset()
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			// A comment
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case "id":
+			id = value
+		}
+	}
+	if err = scanner.Err(); err == nil {
+		err = fmt.Errorf("push %s: the stream is closed", c.Name())
+	}
+	return received, err
+}
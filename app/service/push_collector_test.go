@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/skeyic/monitoring/config"
+)
+
+func testPushFeed(pushType, pushURL string) config.PushFeed {
+	return config.PushFeed{
+		Feed: config.Feed{
+			Name:       "push",
+			IDField:    "id",
+			TimeField:  "ts",
+			TextField:  "text",
+			TimeFormat: "2006-01-02T15:04:05",
+		},
+		Type:              pushType,
+		PushURL:           pushURL,
+		HeartbeatSeconds:  1,
+		MinBackoffSeconds: 1,
+		MaxBackoffSeconds: 1,
+	}
+}
+
+func testPushItem(id int) string {
+	return fmt.Sprintf(`{"id":"%d","ts":"2020-12-01T08:0%d:00","text":"快讯 %d"}`, id, id, id)
+}
+
+// waitStored waits until the count of the msgs stored of the source
+func waitStored(t *testing.T, store MsgStore, source string, count int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var stored int
+		store.Scan(source, time.Time{}, time.Time{}, func(*Msg) bool {
+			stored++
+			return true
+		})
+		if stored >= count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d msgs stored, expect %d", stored, count)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPushCollectorSSE(t *testing.T) {
+	var (
+		lock         sync.Mutex
+		lastEventIDs []string
+		done         = make(chan struct{})
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		conn := len(lastEventIDs)
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch conn {
+		case 1:
+			// Dropped after 3 events, the last one keeps the ID e2
+			fmt.Fprintf(w, ": hello\n\nid: e1\nevent: msg\ndata: %s\n\n", testPushItem(1))
+			fmt.Fprintf(w, "id: e2\ndata: [%s,\ndata: %s]\n\n", testPushItem(2), testPushItem(3))
+			fmt.Fprintf(w, "data: %s\n\n", testPushItem(6))
+		case 2:
+			// Silent after an event, down as no heartbeat
+			fmt.Fprintf(w, "id: e4\ndata: %s\n\n", testPushItem(4))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+		default:
+			fmt.Fprintf(w, "retry: 1000\ndata: %s\n\n", testPushItem(5))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	var (
+		store          = NewFileMsgStore(t.TempDir())
+		collector, err = NewPushCollector(testPushFeed(PushTypeSSE, server.URL))
+		ctx, cancel    = context.WithCancel(context.Background())
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.Store(store)
+	go collector.Run(ctx)
+	defer cancel()
+
+	waitStored(t, store, "push", 6)
+	msg, err := store.Get("push", "5")
+	if err != nil || msg.Text != "快讯 5" || !msg.CreatedAt.Equal(time.Date(2020, 12, 1, 0, 5, 0, 0, time.UTC)) {
+		t.Errorf("%+v, %v", msg, err)
+	}
+	// Without the id field, the ID of the msg is the last event ID
+	if id := collector.LastEventID(); id != "5" {
+		t.Errorf("last event ID %s", id)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if got := strings.Join(lastEventIDs, ","); got != ",e2,e4" {
+		t.Errorf("last event IDs %s", got)
+	}
+}
+
+func TestPushCollectorWebSocket(t *testing.T) {
+	var (
+		lock       sync.Mutex
+		subscribes []string
+		upgrader   websocket.Upgrader
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, subscribe, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		lock.Lock()
+		subscribes = append(subscribes, string(subscribe))
+		first := len(subscribes) == 1
+		lock.Unlock()
+
+		if first {
+			// Dropped after 2 messages
+			conn.WriteMessage(websocket.TextMessage, []byte(testPushItem(1)))
+			conn.WriteMessage(websocket.TextMessage, []byte("["+testPushItem(3)+","+testPushItem(2)+"]"))
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(testPushItem(4)))
+		// Reads to answer the pings
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	var (
+		store = NewFileMsgStore(t.TempDir())
+		feed  = testPushFeed(PushTypeWebSocket, "ws"+strings.TrimPrefix(server.URL, "http"))
+	)
+	feed.Subscribe = `{"op":"subscribe","since":"{last_event_id}"}`
+	collector, err := NewPushCollector(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.Store(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		collector.Run(ctx)
+		close(stopped)
+	}()
+
+	waitStored(t, store, "push", 4)
+	// Kept connected by the pongs longer than the heartbeat
+	time.Sleep(1500 * time.Millisecond)
+	if !collector.Connected() {
+		t.Error("expect connected")
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not stopped")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(subscribes) != 2 || subscribes[0] != `{"op":"subscribe","since":""}` || subscribes[1] != `{"op":"subscribe","since":"3"}` {
+		t.Errorf("subscribes %v", subscribes)
+	}
+}
+
+func TestPushCollectorFallback(t *testing.T) {
+	var (
+		feedSrv = feedServer(t)
+		down    = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	)
+	defer feedSrv.Close()
+	defer down.Close()
+
+	feed := testPushFeed(PushTypeSSE, down.URL)
+	feed.Feed = config.Feed{
+		Name:            "push",
+		URL:             feedSrv.URL + "/live?page={page}&size={page_size}",
+		Headers:         map[string]string{"X-Token": "secret"},
+		ListPath:        []string{"data", "list"},
+		IDField:         "ext.id",
+		TimeField:       "ts",
+		TextField:       "body",
+		TimeFormat:      "2006-01-02T15:04:05",
+		FirstPage:       1,
+		PageSize:        2,
+		IntervalSeconds: 1,
+	}
+	collector, err := NewPushCollector(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileMsgStore(t.TempDir())
+	collector.Store(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go collector.Run(ctx)
+
+	// Polled while the push server is down
+	waitStored(t, store, "push", 5)
+	if collector.Connected() {
+		t.Error("expect disconnected")
+	}
+
+	if _, err = NewPushCollector(config.PushFeed{Feed: config.Feed{Name: "bad"}, Type: "mqtt"}); err == nil {
+		t.Error("expect the error of the unknown type")
+	}
+}
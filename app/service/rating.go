@@ -0,0 +1,252 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	RatingUpgrade   = "upgrade"
+	RatingDowngrade = "downgrade"
+	RatingInitiate  = "initiate"
+	RatingMaintain  = "maintain"
+)
+
+var (
+	RatingDirections = []string{RatingUpgrade, RatingDowngrade, RatingInitiate, RatingMaintain}
+
+	ratingCNNames = []string{
+		"强烈推荐", "谨慎推荐", "审慎推荐", "推荐",
+		"跑赢行业", "跑输行业", "跑赢大市", "跑输大市", "优于大市", "同步大市", "弱于大市", "与大市同步",
+		"持股观望", "强烈买入", "审慎增持", "谨慎增持",
+		"买入", "增持", "持有", "中性", "减持", "卖出", "超配", "标配", "低配",
+	}
+	ratingENNames = []string{
+		"strong buy", "buy", "outperform", "overweight", "accumulate",
+		"neutral", "hold", "equal-weight", "equal weight", "market perform", "sector perform", "peer perform",
+		"underperform", "underweight", "reduce", "sell",
+	}
+
+	ratingCurrencies = map[string]string{
+		"$":   "USD",
+		"美元":  "USD",
+		"港元":  "HKD",
+		"港币":  "HKD",
+		"元":   "CNY",
+		"人民币": "CNY",
+	}
+
+	ratingBrokerColonRegexp = regexp.MustCompile(`^\s*【?([^：:，,。\s【】|]{2,12})\s*[：:]`)
+	ratingBrokerVerbRegexp  = regexp.MustCompile(`^\s*([\p{Han}A-Za-z&.]{2,12}?)(?:将|把|首予|首次覆盖|给予|上调|下调|调升|调降|维持|重申)`)
+	ratingBrokerENRegexp    = regexp.MustCompile(`^\s*([A-Z][\w&.]*(?: [A-Z][\w&.]*)*)\s+(?:upgrades|downgrades|initiates|reiterates|maintains|raises|cuts|lowers)\b`)
+
+	ratingCompanyRegexp      = regexp.MustCompile(`([\p{Han}A-Za-z0-9·&.\- ]{1,30}?)\s*[（(]([A-Z0-9]{1,6}(?:\.[A-Z]{1,2})?)[)）]`)
+	ratingCompanyVerbRegexp  = regexp.MustCompile(`(?:将|把|予|维持|重申|上调|下调)([\p{Han}A-Za-z]{2,10}?)的?(?:目标价|评级)`)
+	ratingCompanyPrefixWords = []string{
+		"将", "把", "对", "首予", "首次覆盖", "给予", "予以", "上调", "下调", "调升", "调降", "维持", "重申",
+		"upgrades ", "downgrades ", "initiates ", "reiterates ", "maintains ", "raises ", "cuts ", "lowers ",
+		"coverage of ", "on ",
+	}
+
+	ratingNameRegexp = regexp.MustCompile(`[“"「『]([^“”"「」『』]{1,10})[”"」』]|(` + strings.Join(ratingCNNames, "|") +
+		`)|(?i:\b(` + strings.Join(ratingENNames, "|") + `)\b)`)
+
+	ratingFromRegexp = regexp.MustCompile(`(?i)\bfrom\b`)
+
+	ratingNumber      = `(\d+(?:\.\d+)?)`
+	ratingCNCurrency  = `(美元|港元|港币|人民币|元)?`
+	ratingCNVerb      = `(?:上调|下调|调升|调降|上修|下修|提高|降低|升|降)`
+	ratingTargetRange = regexp.MustCompile(`目标价(?:格)?(?:由|从)\s*` + ratingNumber + `\s*` + ratingCNCurrency +
+		`\s*` + ratingCNVerb + `?\s*至\s*` + ratingNumber + `\s*` + ratingCNCurrency)
+	ratingTargetSingle = regexp.MustCompile(`目标价(?:格)?\s*` + ratingCNVerb + `?\s*(?:至|为|到)?\s*` + ratingNumber + `\s*` + ratingCNCurrency)
+	ratingTargetEN     = regexp.MustCompile(`(?i)(?:price target|target price|\bPT\b)[^$\d]{0,30}\$\s?` + ratingNumber +
+		`(?:[^$\d]{0,10}\bfrom\s+\$\s?` + ratingNumber + `)?`)
+)
+
+// RatingChange is the analyst rating change extracted from a msg
+type RatingChange struct {
+	Broker  string `json:"broker,omitempty"`
+	Company string `json:"company,omitempty"`
+	// As in the msg, e.g. PLUG.US
+	Ticker    string  `json:"ticker,omitempty"`
+	OldRating string  `json:"old_rating,omitempty"`
+	NewRating string  `json:"new_rating,omitempty"`
+	OldTarget float64 `json:"old_target,omitempty"`
+	NewTarget float64 `json:"new_target,omitempty"`
+	// USD, HKD or CNY
+	Currency string `json:"currency,omitempty"`
+	// The direction of the rating, or of the target if the rating is not changed
+	Direction string `json:"direction,omitempty"`
+}
+
+// ExtractRatingChange returns nil if the text is not a rating change
+func ExtractRatingChange(text string) *RatingChange {
+	lower := strings.ToLower(text)
+	if !strings.Contains(text, "评级") && !strings.Contains(text, "目标价") &&
+		!strings.Contains(lower, "rating") && !strings.Contains(lower, "price target") &&
+		!ratingBrokerENRegexp.MatchString(text) {
+		return nil
+	}
+
+	r := &RatingChange{}
+	r.extractBroker(text)
+	r.extractCompany(text)
+	r.extractRatings(text)
+	r.extractTargets(text)
+	if r.NewRating == "" && r.NewTarget == 0 {
+		return nil
+	}
+	r.extractDirection(lower)
+	return r
+}
+
+func (r *RatingChange) extractBroker(text string) {
+	for _, re := range []*regexp.Regexp{ratingBrokerColonRegexp, ratingBrokerENRegexp, ratingBrokerVerbRegexp} {
+		if m := re.FindStringSubmatch(text); m != nil && !strings.Contains(m[1], "评级") && !strings.Contains(m[1], "目标价") {
+			r.Broker = m[1]
+			return
+		}
+	}
+}
+
+func (r *RatingChange) extractCompany(text string) {
+	if r.Broker != "" {
+		if idx := strings.Index(text, r.Broker); idx >= 0 {
+			text = text[idx+len(r.Broker):]
+		}
+	}
+	if m := ratingCompanyRegexp.FindStringSubmatch(text); m != nil {
+		r.Company, r.Ticker = trimCompanyPrefix(m[1]), m[2]
+		return
+	}
+	if m := ratingCompanyVerbRegexp.FindStringSubmatch(text); m != nil {
+		r.Company = trimCompanyPrefix(m[1])
+	}
+}
+
+// trimCompanyPrefix cuts the verbs before the company name
+func trimCompanyPrefix(company string) string {
+	for _, word := range ratingCompanyPrefixWords {
+		if idx := strings.LastIndex(company, word); idx >= 0 {
+			company = company[idx+len(word):]
+		}
+	}
+	return strings.Trim(company, " :：，,")
+}
+
+func (r *RatingChange) extractRatings(text string) {
+	var ratings []string
+	for _, m := range ratingNameRegexp.FindAllStringSubmatchIndex(text, -1) {
+		for group := 1; group <= 3; group++ {
+			if m[2*group] >= 0 {
+				ratings = append(ratings, text[m[2*group]:m[2*group+1]])
+				break
+			}
+		}
+	}
+
+	switch {
+	case len(ratings) == 0:
+	case len(ratings) == 1:
+		r.NewRating = ratings[0]
+	case ratingFromRegexp.MatchString(text):
+		// to Buy from Neutral
+		r.NewRating, r.OldRating = ratings[0], ratings[1]
+	default:
+		// 从“增持”下调至“持股观望”
+		r.OldRating, r.NewRating = ratings[0], ratings[1]
+	}
+}
+
+func (r *RatingChange) extractTargets(text string) {
+	if m := ratingTargetRange.FindStringSubmatch(text); m != nil {
+		r.OldTarget, _ = strconv.ParseFloat(m[1], 64)
+		r.NewTarget, _ = strconv.ParseFloat(m[3], 64)
+		r.Currency = ratingCurrencies[m[4]]
+		if r.Currency == "" {
+			r.Currency = ratingCurrencies[m[2]]
+		}
+	} else if m := ratingTargetSingle.FindStringSubmatch(text); m != nil {
+		r.NewTarget, _ = strconv.ParseFloat(m[1], 64)
+		r.Currency = ratingCurrencies[m[2]]
+	} else if m := ratingTargetEN.FindStringSubmatch(text); m != nil {
+		r.NewTarget, _ = strconv.ParseFloat(m[1], 64)
+		if m[2] != "" {
+			r.OldTarget, _ = strconv.ParseFloat(m[2], 64)
+		}
+		r.Currency = ratingCurrencies["$"]
+	}
+
+	if r.NewTarget != 0 && r.Currency == "" {
+		switch {
+		case strings.HasSuffix(r.Ticker, ".HK"):
+			r.Currency = "HKD"
+		case strings.HasSuffix(r.Ticker, ".US"):
+			r.Currency = "USD"
+		case strings.HasSuffix(r.Ticker, ".SH"), strings.HasSuffix(r.Ticker, ".SZ"):
+			r.Currency = "CNY"
+		}
+	}
+}
+
+func (r *RatingChange) extractDirection(lower string) {
+	has := func(words ...string) bool {
+		for _, word := range words {
+			if strings.Contains(lower, word) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("首予", "首次覆盖", "首次给予", "initiate"):
+		r.Direction = RatingInitiate
+	case has("维持", "重申", "reiterate", "maintain"):
+		r.Direction = RatingMaintain
+	case has("upgrade", "评级上调", "上调评级", "调升评级", "评级调升"),
+		r.OldRating != "" && has("上调", "调升", "升至"):
+		r.Direction = RatingUpgrade
+	case has("downgrade", "评级下调", "下调评级", "调降评级", "评级调降"),
+		r.OldRating != "" && has("下调", "调降", "降至"):
+		r.Direction = RatingDowngrade
+	case r.OldTarget != 0 && r.NewTarget > r.OldTarget, has("上调", "调升", "上修", "提高", "raise"):
+		r.Direction = RatingUpgrade
+	case r.OldTarget != 0 && r.NewTarget < r.OldTarget, has("下调", "调降", "下修", "降低", "cut", "lower"):
+		r.Direction = RatingDowngrade
+	}
+}
+
+// String is the short summary used in the alerts
+func (r *RatingChange) String() string {
+	var parts []string
+	if r.Broker != "" {
+		parts = append(parts, r.Broker)
+	}
+	if r.Direction != "" {
+		parts = append(parts, r.Direction)
+	}
+	switch {
+	case r.Company != "" && r.Ticker != "":
+		parts = append(parts, fmt.Sprintf("%s(%s)", r.Company, r.Ticker))
+	case r.Company != "" || r.Ticker != "":
+		parts = append(parts, r.Company+r.Ticker)
+	}
+	if r.NewRating != "" {
+		if r.OldRating != "" {
+			parts = append(parts, fmt.Sprintf("%s -> %s", r.OldRating, r.NewRating))
+		} else {
+			parts = append(parts, r.NewRating)
+		}
+	}
+	if r.NewTarget != 0 {
+		target := strconv.FormatFloat(r.NewTarget, 'f', -1, 64)
+		if r.OldTarget != 0 {
+			target = strconv.FormatFloat(r.OldTarget, 'f', -1, 64) + " -> " + target
+		}
+		parts = append(parts, strings.TrimSpace(target+" "+r.Currency))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+type ratingGolden struct {
+	Source string        `json:"source"`
+	ID     string        `json:"id"`
+	Text   string        `json:"text"`
+	Rating *RatingChange `json:"rating"`
+}
+
+// The handwritten cases of the patterns, the English ones are of the English feeds
+func TestExtractRatingChange(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want *RatingChange
+	}{
+		{"摩根士丹利：上调普拉格能源(PLUG.US)评级至“增持”，目标价由25美元上调至40美元", &RatingChange{Broker: "摩根士丹利", Company: "普拉格能源", Ticker: "PLUG.US", NewRating: "增持", OldTarget: 25, NewTarget: 40, Currency: "USD", Direction: RatingUpgrade}},
+		{"高盛：下调特斯拉(TSLA.US)评级至“中性”，目标价780美元", &RatingChange{Broker: "高盛", Company: "特斯拉", Ticker: "TSLA.US", NewRating: "中性", NewTarget: 780, Currency: "USD", Direction: RatingDowngrade}},
+		{"瑞银：首予小鹏汽车(XPEV.US)“买入”评级，目标价58美元", &RatingChange{Broker: "瑞银", Company: "小鹏汽车", Ticker: "XPEV.US", NewRating: "买入", NewTarget: 58, Currency: "USD", Direction: RatingInitiate}},
+		{"花旗：维持腾讯控股(00700.HK)“买入”评级，目标价由650港元上调至720港元", &RatingChange{Broker: "花旗", Company: "腾讯控股", Ticker: "00700.HK", NewRating: "买入", OldTarget: 650, NewTarget: 720, Currency: "HKD", Direction: RatingMaintain}},
+		{"大摩：将阿里巴巴(BABA.US)评级从“增持”下调至“持股观望”，目标价从325美元下调至270美元", &RatingChange{Broker: "大摩", Company: "阿里巴巴", Ticker: "BABA.US", OldRating: "增持", NewRating: "持股观望", OldTarget: 325, NewTarget: 270, Currency: "USD", Direction: RatingDowngrade}},
+		{"中金：贵州茅台(600519.SH)目标价上调至2200元，维持“跑赢行业”评级", &RatingChange{Broker: "中金", Company: "贵州茅台", Ticker: "600519.SH", NewRating: "跑赢行业", NewTarget: 2200, Currency: "CNY", Direction: RatingMaintain}},
+		{"杰富瑞将蔚来汽车的目标价从45美元上调至60美元", &RatingChange{Broker: "杰富瑞", Company: "蔚来汽车", OldTarget: 45, NewTarget: 60, Currency: "USD", Direction: RatingUpgrade}},
+		{"Goldman Sachs upgrades Plug Power (PLUG) to Buy from Neutral, price target raised to $45 from $30", &RatingChange{Broker: "Goldman Sachs", Company: "Plug Power", Ticker: "PLUG", OldRating: "Neutral", NewRating: "Buy", OldTarget: 30, NewTarget: 45, Currency: "USD", Direction: RatingUpgrade}},
+		{"Morgan Stanley initiates coverage of XPeng (XPEV) with Overweight rating, price target $55", &RatingChange{Broker: "Morgan Stanley", Company: "XPeng", Ticker: "XPEV", NewRating: "Overweight", NewTarget: 55, Currency: "USD", Direction: RatingInitiate}},
+		{"摩根大通：美团(03690.HK)评级由“中性”上调至“增持”，目标价升至420港元", &RatingChange{Broker: "摩根大通", Company: "美团", Ticker: "03690.HK", OldRating: "中性", NewRating: "增持", NewTarget: 420, Currency: "HKD", Direction: RatingUpgrade}},
+		{"招商证券：首次覆盖宁德时代(300750.SZ)，给予“强烈推荐”评级", &RatingChange{Broker: "招商证券", Company: "宁德时代", Ticker: "300750.SZ", NewRating: "强烈推荐", Direction: RatingInitiate}},
+		{"美股异动 | 普拉格能源涨超10%，氢能源板块走强", nil},
+		{"富途早报：美股三大指数集体收涨，纳指创历史新高", nil},
+		{"瑞信：重申京东(JD.US)跑赢大市评级，目标价96美元", &RatingChange{Broker: "瑞信", Company: "京东", Ticker: "JD.US", NewRating: "跑赢大市", NewTarget: 96, Currency: "USD", Direction: RatingMaintain}},
+	} {
+		if got := ExtractRatingChange(c.text); !reflect.DeepEqual(got, c.want) {
+			g, _ := json.Marshal(got)
+			w, _ := json.Marshal(c.want)
+			t.Errorf("%s\n got: %s\nwant: %s", c.text, g, w)
+		}
+	}
+}
+
+// The corpus is in the format of store export, scripts/capture_ratings.sh appends the rating msgs captured
+// from Futu and Sina to it. Run with -update to regenerate the golden file after reviewing the changes.
+func TestExtractRatingChangeGolden(t *testing.T) {
+	const (
+		corpusFile = "testdata/ratings.jsonl"
+		goldenFile = "testdata/ratings.golden.json"
+	)
+	f, err := os.Open(corpusFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	msgs, err := ReadMsgs(f, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []ratingGolden
+	for _, msg := range msgs {
+		got = append(got, ratingGolden{Source: msg.Source, ID: msg.ID, Text: msg.Text, Rating: ExtractRatingChange(msg.Text)})
+	}
+
+	if *updateGolden {
+		data, _ := json.MarshalIndent(got, "", "  ")
+		if err = os.WriteFile(goldenFile, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []ratingGolden
+	if err = json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d, run with -update after changing the corpus", len(got), len(want))
+	}
+	for idx := range want {
+		if !reflect.DeepEqual(got[idx], want[idx]) {
+			g, _ := json.Marshal(got[idx].Rating)
+			w, _ := json.Marshal(want[idx].Rating)
+			t.Errorf("%s/%s %s\n got: %s\nwant: %s", want[idx].Source, want[idx].ID, want[idx].Text, g, w)
+		}
+	}
+}
+
+func TestKeywordMsgFilterDirections(t *testing.T) {
+	rule := NewKeywordMsgFilter(config.Rule{Name: "upgrades", Directions: []string{RatingUpgrade, RatingInitiate}})
+	for text, want := range map[string]bool{
+		"摩根士丹利：上调普拉格能源(PLUG.US)评级至“增持”，目标价由25美元上调至40美元": true,
+		"瑞银：首予小鹏汽车(XPEV.US)“买入”评级，目标价58美元":              true,
+		"高盛：下调特斯拉(TSLA.US)评级至“中性”，目标价780美元":             false,
+		"普拉格能源涨超10%": false,
+	} {
+		if got := rule.Match((&Msg{Text: text}).Extract()); got != want {
+			t.Errorf("%s: got %v, want %v", text, got, want)
+		}
+	}
+}
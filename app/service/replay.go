@@ -0,0 +1,168 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ReplayDefaultSamples = 3
+	replayBatchSize      = 1000
+)
+
+// ReplayReport tells how noisy the rules would have been
+type ReplayReport struct {
+	Source string    `json:"source,omitempty"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// Number of msgs replayed
+	Total int      `json:"total"`
+	Rules []string `json:"rules"`
+	Days  []string `json:"days"`
+	// Rule -> date -> number of matched msgs
+	Counts map[string]map[string]int `json:"counts"`
+	Totals map[string]int            `json:"totals"`
+	// The latest matched msgs of each rule
+	Samples map[string][]*Msg `json:"samples"`
+	// Rule -> rule -> number of msgs matched by both
+	Overlaps map[string]map[string]int `json:"overlaps"`
+}
+
+// Replay applies the rules to the stored msgs created in [from, to) without alerting
+func Replay(store MsgStore, filters []MsgFilter, source string, from, to time.Time, samples int) (report *ReplayReport, err error) {
+	report = &ReplayReport{
+		Source:   source,
+		From:     from,
+		To:       to,
+		Counts:   make(map[string]map[string]int),
+		Totals:   make(map[string]int),
+		Samples:  make(map[string][]*Msg),
+		Overlaps: make(map[string]map[string]int),
+	}
+	for _, filter := range filters {
+		report.Rules = append(report.Rules, filter.Name())
+		report.Counts[filter.Name()] = make(map[string]int)
+	}
+
+	if samples <= 0 {
+		samples = ReplayDefaultSamples
+	}
+
+	var (
+		batch []*Msg
+		days  = make(map[string]bool)
+	)
+	replay := func() {
+		var (
+			// Msg -> rules matched it
+			matched = make(map[*Msg][]string)
+		)
+		ApplyFilter(filters, batch, func(filter MsgFilter, msg *Msg) {
+			var (
+				name = filter.Name()
+				date = AnalysisDate(msg.CreatedAt)
+			)
+			days[date] = true
+			report.Counts[name][date]++
+			report.Totals[name]++
+			report.Samples[name] = append(report.Samples[name], msg)
+			if len(report.Samples[name]) > 2*samples {
+				report.Samples[name] = latestMsgs(report.Samples[name], samples)
+			}
+			matched[msg] = append(matched[msg], name)
+		})
+
+		for _, names := range matched {
+			for _, a := range names {
+				for _, b := range names {
+					if a == b {
+						continue
+					}
+					if report.Overlaps[a] == nil {
+						report.Overlaps[a] = make(map[string]int)
+					}
+					report.Overlaps[a][b]++
+				}
+			}
+		}
+		report.Total += len(batch)
+		batch = batch[:0]
+	}
+
+	err = store.Scan(source, from, to, func(msg *Msg) bool {
+		batch = append(batch, msg)
+		if len(batch) == replayBatchSize {
+			replay()
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	replay()
+
+	for day := range days {
+		report.Days = append(report.Days, day)
+	}
+	sort.Strings(report.Days)
+
+	for name, msgs := range report.Samples {
+		report.Samples[name] = latestMsgs(msgs, samples)
+	}
+	return
+}
+
+func latestMsgs(msgs []*Msg, n int) []*Msg {
+	sort.Sort(Msgs(msgs))
+	if len(msgs) > n {
+		return msgs[:n]
+	}
+	return msgs
+}
+
+// Print writes the report as tables
+func (r *ReplayReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "REPLAYED %d MSGS\n\n", r.Total)
+
+	fmt.Fprintf(w, "%-12s", "DATE")
+	for _, name := range r.Rules {
+		fmt.Fprintf(w, " %12s", name)
+	}
+	fmt.Fprintln(w)
+	for _, day := range r.Days {
+		fmt.Fprintf(w, "%-12s", day)
+		for _, name := range r.Rules {
+			fmt.Fprintf(w, " %12d", r.Counts[name][day])
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%-12s", "TOTAL")
+	for _, name := range r.Rules {
+		fmt.Fprintf(w, " %12d", r.Totals[name])
+	}
+	fmt.Fprintln(w)
+
+	if len(r.Overlaps) > 0 {
+		fmt.Fprintf(w, "\nOVERLAPS\n")
+		for _, a := range r.Rules {
+			for _, b := range r.Rules {
+				if a < b && r.Overlaps[a][b] > 0 {
+					fmt.Fprintf(w, "%s & %s: %d\n", a, b, r.Overlaps[a][b])
+				}
+			}
+		}
+	}
+
+	for _, name := range r.Rules {
+		if len(r.Samples[name]) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nSAMPLES OF %s\n", name)
+		for _, msg := range r.Samples[name] {
+			fmt.Fprintf(w, "%s %s/%s %s\n", msg.LocalTime(), msg.Source, msg.ID, strings.ReplaceAll(msg.Text, "\n", " "))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestReplay(t *testing.T) {
+	var (
+		store = NewFileMsgStore(t.TempDir())
+		day1  = time.Date(2020, 12, 1, 2, 0, 0, 0, time.UTC)
+		day2  = day1.Add(24 * time.Hour)
+		plug  = NewKeywordMsgFilter(config.Rule{Name: "plug", Keywords: []string{"普拉格"}})
+		rate  = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+	)
+	err := store.Save([]*Msg{
+		{Source: "futu", ID: "1", CreatedAt: day1, Text: "普拉格 目标价 上调至 40"},
+		{Source: "futu", ID: "2", CreatedAt: day1.Add(time.Hour), Text: "普拉格 大涨"},
+		{Source: "futu", ID: "3", CreatedAt: day2, Text: "特斯拉 目标价 上调至 800"},
+		{Source: "futu", ID: "4", CreatedAt: day2.Add(time.Hour), Text: "普拉格 发布财报"},
+		{Source: "futu", ID: "5", CreatedAt: day2.Add(2 * time.Hour), Text: "无关"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Replay(store, []MsgFilter{plug, rate}, "", time.Time{}, time.Time{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 5 {
+		t.Errorf("total %d, want 5", report.Total)
+	}
+	if strings.Join(report.Days, ",") != "2020-12-01,2020-12-02" {
+		t.Errorf("days %v", report.Days)
+	}
+	if report.Counts["plug"]["2020-12-01"] != 2 || report.Counts["plug"]["2020-12-02"] != 1 || report.Totals["plug"] != 3 {
+		t.Errorf("plug counts %v", report.Counts["plug"])
+	}
+	if report.Counts["rate"]["2020-12-01"] != 1 || report.Counts["rate"]["2020-12-02"] != 1 || report.Totals["rate"] != 2 {
+		t.Errorf("rate counts %v", report.Counts["rate"])
+	}
+	if report.Overlaps["plug"]["rate"] != 1 || report.Overlaps["rate"]["plug"] != 1 {
+		t.Errorf("overlaps %v", report.Overlaps)
+	}
+	if samples := report.Samples["plug"]; len(samples) != 2 || samples[0].ID != "4" || samples[1].ID != "2" {
+		t.Errorf("plug samples %v", samples)
+	}
+
+	// Only the second day
+	report, err = Replay(store, []MsgFilter{plug, rate}, "futu", day2, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 3 || report.Totals["plug"] != 1 || len(report.Overlaps) != 0 {
+		t.Errorf("replay since day 2: %+v", report)
+	}
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+	if !strings.Contains(buf.String(), "SAMPLES OF plug") {
+		t.Errorf("print:\n%s", buf.String())
+	}
+}
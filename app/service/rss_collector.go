@@ -0,0 +1,283 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	RSSDefaultInterval = 5 * time.Minute
+)
+
+var (
+	TheRSSCollectors = newRSSCollectors(config.Config.RSSFeeds)
+
+	rssTimeLayouts = []string{
+		time.RFC1123Z,
+		time.RFC1123,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 MST",
+		"2 Jan 2006 15:04:05 -0700",
+		time.RFC822Z,
+		time.RFC822,
+		time.RFC3339,
+	}
+)
+
+func newRSSCollectors(feeds []config.RSSFeed) map[string]*RSSCollector {
+	collectors := make(map[string]*RSSCollector)
+	for _, feed := range feeds {
+		collectors[feed.Name] = NewRSSCollector(feed)
+	}
+	return collectors
+}
+
+// rssDocument is either an RSS 2.0 channel or an Atom feed
+type rssDocument struct {
+	XMLName xml.Name
+	// RSS
+	Items []rssItem `xml:"channel>item"`
+	// Atom
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Link        string `xml:"link"`
+	Title       string `xml:"title"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+// RSSCollector collects the items of an RSS 2.0 or Atom feed, only the changed feed is downloaded when polling
+type RSSCollector struct {
+	feed    config.RSSFeed
+	store   MsgStore
+	filters []MsgFilter
+	now     func() time.Time
+
+	lock sync.Mutex
+	// Of the conditional GET
+	etag         string
+	lastModified string
+}
+
+func NewRSSCollector(feed config.RSSFeed) *RSSCollector {
+	if feed.IntervalSeconds <= 0 {
+		feed.IntervalSeconds = int(RSSDefaultInterval / time.Second)
+	}
+	return &RSSCollector{
+		feed:  feed,
+		store: TheMsgStore,
+		now:   time.Now,
+	}
+}
+
+func (c *RSSCollector) Store(store MsgStore) *RSSCollector {
+	c.store = store
+	return c
+}
+
+func (c *RSSCollector) AddFilter(f MsgFilter) {
+	c.filters = append(c.filters, f)
+}
+
+func (c *RSSCollector) Name() string {
+	return c.feed.Name
+}
+
+// DefaultPageSize is 0 as the feed is a single page of any size
+func (c *RSSCollector) DefaultPageSize() int {
+	return 0
+}
+
+// Fetch gets all the items of the feed as page 0, there is no other page
+func (c *RSSCollector) Fetch(page, pageSize int) (msgs []*Msg, err error) {
+	if page > 0 {
+		return nil, nil
+	}
+	msgs, err = c.fetch(false)
+	if pageSize > 0 && len(msgs) > pageSize {
+		msgs = msgs[:pageSize]
+	}
+	return
+}
+
+// fetch gets the items, none if conditional and the feed is not modified since the last time
+func (c *RSSCollector) fetch(conditional bool) (msgs []*Msg, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	headers := map[string]string{
+		"Accept": "application/rss+xml, application/atom+xml, application/xml, text/xml",
+	}
+	for key, value := range c.feed.Headers {
+		headers[key] = value
+	}
+	if conditional {
+		if c.etag != "" {
+			headers["If-None-Match"] = c.etag
+		}
+		if c.lastModified != "" {
+			headers["If-Modified-Since"] = c.lastModified
+		}
+	}
+
+	rCode, rBody, rHeader, err := utils.SendRequestForHeader(http.MethodGet, c.feed.URL, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	switch rCode {
+	case http.StatusNotModified:
+		glog.V(4).Infof("RSS %s IS NOT MODIFIED", c.feed.Name)
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("rss %s responded %d", c.feed.Name, rCode)
+	}
+
+	if msgs, err = c.parse([]byte(rBody)); err != nil {
+		return nil, err
+	}
+	// Only the polls know what are collected
+	if conditional {
+		c.etag, c.lastModified = rHeader.Get("ETag"), rHeader.Get("Last-Modified")
+	}
+	return msgs, nil
+}
+
+// parse reads the items of RSS 2.0 or the entries of Atom, the newest first
+func (c *RSSCollector) parse(body []byte) (msgs []*Msg, err error) {
+	var (
+		doc     rssDocument
+		decoder = xml.NewDecoder(bytes.NewReader(body))
+	)
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err = decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("rss %s: %v", c.feed.Name, err)
+	}
+
+	switch doc.XMLName.Local {
+	case "rss":
+		for _, item := range doc.Items {
+			content := item.Content
+			if content == "" {
+				content = item.Description
+			}
+			msgs = append(msgs, c.newMsg(firstNonEmpty(item.GUID, item.Link), item.Title, content, firstNonEmpty(item.PubDate, item.Date)))
+		}
+	case "feed":
+		for _, entry := range doc.Entries {
+			var link string
+			for _, l := range entry.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+			content := entry.Content
+			if content == "" {
+				content = entry.Summary
+			}
+			msgs = append(msgs, c.newMsg(firstNonEmpty(entry.ID, link), entry.Title, content, firstNonEmpty(entry.Published, entry.Updated)))
+		}
+	default:
+		return nil, fmt.Errorf("rss %s: unknown root element %s, expect rss or feed", c.feed.Name, doc.XMLName.Local)
+	}
+
+	sort.Stable(Msgs(msgs))
+	return msgs, nil
+}
+
+func (c *RSSCollector) newMsg(guid, title, content, published string) *Msg {
+	var (
+		text = strings.TrimSpace(stripHTML(title))
+		body = strings.TrimSpace(stripHTML(content))
+	)
+	if body != "" && body != text {
+		text = strings.TrimSpace(text + "\n" + body)
+	}
+	if guid == "" {
+		// Neither the GUID nor the link, the same title at the same time is the same item
+		sum := sha1.Sum([]byte(title + published))
+		guid = hex.EncodeToString(sum[:])
+	}
+
+	msg := &Msg{
+		Source:     c.feed.Name,
+		ID:         strings.TrimSpace(guid),
+		CreateTime: strings.TrimSpace(published),
+		Text:       text,
+	}
+	t, err := ParseRSSTime(msg.CreateTime)
+	if err != nil {
+		glog.Warningf("rss %s: use the fetch time of item %s, ERR: %v", c.feed.Name, msg.ID, err)
+		t = c.now()
+	}
+	msg.CreatedAt = t.UTC()
+	return msg.Extract()
+}
+
+// ParseRSSTime parses the dates of RSS and Atom, and the common layouts of the sources
+func ParseRSSTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("missing the published time")
+	}
+	for _, layout := range rssTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return utils.NewSourceTimeParser(utils.ShanghaiLocation, time.Now()).Parse(value)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Poll gets the feed if modified, stores the new items and applies the filters
+func (c *RSSCollector) Poll() (fresh []*Msg, err error) {
+	msgs, err := c.fetch(true)
+	if err != nil {
+		return
+	}
+	return CollectFresh(c.store, c.filters, msgs)
+}
+
+// Start polls the feed every interval
+func (c *RSSCollector) Start() {
+	startPolling(c.Name(), time.Duration(c.feed.IntervalSeconds)*time.Second, c.Poll)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	testRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+<channel>
+  <title>公告</title>
+  <item>
+    <title>腾讯控股：拟回购股份</title>
+    <link>https://example.com/2</link>
+    <guid isPermaLink="false">notice-2</guid>
+    <pubDate>Tue, 01 Dec 2020 08:30:00 +0800</pubDate>
+    <description><![CDATA[<p>拟回购不超过<b>10亿港元</b>股份</p>]]></description>
+  </item>
+  <item>
+    <title>停牌公告</title>
+    <link>https://example.com/1</link>
+    <pubDate>Tue, 1 Dec 2020 00:10:00 GMT</pubDate>
+    <content:encoded>&lt;p&gt;某公司 停牌&lt;/p&gt;</content:encoded>
+  </item>
+</channel>
+</rss>`
+
+	testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Exchange</title>
+  <entry>
+    <id>urn:uuid:1</id>
+    <title>Trading halt</title>
+    <link rel="alternate" href="https://example.com/halt"/>
+    <updated>2020-12-01T01:00:00Z</updated>
+    <summary type="html">&lt;p&gt;Trading in PLUG is halted&lt;/p&gt;</summary>
+  </entry>
+</feed>`
+)
+
+func TestRSSCollector(t *testing.T) {
+	var (
+		notModified int
+		server      = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/atom" {
+				w.Write([]byte(testAtom))
+				return
+			}
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(testRSS))
+		}))
+	)
+	defer server.Close()
+
+	var (
+		store     = NewFileMsgStore(t.TempDir())
+		collector = NewRSSCollector(config.RSSFeed{Name: "notice", URL: server.URL + "/rss"}).Store(store)
+	)
+
+	msgs, err := collector.Fetch(0, 0)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("%v, %v", msgs, err)
+	}
+	if msg := msgs[1]; msg.ID != "https://example.com/1" || msg.Text != "停牌公告\n某公司 停牌" ||
+		!msg.CreatedAt.Equal(time.Date(2020, 12, 1, 0, 10, 0, 0, time.UTC)) {
+		t.Errorf("msg %+v", msg)
+	}
+	if msg := msgs[0]; msg.ID != "notice-2" || msg.Text != "腾讯控股：拟回购股份\n拟回购不超过10亿港元股份" {
+		t.Errorf("msg %+v", msg)
+	}
+
+	fresh, err := collector.Poll()
+	if err != nil || len(fresh) != 2 {
+		t.Fatalf("%d fresh, %v", len(fresh), err)
+	}
+
+	// Not modified since the last poll
+	if fresh, err = collector.Poll(); err != nil || len(fresh) != 0 || notModified != 1 {
+		t.Errorf("%d fresh, %d not modified, %v", len(fresh), notModified, err)
+	}
+
+	atom := NewRSSCollector(config.RSSFeed{Name: "exchange", URL: server.URL + "/atom"})
+	if msgs, err = atom.Fetch(0, 0); err != nil || len(msgs) != 1 {
+		t.Fatalf("%v, %v", msgs, err)
+	}
+	if msg := msgs[0]; msg.ID != "urn:uuid:1" || !strings.HasSuffix(msg.Text, "Trading in PLUG is halted") || len(msg.Symbols) != 1 {
+		t.Errorf("msg %+v", msg)
+	}
+}
+
+func TestParseRSSTime(t *testing.T) {
+	expected := time.Date(2020, 12, 1, 0, 30, 0, 0, time.UTC)
+	for _, value := range []string{
+		"Tue, 01 Dec 2020 08:30:00 +0800",
+		"Tue, 1 Dec 2020 00:30:00 GMT",
+		"2020-12-01T00:30:00Z",
+		"2020-12-01 08:30:00",
+	} {
+		if got, err := ParseRSSTime(value); err != nil || !got.Equal(expected) {
+			t.Errorf("%s: %v, %v", value, got, err)
+		}
+	}
+	if _, err := ParseRSSTime("yesterday"); err == nil {
+		t.Error("expect the error of yesterday")
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+var (
+	builtinRules = map[string]func() MsgFilter{
+		"rate": func() MsgFilter { return NewRateMsgFilter() },
+		"test": func() MsgFilter { return NewTestMsgFilter() },
+	}
+)
+
+// KeywordMsgFilter is the rule defined in the config
+type KeywordMsgFilter struct {
+	rule config.Rule
+}
+
+func NewKeywordMsgFilter(rule config.Rule) KeywordMsgFilter {
+	return KeywordMsgFilter{rule: rule}
+}
+
+func (r KeywordMsgFilter) Name() string {
+	return r.rule.Name
+}
+
+func (r KeywordMsgFilter) Match(msg *Msg) bool {
+	for _, keyword := range r.rule.Keywords {
+		if !strings.Contains(msg.Text, keyword) {
+			return false
+		}
+	}
+	for _, keyword := range r.rule.ExcludeKeywords {
+		if strings.Contains(msg.Text, keyword) {
+			return false
+		}
+	}
+	if len(r.rule.AnyKeywords) > 0 {
+		matched := false
+		for _, keyword := range r.rule.AnyKeywords {
+			if strings.Contains(msg.Text, keyword) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.rule.Directions) > 0 {
+		if msg.Rating == nil || !slices.Contains(r.rule.Directions, msg.Rating.Direction) {
+			return false
+		}
+	}
+	if len(r.rule.Watchlists) > 0 && !matchWatchlists(r.rule.Watchlists, msg) {
+		return false
+	}
+	glog.V(4).Infof("MATCH RULE %s MSG: %+v\n", r.rule.Name, msg)
+	return true
+}
+
+// matchWatchlists tells if the msg mentions a symbol in any of the watchlists
+func matchWatchlists(watchlists []string, msg *Msg) bool {
+	for _, name := range watchlists {
+		if w, err := GetWatchlist(name); err == nil && w.Match(msg.Symbols) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r KeywordMsgFilter) Priority() string {
+	return r.rule.Priority
+}
+
+func (r KeywordMsgFilter) Keywords() []string {
+	return append(append([]string{}, r.rule.Keywords...), r.rule.AnyKeywords...)
+}
+
+func (r KeywordMsgFilter) Format(msg *Msg) (title, content string) {
+	return fmt.Sprintf("%s %s", r.rule.Name, msg.LocalTime()), msg.Text
+}
+
+func (r KeywordMsgFilter) Alert(msg *Msg) error {
+	return utils.SendAlertV2(FormatAlert(r, DefaultNotifierName, "", msg))
+}
+
+// GetRule returns the built-in rule or the rule defined in the config
+func GetRule(name string) (MsgFilter, error) {
+	for _, rule := range config.Config.Rules {
+		if rule.Name == name {
+			return NewKeywordMsgFilter(rule), nil
+		}
+	}
+	if newRule, hit := builtinRules[name]; hit {
+		return newRule(), nil
+	}
+	return nil, fmt.Errorf("unknown rule: %s", name)
+}
+
+// GetRules returns the rules by names, all the rules if names is empty
+func GetRules(names []string) (rules []MsgFilter, err error) {
+	if len(names) == 0 {
+		names = RuleNames()
+	}
+	for _, name := range names {
+		rule, err := GetRule(name)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
+
+func RuleNames() (names []string) {
+	for name := range builtinRules {
+		names = append(names, name)
+	}
+	for _, rule := range config.Config.Rules {
+		if _, hit := builtinRules[rule.Name]; !hit {
+			names = append(names, rule.Name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// ValidateRules checks the rules referenced by the config exist
+func ValidateRules(c *config.Configuration) (errs []error) {
+	names := make(map[string]bool)
+	for name := range builtinRules {
+		names[name] = true
+	}
+	for _, rule := range c.Rules {
+		names[rule.Name] = true
+		for _, direction := range rule.Directions {
+			if !slices.Contains(RatingDirections, direction) {
+				errs = append(errs, fmt.Errorf("Rules: %s has unknown direction %s, expect %v", rule.Name, direction, RatingDirections))
+			}
+		}
+	}
+	for _, name := range c.Collector.Rules {
+		if !names[name] {
+			errs = append(errs, fmt.Errorf("Collector.Rules: unknown rule %s", name))
+		}
+	}
+	return
+}
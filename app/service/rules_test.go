@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func TestKeywordMsgFilter(t *testing.T) {
+	rule := NewKeywordMsgFilter(config.Rule{
+		Name:            "plug",
+		Keywords:        []string{"目标价"},
+		AnyKeywords:     []string{"PLUG", "普拉格"},
+		ExcludeKeywords: []string{"下调"},
+	})
+
+	cases := map[string]bool{
+		"大摩上调普拉格目标价至45美元": true,
+		"大摩上调PLUG目标价":     true,
+		"大摩下调普拉格目标价":      false,
+		"普拉格涨超10%":        false,
+		"大摩上调特斯拉目标价":      false,
+	}
+	for text, expected := range cases {
+		if rule.Match(&Msg{Text: text}) != expected {
+			t.Errorf("%s, expected %v", text, expected)
+		}
+	}
+}
+
+func TestGetRule(t *testing.T) {
+	defer func(rules []config.Rule) { config.Config.Rules = rules }(config.Config.Rules)
+	config.Config.Rules = []config.Rule{{Name: "plug", AnyKeywords: []string{"PLUG"}}}
+
+	if _, err := GetRule("rate"); err != nil {
+		t.Errorf("built-in rule: %v", err)
+	}
+	if rule, err := GetRule("plug"); err != nil || !rule.Match(&Msg{Text: "PLUG"}) {
+		t.Errorf("config rule: %v", err)
+	}
+	if _, err := GetRule("nope"); err == nil {
+		t.Errorf("expected error for unknown rule")
+	}
+
+	c := &config.Configuration{}
+	c.Collector.Rules = []string{"rate", "nope"}
+	if errs := ValidateRules(c); len(errs) != 1 {
+		t.Errorf("expected 1 error, got %v", errs)
+	}
+}
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	SearchDefaultLimit = 50
+)
+
+var (
+	TheSearchIndex = NewSearchIndex(theBackendMsgStore)
+)
+
+func init() {
+	if config.Config.Search.Dictionary != "" {
+		if err := utils.TheDictionary.LoadFile(config.Config.Search.Dictionary); err != nil {
+			glog.Errorf("failed to load the search dictionary %s, ERR: %v", config.Config.Search.Dictionary, err)
+		}
+	}
+}
+
+// IndexedMsgStore adds the saved msgs to the search index
+type IndexedMsgStore struct {
+	MsgStore
+	index *SearchIndex
+}
+
+func NewIndexedMsgStore(store MsgStore, index *SearchIndex) *IndexedMsgStore {
+	return &IndexedMsgStore{
+		MsgStore: store,
+		index:    index,
+	}
+}
+
+func (s *IndexedMsgStore) Save(msgs []*Msg) (err error) {
+	if err = s.MsgStore.Save(msgs); err != nil {
+		return
+	}
+	s.index.Add(msgs...)
+	return
+}
+
+type searchDoc struct {
+	source    string
+	id        string
+	createdAt time.Time
+}
+
+// SearchOptions filters the results, zero values mean no limit
+type SearchOptions struct {
+	Source string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+func (o SearchOptions) allow(doc searchDoc) bool {
+	if o.Source != "" && o.Source != doc.source {
+		return false
+	}
+	if !o.From.IsZero() && doc.createdAt.Before(o.From) {
+		return false
+	}
+	if !o.To.IsZero() && !doc.createdAt.Before(o.To) {
+		return false
+	}
+	return true
+}
+
+// SearchIndex is an in-memory inverted index of the stored msgs, only the terms are kept in memory
+type SearchIndex struct {
+	dictionary *utils.Dictionary
+	store      MsgStore
+
+	lock     *sync.RWMutex
+	docs     []searchDoc
+	keys     map[string]uint32
+	postings map[string][]uint32
+	// Sorted terms for the prefix queries, rebuilt when dirty
+	terms      []string
+	termsDirty bool
+}
+
+func NewSearchIndex(store MsgStore) *SearchIndex {
+	return &SearchIndex{
+		dictionary: utils.TheDictionary,
+		store:      store,
+		lock:       &sync.RWMutex{},
+		keys:       make(map[string]uint32),
+		postings:   make(map[string][]uint32),
+	}
+}
+
+func (i *SearchIndex) Dictionary(dictionary *utils.Dictionary) *SearchIndex {
+	i.dictionary = dictionary
+	return i
+}
+
+func (i *SearchIndex) Len() int {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return len(i.docs)
+}
+
+// Build indexes all the msgs of the store
+func (i *SearchIndex) Build() error {
+	var (
+		batch []*Msg
+	)
+	err := i.store.Scan("", time.Time{}, time.Time{}, func(msg *Msg) bool {
+		batch = append(batch, msg)
+		if len(batch) == 1000 {
+			i.Add(batch...)
+			batch = batch[:0]
+		}
+		return true
+	})
+	i.Add(batch...)
+	glog.V(4).Infof("SEARCH INDEX BUILT: %d MSGS", i.Len())
+	return err
+}
+
+// Add skips the msgs already indexed
+func (i *SearchIndex) Add(msgs ...*Msg) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, msg := range msgs {
+		key := msg.Source + "/" + msg.ID
+		if _, hit := i.keys[key]; hit {
+			continue
+		}
+
+		docID := uint32(len(i.docs))
+		i.docs = append(i.docs, searchDoc{source: msg.Source, id: msg.ID, createdAt: msg.CreatedAt})
+		i.keys[key] = docID
+
+		for _, term := range i.dictionary.Tokenize(msg.Text) {
+			postings := i.postings[term]
+			// A term may appear many times in the doc
+			if len(postings) > 0 && postings[len(postings)-1] == docID {
+				continue
+			}
+			if len(postings) == 0 {
+				i.termsDirty = true
+			}
+			i.postings[term] = append(postings, docID)
+		}
+	}
+}
+
+// Search returns the matched msgs, the newest first.
+// The query supports words, "phrases", prefix*, AND, OR, NOT / -word and parentheses.
+func (i *SearchIndex) Search(query string, options SearchOptions) (msgs []*Msg, err error) {
+	node, err := parseSearchQuery(query)
+	if err != nil {
+		return
+	}
+
+	i.lock.Lock()
+	if i.termsDirty {
+		i.terms = i.terms[:0]
+		for term := range i.postings {
+			i.terms = append(i.terms, term)
+		}
+		sort.Strings(i.terms)
+		i.termsDirty = false
+	}
+	i.lock.Unlock()
+
+	i.lock.RLock()
+	var (
+		e = &searchEvaluation{index: i, options: options, texts: make(map[uint32]string)}
+	)
+	docIDs := e.eval(node)
+	docs := make([]searchDoc, 0, len(docIDs))
+	for _, docID := range docIDs {
+		docs = append(docs, i.docs[docID])
+	}
+	i.lock.RUnlock()
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	sort.SliceStable(docs, func(a, b int) bool {
+		return docs[a].createdAt.After(docs[b].createdAt)
+	})
+	limit := options.Limit
+	if limit <= 0 {
+		limit = SearchDefaultLimit
+	}
+	if len(docs) > limit {
+		docs = docs[:limit]
+	}
+
+	for _, doc := range docs {
+		msg, gErr := i.store.Get(doc.source, doc.id)
+		if gErr != nil {
+			return nil, gErr
+		}
+		msgs = append(msgs, msg)
+	}
+	return
+}
+
+// searchEvaluation must be used with the read lock of the index held
+type searchEvaluation struct {
+	index   *SearchIndex
+	options SearchOptions
+	texts   map[uint32]string
+	err     error
+}
+
+func (e *searchEvaluation) all() (docIDs []uint32) {
+	for docID, doc := range e.index.docs {
+		if e.options.allow(doc) {
+			docIDs = append(docIDs, uint32(docID))
+		}
+	}
+	return
+}
+
+func (e *searchEvaluation) text(docID uint32) string {
+	if text, hit := e.texts[docID]; hit {
+		return text
+	}
+	var (
+		doc     = e.index.docs[docID]
+		text    string
+		msg, er = e.index.store.Get(doc.source, doc.id)
+	)
+	if er != nil {
+		e.err = er
+	} else {
+		text = utils.NormalizeText(msg.Text)
+	}
+	e.texts[docID] = text
+	return text
+}
+
+func (e *searchEvaluation) eval(node *searchNode) []uint32 {
+	switch node.kind {
+	case searchAnd:
+		result := e.eval(node.children[0])
+		for _, child := range node.children[1:] {
+			if child.kind == searchNot {
+				result = differenceDocIDs(result, e.eval(child.children[0]))
+			} else {
+				result = intersectDocIDs(result, e.eval(child))
+			}
+		}
+		return result
+	case searchOr:
+		var result []uint32
+		for _, child := range node.children {
+			result = unionDocIDs(result, e.eval(child))
+		}
+		return result
+	case searchNot:
+		return differenceDocIDs(e.all(), e.eval(node.children[0]))
+	case searchPrefix:
+		return e.prefix(node.text)
+	}
+	return e.match(node)
+}
+
+func (e *searchEvaluation) prefix(prefix string) (result []uint32) {
+	var (
+		terms = e.index.terms
+		start = sort.SearchStrings(terms, prefix)
+	)
+	for j := start; j < len(terms) && strings.HasPrefix(terms[j], prefix); j++ {
+		result = unionDocIDs(result, e.index.postings[terms[j]])
+	}
+	return e.filter(result, nil)
+}
+
+// match finds the docs containing the word or the phrase
+func (e *searchEvaluation) match(node *searchNode) []uint32 {
+	var (
+		terms  = e.index.dictionary.QueryTerms(node.text)
+		result []uint32
+	)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	result = e.index.postings[terms[0]]
+	for _, term := range terms[1:] {
+		result = intersectDocIDs(result, e.index.postings[term])
+	}
+
+	if len(terms) == 1 && node.kind == searchWord {
+		return e.filter(result, nil)
+	}
+	// The terms may be in different places, check the text
+	phrase := utils.NormalizeText(node.text)
+	return e.filter(result, func(docID uint32) bool {
+		return strings.Contains(e.text(docID), phrase)
+	})
+}
+
+func (e *searchEvaluation) filter(docIDs []uint32, check func(docID uint32) bool) (result []uint32) {
+	for _, docID := range docIDs {
+		if !e.options.allow(e.index.docs[docID]) {
+			continue
+		}
+		if check != nil && !check(docID) {
+			continue
+		}
+		result = append(result, docID)
+	}
+	return
+}
+
+func intersectDocIDs(a, b []uint32) (result []uint32) {
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return
+}
+
+func unionDocIDs(a, b []uint32) (result []uint32) {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+func differenceDocIDs(a, b []uint32) (result []uint32) {
+	j := 0
+	for _, docID := range a {
+		for j < len(b) && b[j] < docID {
+			j++
+		}
+		if j < len(b) && b[j] == docID {
+			continue
+		}
+		result = append(result, docID)
+	}
+	return
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/app/utils"
+)
+
+func newTestSearchIndex(t *testing.T) *SearchIndex {
+	var (
+		now   = time.Date(2020, 12, 2, 8, 0, 0, 0, time.UTC)
+		store = NewFileMsgStore(t.TempDir())
+		index = NewSearchIndex(store).Dictionary(utils.NewDictionary("普拉格", "目标价"))
+	)
+
+	err := NewIndexedMsgStore(store, index).Save([]*Msg{
+		{Source: "futu", ID: "1", CreatedAt: now, Text: "大摩上调普拉格(PLUG.US)目标价至45美元"},
+		{Source: "futu", ID: "2", CreatedAt: now.Add(-24 * time.Hour), Text: "Plug Power shares jump"},
+		{Source: "sina", ID: "3", CreatedAt: now.Add(-48 * time.Hour), Text: "普拉格与格拉普"},
+		{Source: "sina", ID: "4", CreatedAt: now.Add(-72 * time.Hour), Text: "普拉 拉格朗日 plugin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func searchIDs(t *testing.T, index *SearchIndex, query string, options SearchOptions) string {
+	msgs, err := index.Search(query, options)
+	if err != nil {
+		t.Fatalf("search %s failed: %v", query, err)
+	}
+	var ids []string
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSearchIndex(t *testing.T) {
+	var (
+		index = newTestSearchIndex(t)
+		cases = []struct {
+			query    string
+			expected string
+		}{
+			{"PLUG OR 普拉格", "1,2,3"},
+			{"plug", "1,2"},
+			{"plug*", "1,2,4"},
+			// The bigrams 普拉 and 拉格 are in doc 4 too, but not the word
+			{"普拉格", "1,3"},
+			{"拉格", "1,3,4"},
+			{"\"普拉 拉格\"", "4"},
+			{"\"plug power\"", "2"},
+			{"\"power plug\"", ""},
+			{"普拉格 -目标价", "3"},
+			{"NOT plug*", "3"},
+			{"(plug OR 格拉普) AND 普拉格", "1,3"},
+		}
+	)
+
+	for _, c := range cases {
+		if ids := searchIDs(t, index, c.query, SearchOptions{}); ids != c.expected {
+			t.Errorf("query %s, expected [%s], got [%s]", c.query, c.expected, ids)
+		}
+	}
+
+	options, _ := NewSearchOptions("", "2020-11-30", "2020-12-02", 0)
+	if ids := searchIDs(t, index, "plug* OR 普拉格", options); ids != "2,3" {
+		t.Errorf("unexpected time filtered results: %s", ids)
+	}
+	if ids := searchIDs(t, index, "普拉格", SearchOptions{Source: "sina"}); ids != "3" {
+		t.Errorf("unexpected source filtered results: %s", ids)
+	}
+
+	for _, query := range []string{"", "(plug", "\"plug", "plug )"} {
+		if _, err := index.Search(query, SearchOptions{}); err == nil {
+			t.Errorf("expected error for query %q", query)
+		}
+	}
+}
+
+func TestAPISearch(t *testing.T) {
+	defer func(index *SearchIndex) { TheSearchIndex = index }(TheSearchIndex)
+	TheSearchIndex = newTestSearchIndex(t)
+
+	var (
+		server   = NewAPIServer("")
+		recorder = httptest.NewRecorder()
+	)
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/search?q=plug&source=futu&limit=1", nil))
+
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"total":1`) {
+		t.Errorf("unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type searchNodeKind int
+
+const (
+	searchWord searchNodeKind = iota
+	searchPhrase
+	searchPrefix
+	searchAnd
+	searchOr
+	searchNot
+)
+
+type searchNode struct {
+	kind     searchNodeKind
+	text     string
+	children []*searchNode
+}
+
+// searchQueryParser parses
+//
+//	or      := and ("OR" and)*
+//	and     := unary (["AND"] unary)*
+//	unary   := ("NOT" | "-") unary | primary
+//	primary := "(" or ")" | "phrase" | word | prefix*
+type searchQueryParser struct {
+	tokens   []string
+	position int
+}
+
+func parseSearchQuery(query string) (node *searchNode, err error) {
+	tokens, err := lexSearchQuery(query)
+	if err != nil {
+		return
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	p := &searchQueryParser{tokens: tokens}
+	if node, err = p.or(); err != nil {
+		return
+	}
+	if p.position < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in query", p.tokens[p.position])
+	}
+	return
+}
+
+func lexSearchQuery(query string) (tokens []string, err error) {
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				j++
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("unclosed quote in query")
+			}
+			tokens = append(tokens, string(runes[i:j+1]))
+			i = j + 1
+		case r == '-':
+			tokens = append(tokens, "-")
+			i++
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && runes[j] != '(' && runes[j] != ')' && runes[j] != '"' {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return
+}
+
+func (p *searchQueryParser) peek() string {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+	return ""
+}
+
+func (p *searchQueryParser) or() (*searchNode, error) {
+	node, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	children := []*searchNode{node}
+	for p.peek() == "OR" {
+		p.position++
+		if node, err = p.and(); err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &searchNode{kind: searchOr, children: children}, nil
+}
+
+func (p *searchQueryParser) and() (*searchNode, error) {
+	node, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	children := []*searchNode{node}
+	for {
+		next := p.peek()
+		if next == "" || next == ")" || next == "OR" {
+			break
+		}
+		if next == "AND" {
+			p.position++
+		}
+		if node, err = p.unary(); err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	// The first one is the base of the NOTs
+	for idx, child := range children {
+		if child.kind != searchNot {
+			children[0], children[idx] = children[idx], children[0]
+			break
+		}
+	}
+	return &searchNode{kind: searchAnd, children: children}, nil
+}
+
+func (p *searchQueryParser) unary() (*searchNode, error) {
+	if next := p.peek(); next == "NOT" || next == "-" {
+		p.position++
+		node, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &searchNode{kind: searchNot, children: []*searchNode{node}}, nil
+	}
+	return p.primary()
+}
+
+func (p *searchQueryParser) primary() (*searchNode, error) {
+	token := p.peek()
+	p.position++
+
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of query")
+	case token == "(":
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) in query")
+		}
+		p.position++
+		return node, nil
+	case token == ")":
+		return nil, fmt.Errorf("unexpected ) in query")
+	case strings.HasPrefix(token, "\""):
+		return &searchNode{kind: searchPhrase, text: strings.Trim(token, "\"")}, nil
+	case len(token) > 1 && strings.HasSuffix(token, "*"):
+		return &searchNode{kind: searchPrefix, text: strings.ToLower(strings.TrimSuffix(token, "*"))}, nil
+	}
+	return &searchNode{kind: searchWord, text: token}, nil
+}
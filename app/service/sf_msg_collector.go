@@ -6,9 +6,11 @@ import (
 	"github.com/buger/jsonparser"
 	"github.com/skeyic/monitoring/app/utils"
 	"net/http"
+	"time"
 )
 
 const (
+	SinaFinanceSourceName           = "sina"
 	SinaFinanceBaseURL              = "http://zhibo.sina.com.cn/api/zhibo/feed?page=%d&page_size=%d&zhibo_id=152"
 	SinaFinanceDefaultPageSize      = 100
 	TheSinaFinanceCollectorFileName = "TheSinaFinanceCollector.data"
@@ -21,9 +23,26 @@ var (
 )
 
 type SinaFinanceMsg struct {
-	CommentID  string `json:"commentid"`
+	CommentID string `json:"commentid"`
+	// The original string from Sina
 	CreateTime string `json:"create_time"`
-	RichText   string `json:"rich_text"`
+	// UTC
+	CreatedAt time.Time `json:"created_at"`
+	RichText  string    `json:"rich_text"`
+}
+
+func (s *SinaFinanceMsg) ID() string {
+	return s.CommentID
+}
+
+func (s *SinaFinanceMsg) ToMsg() *Msg {
+	return (&Msg{
+		Source:     SinaFinanceSourceName,
+		ID:         s.CommentID,
+		CreateTime: s.CreateTime,
+		CreatedAt:  s.CreatedAt,
+		Text:       s.RichText,
+	}).Extract()
 }
 
 type SinaFinanceMsgs []*SinaFinanceMsg
@@ -32,8 +51,9 @@ func (s SinaFinanceMsgs) Len() int {
 	return len(s)
 }
 
+// We need the reversed order, the IDs are compared by their numeric value
 func (s SinaFinanceMsgs) Less(i, j int) bool {
-	return s[i].CommentID < s[j].CommentID
+	return utils.CompareNumericStrings(s[i].CommentID, s[j].CommentID) > 0
 }
 
 func (s SinaFinanceMsgs) Swap(i, j int) {
@@ -45,6 +65,23 @@ type SinaFinanceCollector struct {
 	fileName string
 }
 
+func (c *SinaFinanceCollector) Name() string {
+	return SinaFinanceSourceName
+}
+
+func (c *SinaFinanceCollector) DefaultPageSize() int {
+	return SinaFinanceDefaultPageSize
+}
+
+// Fetch starts from page 0 as the other collectors, Sina starts from page 1
+func (c *SinaFinanceCollector) Fetch(page, pageSize int) (msgs []*Msg, err error) {
+	sinaMsgs, err := c.GetMsgs(page+1, pageSize)
+	for _, msg := range sinaMsgs {
+		msgs = append(msgs, msg.ToMsg())
+	}
+	return
+}
+
 func (c *SinaFinanceCollector) SaveToFile() (err error) {
 	data, _ := json.Marshal(c)
 	return utils.SaveToFile(c.fileName, data)
@@ -70,7 +107,7 @@ func (c *SinaFinanceCollector) GetMsgs(page, pageSize int) (msgs []*SinaFinanceM
 	rCode, rBody, rErr := utils.SendRequest(http.MethodGet, url, nil)
 	if rErr != nil {
 		fmt.Printf("HTTP ERROR: %v, CODE: %d, BODY: %s\n", rErr, rCode, rBody)
-		return
+		return nil, rErr
 	}
 
 	msgSource, _, _, err := jsonparser.Get([]byte(rBody), "result", "data", "feed", "list")
@@ -85,60 +122,22 @@ func (c *SinaFinanceCollector) GetMsgs(page, pageSize int) (msgs []*SinaFinanceM
 		return
 	}
 
-	//for idx, msg := range msgs {
-	//	fmt.Printf("IDX: %d, MSG: %+v\n", idx, msg)
-	//}
+	parser := utils.NewSourceTimeParser(utils.ShanghaiLocation, time.Now())
+	for _, msg := range msgs {
+		t, pErr := parser.Parse(msg.CreateTime)
+		if pErr != nil {
+			fmt.Printf("Parse time ERR: %v\n", pErr)
+			continue
+		}
+		msg.CreatedAt = t.UTC()
+	}
 
 	return msgs, err
 }
 
 func (c *SinaFinanceCollector) MergeMsgs(sourceMsgs, newMsgs []*SinaFinanceMsg) (lastMsgs []*SinaFinanceMsg) {
 	// We know the msg list are descend
-
-	var (
-		newMsgsAnchor, sourceMsgsAnchor = 0, 0
-		newMsgsLength, sourceMsgsLength = len(newMsgs), len(sourceMsgs)
-	)
-
-	if sourceMsgsLength == 0 {
-		return newMsgs
-	}
-	if newMsgsLength == 0 {
-		return sourceMsgs
-	}
-
-	if newMsgs[newMsgsLength-1].CommentID > sourceMsgs[0].CommentID {
-		return append(sourceMsgs, newMsgs...)
-	}
-
-	if newMsgs[newMsgsLength-1].CommentID > sourceMsgs[0].CommentID {
-		return append(sourceMsgs, newMsgs...)
-	}
-
-	for {
-		if newMsgs[newMsgsAnchor].CommentID > sourceMsgs[sourceMsgsAnchor].CommentID {
-			lastMsgs = append(lastMsgs, newMsgs[newMsgsAnchor])
-			newMsgsAnchor++
-		} else if newMsgs[newMsgsAnchor].CommentID < sourceMsgs[sourceMsgsAnchor].CommentID {
-			lastMsgs = append(lastMsgs, sourceMsgs[sourceMsgsAnchor])
-			sourceMsgsAnchor++
-		} else {
-			lastMsgs = append(lastMsgs, sourceMsgs[sourceMsgsAnchor])
-			sourceMsgsAnchor++
-			newMsgsAnchor++
-		}
-
-		if newMsgsAnchor == newMsgsLength {
-			lastMsgs = append(lastMsgs, sourceMsgs[sourceMsgsAnchor:]...)
-			break
-		}
-		if sourceMsgsAnchor == sourceMsgsLength {
-			lastMsgs = append(lastMsgs, newMsgs[newMsgsAnchor:]...)
-			break
-		}
-	}
-
-	return
+	return utils.MergeDescendFunc(sourceMsgs, newMsgs, (*SinaFinanceMsg).ID, utils.CompareNumericStrings)
 }
 
 func (c *SinaFinanceCollector) Load(maxLength int64) (err error) {
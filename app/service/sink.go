@@ -0,0 +1,398 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	SinkOverflowBlock      = "block"
+	SinkOverflowDropOldest = "drop_oldest"
+	SinkOverflowSpill      = "spill"
+
+	SinkSpillDirName = "spill"
+)
+
+var (
+	TheSinks = newSinks(config.Config.Sinks)
+
+	sinkMetrics = expvar.NewMap("sinks")
+)
+
+func newSinks(sinks []config.Sink) *Sinks {
+	s := &Sinks{}
+	for _, sink := range sinks {
+		if sink.SpillDir == "" {
+			sink.SpillDir = filepath.Join(config.Config.Store.Dir, SinkSpillDirName)
+		}
+		writer, err := NewMsgWriter(sink)
+		if err != nil {
+			glog.Errorf("failed to create the sink %s, ERR: %v", sink.Name, err)
+			continue
+		}
+		s.Add(NewMsgSink(sink.Name, writer).Buffer(sink.BufferSize, sink.Overflow).
+			Spill(sink.SpillDir).Retry(time.Duration(sink.RetrySeconds) * time.Second))
+	}
+	return s
+}
+
+// Sinks fans the collected msgs out to every sink
+type Sinks struct {
+	sinks []*MsgSink
+}
+
+func (s *Sinks) Add(sink *MsgSink) *Sinks {
+	s.sinks = append(s.sinks, sink)
+	return s
+}
+
+func (s *Sinks) List() []*MsgSink {
+	return s.sinks
+}
+
+// Send puts the msgs into the buffer of each sink, blocks if a sink of block is full
+func (s *Sinks) Send(msgs []*Msg) {
+	for _, sink := range s.sinks {
+		for _, msg := range msgs {
+			sink.Send(msg)
+		}
+	}
+}
+
+func (s *Sinks) Start() {
+	for _, sink := range s.sinks {
+		go sink.Start()
+	}
+}
+
+func (s *Sinks) Close() {
+	for _, sink := range s.sinks {
+		sink.Close()
+	}
+}
+
+// MsgSink writes the msgs by a writer in order, keeps them in a buffer while the writer is slow or failed.
+// When the buffer is full, the sender is blocked, the oldest msg is dropped, or the msgs are spilled into
+// a file until the buffer is drained. The spilled msgs are read back after restarting, may be written twice.
+type MsgSink struct {
+	name       string
+	writer     MsgWriter
+	bufferSize int
+	overflow   string
+	spillPath  string
+	retry      time.Duration
+
+	lock    sync.Mutex
+	changed *sync.Cond
+	buffer  []*Msg
+	// Being written
+	current *Msg
+	// If there are msgs spilled, and the offset read back
+	spilled   bool
+	spillRead int64
+	spillFile *os.File
+	closed    bool
+	stopped   chan struct{}
+	startOnce sync.Once
+	metrics   *expvar.Map
+}
+
+func NewMsgSink(name string, writer MsgWriter) *MsgSink {
+	s := &MsgSink{
+		name:       name,
+		writer:     writer,
+		bufferSize: 1000,
+		overflow:   SinkOverflowDropOldest,
+		retry:      5 * time.Second,
+		stopped:    make(chan struct{}),
+		metrics:    new(expvar.Map).Init(),
+	}
+	s.changed = sync.NewCond(&s.lock)
+	sinkMetrics.Set(name, s.metrics)
+	return s
+}
+
+func (s *MsgSink) Buffer(size int, overflow string) *MsgSink {
+	s.bufferSize, s.overflow = size, overflow
+	return s
+}
+
+// Spill sets the directory of the spill file of the sink, whose msgs left are read back
+func (s *MsgSink) Spill(dir string) *MsgSink {
+	s.spillPath = filepath.Join(dir, s.name+MsgStoreFileSuffix)
+	if info, err := os.Stat(s.spillPath); err == nil && info.Size() > 0 {
+		s.spilled = true
+	}
+	return s
+}
+
+func (s *MsgSink) Retry(interval time.Duration) *MsgSink {
+	s.retry = interval
+	return s
+}
+
+func (s *MsgSink) Name() string {
+	return s.name
+}
+
+// Pending is the number of the msgs in the buffer but the one being written, and if there are spilled ones
+func (s *MsgSink) Pending() (buffered int, spilled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.buffer), s.spilled
+}
+
+// Send puts the msg into the buffer, by the overflow policy if full
+func (s *MsgSink) Send(msg *Msg) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for !s.closed && len(s.buffer) >= s.bufferSize {
+		switch s.overflow {
+		case SinkOverflowBlock:
+			s.changed.Wait()
+			continue
+		case SinkOverflowSpill:
+			if err := s.spill(msg); err != nil {
+				glog.Errorf("sink %s: failed to spill msg %s/%s, ERR: %v", s.name, msg.Source, msg.ID, err)
+				s.metrics.Add("dropped", 1)
+			}
+			return
+		default:
+			glog.V(4).Infof("SINK %s FULL, DROP THE OLDEST MSG %s/%s", s.name, s.buffer[0].Source, s.buffer[0].ID)
+			s.metrics.Add("dropped", 1)
+			s.buffer[0] = nil
+			s.buffer = s.buffer[1:]
+		}
+	}
+	if s.closed {
+		s.metrics.Add("dropped", 1)
+		return
+	}
+	if s.spilled {
+		// After the spilled ones
+		if err := s.spill(msg); err == nil {
+			return
+		}
+	}
+	s.buffer = append(s.buffer, msg)
+	s.changed.Broadcast()
+}
+
+// spill appends the msg to the spill file, with the lock held
+func (s *MsgSink) spill(msg *Msg) (err error) {
+	if s.spillPath == "" {
+		return fmt.Errorf("no spill dir")
+	}
+	if s.spillFile == nil {
+		if err = os.MkdirAll(filepath.Dir(s.spillPath), 0755); err != nil {
+			return
+		}
+		if s.spillFile, err = os.OpenFile(s.spillPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			return
+		}
+	}
+	data, _ := json.Marshal(msg)
+	if _, err = s.spillFile.Write(append(data, '\n')); err != nil {
+		return
+	}
+	s.spilled = true
+	s.metrics.Add("spilled", 1)
+	s.changed.Broadcast()
+	return
+}
+
+// unspill reads the spilled msgs back into the empty buffer, removes the file when all are read
+func (s *MsgSink) unspill() {
+	f, err := os.Open(s.spillPath)
+	if err != nil {
+		glog.Errorf("sink %s: failed to read the spill file, ERR: %v", s.name, err)
+		s.spilled = false
+		return
+	}
+	defer f.Close()
+	if _, err = f.Seek(s.spillRead, io.SeekStart); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(f)
+	for len(s.buffer) < s.bufferSize {
+		line, rErr := reader.ReadBytes('\n')
+		if rErr != nil {
+			// The last line may be incomplete if the process was killed
+			break
+		}
+		s.spillRead += int64(len(line))
+		msg := &Msg{}
+		if err = json.Unmarshal(line, msg); err != nil {
+			glog.Warningf("sink %s: skip the spilled line, ERR: %v", s.name, err)
+			continue
+		}
+		s.buffer = append(s.buffer, msg)
+	}
+	if len(s.buffer) < s.bufferSize {
+		// All are read back
+		if s.spillFile != nil {
+			s.spillFile.Close()
+			s.spillFile = nil
+		}
+		os.Remove(s.spillPath)
+		s.spilled, s.spillRead = false, 0
+	}
+}
+
+// next waits for the msg at the front of the buffer and takes it as the current one, nil if closed
+func (s *MsgSink) next() *Msg {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.buffer) == 0 || (s.closed && s.overflow == SinkOverflowSpill) {
+		if s.closed && s.overflow == SinkOverflowSpill {
+			// The buffer is spilled when closed
+			return nil
+		}
+		if s.spilled {
+			s.unspill()
+			continue
+		}
+		if s.closed {
+			return nil
+		}
+		s.changed.Wait()
+	}
+	s.current = s.buffer[0]
+	s.buffer[0] = nil
+	s.buffer = s.buffer[1:]
+	s.changed.Broadcast()
+	return s.current
+}
+
+func (s *MsgSink) done() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current = nil
+}
+
+// Start writes the msgs in order until closed, retries the failed one every retry interval
+func (s *MsgSink) Start() {
+	s.startOnce.Do(s.run)
+}
+
+func (s *MsgSink) run() {
+	defer close(s.stopped)
+	for {
+		msg := s.next()
+		if msg == nil {
+			return
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			glog.Errorf("sink %s: skip msg %s/%s, ERR: %v", s.name, msg.Source, msg.ID, err)
+			s.done()
+			continue
+		}
+		for {
+			if err = s.writer.Write(msg, data); err == nil {
+				break
+			}
+			s.metrics.Add("failed", 1)
+			glog.Warningf("sink %s: failed to write msg %s/%s, retry in %s, ERR: %v", s.name, msg.Source, msg.ID, s.retry, err)
+			if !s.wait(s.retry) {
+				return
+			}
+		}
+		s.metrics.Add("written", 1)
+		s.done()
+	}
+}
+
+// wait sleeps for the interval, false if closed
+func (s *MsgSink) wait(interval time.Duration) bool {
+	deadline := time.Now().Add(interval)
+	for time.Now().Before(deadline) {
+		s.lock.Lock()
+		closed := s.closed
+		s.lock.Unlock()
+		if closed {
+			return false
+		}
+		time.Sleep(min(100*time.Millisecond, time.Until(deadline)))
+	}
+	return true
+}
+
+// Close stops after the msgs in the buffer are written or a write fails, the msgs left are lost.
+// For spill, it stops after the msg being written, the msgs left are spilled to be read back after restarting.
+func (s *MsgSink) Close() {
+	s.lock.Lock()
+	s.closed = true
+	s.changed.Broadcast()
+	s.lock.Unlock()
+
+	s.Start()
+	<-s.stopped
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current != nil {
+		// Failed to write
+		s.buffer = append([]*Msg{s.current}, s.buffer...)
+		s.current = nil
+	}
+	if s.overflow == SinkOverflowSpill && len(s.buffer) > 0 {
+		// Before the ones spilled
+		left := s.buffer
+		s.buffer = nil
+		if err := s.respill(left); err != nil {
+			glog.Errorf("sink %s: %d msgs lost, ERR: %v", s.name, len(left), err)
+		}
+	} else if len(s.buffer) > 0 {
+		glog.Errorf("sink %s: %d msgs lost", s.name, len(s.buffer))
+		s.metrics.Add("dropped", int64(len(s.buffer)))
+	}
+	if s.spillFile != nil {
+		s.spillFile.Close()
+		s.spillFile = nil
+	}
+	s.writer.Close()
+}
+
+// respill rewrites the spill file with the msgs in front of the ones not read back
+func (s *MsgSink) respill(msgs []*Msg) (err error) {
+	if s.spillPath == "" {
+		return fmt.Errorf("no spill dir")
+	}
+	var rest []byte
+	if s.spilled {
+		if rest, err = os.ReadFile(s.spillPath); err != nil {
+			return
+		}
+		rest = rest[min(s.spillRead, int64(len(rest))):]
+	}
+	var data []byte
+	for _, msg := range msgs {
+		line, _ := json.Marshal(msg)
+		data = append(append(data, line...), '\n')
+	}
+	if err = os.MkdirAll(filepath.Dir(s.spillPath), 0755); err != nil {
+		return
+	}
+	if s.spillFile != nil {
+		s.spillFile.Close()
+		s.spillFile = nil
+	}
+	if err = os.WriteFile(s.spillPath, append(data, rest...), 0644); err != nil {
+		return
+	}
+	s.spilled, s.spillRead = true, 0
+	return
+}
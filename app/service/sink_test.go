@@ -0,0 +1,339 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+func testSinkMsgs(start, count int) (msgs []*Msg) {
+	for id := start; id < start+count; id++ {
+		msgs = append(msgs, &Msg{Source: "futu", ID: fmt.Sprint(id), Text: fmt.Sprintf("快讯 %d", id)})
+	}
+	return
+}
+
+// waitFor polls the condition for seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+}
+
+// gateWriter records the IDs written, fails while down and waits while paused
+type gateWriter struct {
+	lock   sync.Mutex
+	ids    []string
+	down   bool
+	paused chan struct{}
+}
+
+func (w *gateWriter) Write(msg *Msg, data []byte) error {
+	if w.paused != nil {
+		<-w.paused
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.down {
+		return fmt.Errorf("down")
+	}
+	w.ids = append(w.ids, msg.ID)
+	return nil
+}
+
+func (w *gateWriter) Close() error {
+	return nil
+}
+
+func (w *gateWriter) written() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return strings.Join(w.ids, ",")
+}
+
+func TestMsgSinkOverflow(t *testing.T) {
+	// The writer takes the first msg and waits, the buffer keeps 2 more
+	for _, c := range []struct {
+		overflow, written string
+	}{
+		{SinkOverflowDropOldest, "0,3,4"},
+		{SinkOverflowSpill, "0,1,2,3,4"},
+		{SinkOverflowBlock, "0,1,2,3,4"},
+	} {
+		var (
+			writer = &gateWriter{paused: make(chan struct{})}
+			sink   = NewMsgSink("test-"+c.overflow, writer).Buffer(2, c.overflow).Spill(t.TempDir())
+			sent   = make(chan struct{})
+		)
+		go sink.Start()
+		sink.Send(testSinkMsgs(0, 1)[0])
+		waitFor(t, "taken", func() bool { n, _ := sink.Pending(); return n == 0 })
+		go func() {
+			for _, msg := range testSinkMsgs(1, 4) {
+				sink.Send(msg)
+			}
+			close(sent)
+		}()
+
+		if c.overflow == SinkOverflowBlock {
+			select {
+			case <-sent:
+				t.Fatalf("%s: not blocked", c.overflow)
+			case <-time.After(100 * time.Millisecond):
+			}
+		} else {
+			<-sent
+		}
+		if _, spilled := sink.Pending(); spilled != (c.overflow == SinkOverflowSpill) {
+			t.Errorf("%s: spilled %v", c.overflow, spilled)
+		}
+
+		close(writer.paused)
+		<-sent
+		waitFor(t, c.overflow, func() bool { return strings.Count(writer.written(), ",") == strings.Count(c.written, ",") })
+		if got := writer.written(); got != c.written {
+			t.Errorf("%s: written %s", c.overflow, got)
+		}
+		sink.Close()
+	}
+}
+
+func TestMsgSinkRetryAndSpillRestart(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		writer = &gateWriter{down: true}
+		sink   = NewMsgSink("restart", writer).Buffer(2, SinkOverflowSpill).Spill(dir).Retry(50 * time.Millisecond)
+	)
+	go sink.Start()
+	for _, msg := range testSinkMsgs(0, 5) {
+		sink.Send(msg)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// The buffer is spilled in front of the others
+	sink.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "restart.jsonl"))
+	if err != nil || bytes.Count(data, []byte("\n")) != 5 {
+		t.Fatalf("%s, %v", data, err)
+	}
+
+	writer.down = false
+	sink = NewMsgSink("restart", writer).Buffer(2, SinkOverflowSpill).Spill(dir).Retry(50 * time.Millisecond)
+	go sink.Start()
+	sink.Send(testSinkMsgs(5, 1)[0])
+	waitFor(t, "written", func() bool { return strings.HasSuffix(writer.written(), "5") })
+	if got := writer.written(); got != "0,1,2,3,4,5" {
+		t.Errorf("written %s", got)
+	}
+	sink.Close()
+	if _, err = os.Stat(filepath.Join(dir, "restart.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("spill file left, %v", err)
+	}
+}
+
+// mqttBroker stands in for an MQTT 3.1.1 broker, accepts the connections and the publishes
+type mqttBroker struct {
+	net.Listener
+
+	lock      sync.Mutex
+	published map[string][]string
+}
+
+func newMQTTBroker(t *testing.T) *mqttBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &mqttBroker{Listener: l, published: make(map[string][]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *mqttBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		var length, shift int
+		for {
+			digit, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			length |= int(digit&0x7f) << shift
+			if shift += 7; digit&0x80 == 0 {
+				break
+			}
+		}
+		packet := make([]byte, length)
+		if _, err = io.ReadFull(reader, packet); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH
+			topicLength := int(binary.BigEndian.Uint16(packet))
+			topic, rest := string(packet[2:2+topicLength]), packet[2+topicLength:]
+			if qos := header >> 1 & 3; qos > 0 {
+				conn.Write([]byte{0x40, 2, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			b.lock.Lock()
+			b.published[topic] = append(b.published[topic], string(rest))
+			b.lock.Unlock()
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func (b *mqttBroker) count(topic string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.published[topic])
+}
+
+func TestMsgWriters(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		msgs = testSinkMsgs(0, 2)
+	)
+
+	// http, down for the first msg
+	var (
+		httpLock sync.Mutex
+		posted   []string
+		httpDown = true
+		server   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httpLock.Lock()
+			defer httpLock.Unlock()
+			if httpDown || r.Header.Get("X-Token") != "secret" {
+				httpDown = false
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			posted = append(posted, string(body))
+		}))
+	)
+	defer server.Close()
+
+	// syslog over udp
+	syslogConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syslogConn.Close()
+	syslogs := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, _, err := syslogConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			syslogs <- string(buf[:n])
+		}
+	}()
+
+	// unix socket
+	socket := filepath.Join(dir, "sink.sock")
+	unixListener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixListener.Close()
+	unixLines := make(chan string, 10)
+	go func() {
+		conn, err := unixListener.Accept()
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			unixLines <- scanner.Text()
+		}
+	}()
+
+	broker := newMQTTBroker(t)
+	defer broker.Close()
+
+	var (
+		stdout bytes.Buffer
+		sinks  = &Sinks{}
+	)
+	for _, sink := range []config.Sink{
+		{Name: "file", Type: SinkTypeFile, Target: filepath.Join(dir, "out", "msgs.jsonl")},
+		{Name: "http", Type: SinkTypeHTTP, Target: server.URL, Headers: map[string]string{"X-Token": "secret"}},
+		{Name: "syslog", Type: SinkTypeSyslog, Target: "udp://" + syslogConn.LocalAddr().String(), Tag: "monitoring"},
+		{Name: "unix", Type: SinkTypeUnix, Target: socket},
+		{Name: "mqtt", Type: SinkTypeMQTT, Target: "tcp://user:password@" + broker.Addr().String(), Topic: "monitoring/{source}"},
+	} {
+		writer, err := NewMsgWriter(sink)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sinks.Add(NewMsgSink(sink.Name, writer).Retry(50 * time.Millisecond))
+	}
+	sinks.Add(NewMsgSink("stdout", &streamMsgWriter{w: &stdout}))
+	sinks.Start()
+	sinks.Send(msgs)
+
+	waitFor(t, "http", func() bool { httpLock.Lock(); defer httpLock.Unlock(); return len(posted) == 2 })
+	waitFor(t, "mqtt", func() bool { return broker.count("monitoring/futu") == 2 })
+	for idx := range msgs {
+		if line := <-syslogs; !strings.Contains(line, "monitoring") || !strings.Contains(line, fmt.Sprintf(`"id":"%d"`, idx)) {
+			t.Errorf("syslog %s", line)
+		}
+		if line := <-unixLines; !strings.Contains(line, fmt.Sprintf(`"id":"%d"`, idx)) {
+			t.Errorf("unix %s", line)
+		}
+	}
+	sinks.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "out", "msgs.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, output := range map[string]string{"file": string(data), "stdout": stdout.String()} {
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		var msg Msg
+		if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &msg) != nil || msg.ID != "1" {
+			t.Errorf("%s: %s", name, output)
+		}
+	}
+	if _, err = NewMsgWriter(config.Sink{Name: "bad", Type: "kafka"}); err == nil {
+		t.Error("expect the error of the unknown type")
+	}
+}
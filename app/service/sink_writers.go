@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	SinkTypeFile   = "file"
+	SinkTypeStdout = "stdout"
+	SinkTypeHTTP   = "http"
+	SinkTypeSyslog = "syslog"
+	SinkTypeUnix   = "unix"
+	SinkTypeMQTT   = "mqtt"
+
+	sinkDialTimeout = 10 * time.Second
+)
+
+// MsgWriter writes a msg encoded as JSON to an output, the same msg is written again if failed
+type MsgWriter interface {
+	Write(msg *Msg, data []byte) error
+	Close() error
+}
+
+// NewMsgWriter creates the writer of the sink type
+func NewMsgWriter(sink config.Sink) (MsgWriter, error) {
+	switch sink.Type {
+	case SinkTypeFile:
+		return &fileMsgWriter{path: sink.Target}, nil
+	case SinkTypeStdout:
+		return &streamMsgWriter{w: os.Stdout}, nil
+	case SinkTypeHTTP:
+		return &httpMsgWriter{url: sink.Target, headers: sink.Headers}, nil
+	case SinkTypeSyslog:
+		return newSyslogMsgWriter(sink.Target, sink.Tag)
+	case SinkTypeUnix:
+		return &unixMsgWriter{path: sink.Target}, nil
+	case SinkTypeMQTT:
+		return newMQTTMsgWriter(sink.Name, sink.Target, sink.Topic)
+	}
+	return nil, fmt.Errorf("unknown sink type %s", sink.Type)
+}
+
+// fileMsgWriter appends the JSON lines to a file
+type fileMsgWriter struct {
+	path string
+	file *os.File
+}
+
+func (w *fileMsgWriter) Write(msg *Msg, data []byte) (err error) {
+	if w.file == nil {
+		if err = os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+			return
+		}
+		if w.file, err = os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			return
+		}
+	}
+	if _, err = w.file.Write(append(data, '\n')); err != nil {
+		// Reopened by the next write, e.g. the file is rotated
+		w.Close()
+	}
+	return
+}
+
+func (w *fileMsgWriter) Close() (err error) {
+	if w.file != nil {
+		err, w.file = w.file.Close(), nil
+	}
+	return
+}
+
+// streamMsgWriter writes the JSON lines to stdout
+type streamMsgWriter struct {
+	w io.Writer
+}
+
+func (w *streamMsgWriter) Write(msg *Msg, data []byte) error {
+	_, err := w.w.Write(append(data, '\n'))
+	return err
+}
+
+func (w *streamMsgWriter) Close() error {
+	return nil
+}
+
+// httpMsgWriter posts each msg to the URL
+type httpMsgWriter struct {
+	url     string
+	headers map[string]string
+}
+
+func (w *httpMsgWriter) Write(msg *Msg, data []byte) error {
+	rCode, rBody, err := utils.SendRequestWithHeaders(http.MethodPost, w.url, bytes.NewBuffer(data), w.headers)
+	if err != nil {
+		return err
+	}
+	if rCode < http.StatusOK || rCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s responded %d: %s", w.url, rCode, rBody)
+	}
+	return nil
+}
+
+func (w *httpMsgWriter) Close() error {
+	return nil
+}
+
+// syslogMsgWriter sends each msg as a syslog message of info
+type syslogMsgWriter struct {
+	network, addr, tag string
+	writer             *syslog.Writer
+}
+
+func newSyslogMsgWriter(target, tag string) (*syslogMsgWriter, error) {
+	w := &syslogMsgWriter{tag: tag}
+	if target != "" {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		w.network, w.addr = u.Scheme, u.Host
+	}
+	return w, nil
+}
+
+func (w *syslogMsgWriter) Write(msg *Msg, data []byte) (err error) {
+	if w.writer == nil {
+		if w.writer, err = syslog.Dial(w.network, w.addr, syslog.LOG_INFO|syslog.LOG_USER, w.tag); err != nil {
+			return
+		}
+	}
+	return w.writer.Info(string(data))
+}
+
+func (w *syslogMsgWriter) Close() (err error) {
+	if w.writer != nil {
+		err, w.writer = w.writer.Close(), nil
+	}
+	return
+}
+
+// unixMsgWriter writes the JSON lines to a Unix stream socket, reconnects after a failure
+type unixMsgWriter struct {
+	path string
+	conn net.Conn
+}
+
+func (w *unixMsgWriter) Write(msg *Msg, data []byte) (err error) {
+	if w.conn == nil {
+		if w.conn, err = net.DialTimeout("unix", w.path, sinkDialTimeout); err != nil {
+			return
+		}
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(sinkDialTimeout))
+	if _, err = w.conn.Write(append(data, '\n')); err != nil {
+		w.Close()
+	}
+	return
+}
+
+func (w *unixMsgWriter) Close() (err error) {
+	if w.conn != nil {
+		err, w.conn = w.conn.Close(), nil
+	}
+	return
+}
+
+// mqttMsgWriter publishes each msg of QoS 1 to the topic of its source
+type mqttMsgWriter struct {
+	topic  string
+	client mqtt.Client
+}
+
+func newMQTTMsgWriter(name, broker, topic string) (*mqttMsgWriter, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	options := mqtt.NewClientOptions().SetClientID("monitoring-" + name).
+		SetConnectTimeout(sinkDialTimeout).SetAutoReconnect(true).SetCleanSession(true)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		options.SetUsername(u.User.Username()).SetPassword(password)
+		u.User = nil
+	}
+	options.AddBroker(u.String())
+	return &mqttMsgWriter{topic: topic, client: mqtt.NewClient(options)}, nil
+}
+
+func (w *mqttMsgWriter) Write(msg *Msg, data []byte) error {
+	if !w.client.IsConnected() {
+		token := w.client.Connect()
+		if !token.WaitTimeout(sinkDialTimeout) {
+			return fmt.Errorf("timeout to connect")
+		}
+		if err := token.Error(); err != nil {
+			return err
+		}
+	}
+	token := w.client.Publish(strings.ReplaceAll(w.topic, "{source}", msg.Source), 1, false, data)
+	if !token.WaitTimeout(sinkDialTimeout) {
+		return fmt.Errorf("timeout to publish")
+	}
+	return token.Error()
+}
+
+func (w *mqttMsgWriter) Close() error {
+	w.client.Disconnect(250)
+	return nil
+}
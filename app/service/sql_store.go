@@ -0,0 +1,562 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+	// The driver of sqlite, pure Go to build without cgo
+	_ "modernc.org/sqlite"
+)
+
+const (
+	StoreBackendFile = "file"
+	StoreBackendSQL  = "sql"
+
+	SQLStoreFileName = "monitoring.db"
+
+	MsgEntitySymbol = "symbol"
+	MsgEntityBroker = "broker"
+
+	sqlScanPageSize   = 1000
+	sqlAlertQueueSize = 1000
+)
+
+var (
+	// nil unless Store.Backend is sql, which fails the startup if not opened
+	TheSQLStore = newSQLStore(config.Config.Store.Backend, config.Config.Store.SQLDriver,
+		config.Config.Store.SQLDSN, config.Config.Store.Dir)
+)
+
+// newSQLStore opens the SQL store of the backend, exits if failed rather than keeping the msgs in the files
+func newSQLStore(backend, driver, dsn, dir string) *SQLStore {
+	if backend != StoreBackendSQL {
+		return nil
+	}
+	if dsn == "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			glog.Fatalf("failed to create the store dir, ERR: %v", err)
+		}
+		dsn = filepath.Join(dir, SQLStoreFileName)
+	}
+	s, err := OpenSQLStore(driver, dsn)
+	if err != nil {
+		glog.Fatalf("failed to open the SQL store of driver %s, ERR: %v", driver, err)
+	}
+	return s
+}
+
+// newBackendMsgStore is the SQL store if Store.Backend is sql, the files otherwise
+func newBackendMsgStore() MsgStore {
+	if TheSQLStore != nil {
+		return TheSQLStore
+	}
+	return theFileMsgStore
+}
+
+// sqlMigrations are applied in order and never changed once released, the version is the index + 1
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE msgs (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			source TEXT NOT NULL,
+			id TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			create_time_str TEXT NOT NULL DEFAULT '',
+			content TEXT NOT NULL,
+			rating TEXT,
+			symbols TEXT,
+			UNIQUE (source, id)
+		)`,
+		`CREATE INDEX msgs_created_at ON msgs (created_at)`,
+		// Scan in the order saved
+		`CREATE INDEX msgs_source ON msgs (source)`,
+		`CREATE INDEX msgs_source_created_at ON msgs (source, created_at)`,
+		`CREATE TABLE msg_entities (
+			source TEXT NOT NULL,
+			msg_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			value TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (source, msg_id, kind, value)
+		)`,
+		`CREATE INDEX msg_entities_value ON msg_entities (kind, value, created_at)`,
+		`CREATE TABLE rule_matches (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule TEXT NOT NULL,
+			source TEXT NOT NULL,
+			msg_id TEXT NOT NULL,
+			matched_at INTEGER NOT NULL,
+			UNIQUE (rule, source, msg_id)
+		)`,
+		`CREATE INDEX rule_matches_rule ON rule_matches (rule, matched_at)`,
+		`CREATE INDEX rule_matches_msg ON rule_matches (source, msg_id)`,
+		`CREATE TABLE alerts (
+			alert_key TEXT PRIMARY KEY,
+			id INTEGER NOT NULL,
+			rule TEXT NOT NULL,
+			priority TEXT NOT NULL,
+			user TEXT NOT NULL,
+			source TEXT NOT NULL DEFAULT '',
+			msg_id TEXT NOT NULL DEFAULT '',
+			cluster INTEGER NOT NULL DEFAULT 0,
+			sources TEXT,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			acked_at INTEGER,
+			escalated INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX alerts_created_at ON alerts (created_at)`,
+		`CREATE INDEX alerts_msg ON alerts (source, msg_id)`,
+		`CREATE TABLE alert_deliveries (
+			alert_key TEXT NOT NULL REFERENCES alerts (alert_key),
+			seq INTEGER NOT NULL,
+			time INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			notifier TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (alert_key, seq)
+		)`,
+		`CREATE INDEX alert_deliveries_time ON alert_deliveries (time)`,
+	},
+}
+
+// SQLStore keeps the msgs with their entities, the rule matches and the alerts with their deliveries
+// in an embedded SQLite database, by the database/sql driver linked into the binary, e.g. modernc.org/sqlite
+type SQLStore struct {
+	db     *sql.DB
+	alerts chan AlertRecord
+}
+
+// OpenSQLStore opens the database and applies the migrations not applied yet
+func OpenSQLStore(driver, dsn string) (s *SQLStore, err error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return
+	}
+	// SQLite allows a writer at a time
+	db.SetMaxOpenConns(1)
+	s = &SQLStore{db: db, alerts: make(chan AlertRecord, sqlAlertQueueSize)}
+	if err = s.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return
+}
+
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// tx runs fn in a transaction, which is rolled back if fn fails
+func (s *SQLStore) tx(fn func(tx *sql.Tx) error) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return
+	}
+	return tx.Commit()
+}
+
+// Version is the number of the migrations applied
+func (s *SQLStore) Version() (version int, err error) {
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return
+}
+
+// Migrate applies the migrations after the version each in a transaction
+func (s *SQLStore) Migrate() (err error) {
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return
+	}
+	version, err := s.Version()
+	if err != nil {
+		return
+	}
+	if version > len(sqlMigrations) {
+		return fmt.Errorf("the schema version %d is newer than %d", version, len(sqlMigrations))
+	}
+	for ; version < len(sqlMigrations); version++ {
+		err = s.tx(func(tx *sql.Tx) error {
+			for _, stmt := range sqlMigrations[version] {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version+1, time.Now().UnixNano())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %v", version+1, err)
+		}
+		glog.V(4).Infof("SQL STORE: MIGRATED TO %d", version+1)
+	}
+	return
+}
+
+// sqlJSON is the JSON of v, NULL if empty
+func sqlJSON(v interface{}, empty bool) interface{} {
+	if empty {
+		return nil
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// sqlTime is the unix nanos of t, NULL if nil
+func sqlTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+// sqlRange are the unix nanos of [from, to), zero means no limit
+func sqlRange(from, to time.Time) (int64, int64) {
+	var start, end int64 = math.MinInt64, math.MaxInt64
+	if !from.IsZero() {
+		start = from.UnixNano()
+	}
+	if !to.IsZero() {
+		end = to.UnixNano()
+	}
+	return start, end
+}
+
+// Save skips the msgs already stored, and indexes the entities of the new ones
+func (s *SQLStore) Save(msgs []*Msg) error {
+	return s.tx(func(tx *sql.Tx) error {
+		insertMsg, err := tx.Prepare(`INSERT OR IGNORE INTO msgs
+			(source, id, created_at, create_time_str, content, rating, symbols) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer insertMsg.Close()
+		insertEntity, err := tx.Prepare(`INSERT OR IGNORE INTO msg_entities
+			(source, msg_id, kind, value, created_at) VALUES (?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer insertEntity.Close()
+
+		for _, msg := range msgs {
+			createdAt := msg.CreatedAt.UnixNano()
+			result, err := insertMsg.Exec(msg.Source, msg.ID, createdAt, msg.CreateTime, msg.Text,
+				sqlJSON(msg.Rating, msg.Rating == nil), sqlJSON(msg.Symbols, len(msg.Symbols) == 0))
+			if err != nil {
+				return err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				continue
+			}
+			for _, entity := range msgEntities(msg) {
+				if _, err = insertEntity.Exec(msg.Source, msg.ID, entity[0], entity[1], createdAt); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// msgEntities are the kinds and the values extracted from the msg
+func msgEntities(msg *Msg) (entities [][2]string) {
+	for _, symbol := range msg.Symbols {
+		entities = append(entities, [2]string{MsgEntitySymbol, symbol})
+	}
+	if msg.Rating != nil && msg.Rating.Broker != "" {
+		entities = append(entities, [2]string{MsgEntityBroker, msg.Rating.Broker})
+	}
+	return
+}
+
+const sqlMsgColumns = `m.seq, m.source, m.id, m.created_at, m.create_time_str, m.content, m.rating, m.symbols`
+
+func scanSQLMsg(rows interface {
+	Scan(dest ...interface{}) error
+}) (seq int64, msg *Msg, err error) {
+	var (
+		createdAt       int64
+		rating, symbols sql.NullString
+	)
+	msg = &Msg{}
+	if err = rows.Scan(&seq, &msg.Source, &msg.ID, &createdAt, &msg.CreateTime, &msg.Text, &rating, &symbols); err != nil {
+		return
+	}
+	msg.CreatedAt = time.Unix(0, createdAt).UTC()
+	if rating.Valid {
+		msg.Rating = &RatingChange{}
+		if err = json.Unmarshal([]byte(rating.String), msg.Rating); err != nil {
+			return
+		}
+	}
+	if symbols.Valid {
+		err = json.Unmarshal([]byte(symbols.String), &msg.Symbols)
+	}
+	return
+}
+
+func (s *SQLStore) Get(source, id string) (msg *Msg, err error) {
+	row := s.db.QueryRow(`SELECT `+sqlMsgColumns+` FROM msgs m WHERE m.source = ? AND m.id = ?`, source, id)
+	if _, msg, err = scanSQLMsg(row); err == sql.ErrNoRows {
+		return nil, ErrMsgNotFound
+	}
+	return
+}
+
+// Scan reads the msgs by source in the order saved, a page at a time so that fn may use the store
+func (s *SQLStore) Scan(source string, from, to time.Time, fn func(msg *Msg) bool) error {
+	var (
+		start, end = sqlRange(from, to)
+		lastSource string
+		lastSeq    int64 = -1
+	)
+	for {
+		msgs, seqs, err := s.queryMsgs(`SELECT `+sqlMsgColumns+` FROM msgs m
+			WHERE (? = '' OR m.source = ?) AND m.created_at >= ? AND m.created_at < ?
+				AND (m.source > ? OR (m.source = ? AND m.seq > ?))
+			ORDER BY m.source, m.seq LIMIT ?`,
+			source, source, start, end, lastSource, lastSource, lastSeq, sqlScanPageSize)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if !fn(msg) {
+				return nil
+			}
+		}
+		if len(msgs) < sqlScanPageSize {
+			return nil
+		}
+		lastSource, lastSeq = msgs[len(msgs)-1].Source, seqs[len(seqs)-1]
+	}
+}
+
+func (s *SQLStore) queryMsgs(query string, args ...interface{}) (msgs []*Msg, seqs []int64, err error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		seq, msg, sErr := scanSQLMsg(rows)
+		if sErr != nil {
+			return nil, nil, sErr
+		}
+		msgs, seqs = append(msgs, msg), append(seqs, seq)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLStore) Sources() (sources []string, err error) {
+	rows, err := s.db.Query(`SELECT DISTINCT source FROM msgs ORDER BY source`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source string
+		if err = rows.Scan(&source); err != nil {
+			return
+		}
+		sources = append(sources, source)
+	}
+	err = rows.Err()
+	return
+}
+
+// EntityMsgs are the msgs of the entity created in [from, to), the newest first, e.g. the ones of a symbol
+func (s *SQLStore) EntityMsgs(kind, value string, from, to time.Time, limit int) (msgs []*Msg, err error) {
+	start, end := sqlRange(from, to)
+	msgs, _, err = s.queryMsgs(`SELECT `+sqlMsgColumns+` FROM msg_entities e
+		JOIN msgs m ON m.source = e.source AND m.id = e.msg_id
+		WHERE e.kind = ? AND e.value = ? AND e.created_at >= ? AND e.created_at < ?
+		ORDER BY e.created_at DESC LIMIT ?`, kind, value, start, end, limit)
+	return
+}
+
+// RuleMatch is a msg matched by a rule, whether it is alerted or not
+type RuleMatch struct {
+	Rule      string    `json:"rule"`
+	Source    string    `json:"source"`
+	MsgID     string    `json:"msg_id"`
+	MatchedAt time.Time `json:"matched_at"`
+}
+
+// SaveMatch keeps the first match of the msg by the rule
+func (s *SQLStore) SaveMatch(rule string, msg *Msg, at time.Time) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO rule_matches (rule, source, msg_id, matched_at) VALUES (?, ?, ?, ?)`,
+		rule, msg.Source, msg.ID, at.UnixNano())
+	return err
+}
+
+// AddMatch saves the match, logs if failed, to be called by the alert router
+func (s *SQLStore) AddMatch(filter MsgFilter, msg *Msg, at time.Time) {
+	if err := s.SaveMatch(filter.Name(), msg, at); err != nil {
+		glog.Errorf("failed to save the match of rule %s: %s/%s, ERR: %v", filter.Name(), msg.Source, msg.ID, err)
+	}
+}
+
+// RuleMatches are the matches of the rule in [from, to), the newest first, all the rules if rule is empty
+func (s *SQLStore) RuleMatches(rule string, from, to time.Time, limit int) (matches []RuleMatch, err error) {
+	start, end := sqlRange(from, to)
+	rows, err := s.db.Query(`SELECT rule, source, msg_id, matched_at FROM rule_matches
+		WHERE (? = '' OR rule = ?) AND matched_at >= ? AND matched_at < ?
+		ORDER BY matched_at DESC, seq DESC LIMIT ?`, rule, rule, start, end, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			match     RuleMatch
+			matchedAt int64
+		)
+		if err = rows.Scan(&match.Rule, &match.Source, &match.MsgID, &matchedAt); err != nil {
+			return
+		}
+		match.MatchedAt = time.Unix(0, matchedAt).UTC()
+		matches = append(matches, match)
+	}
+	err = rows.Err()
+	return
+}
+
+// AlertKey identifies the alert across restarts, as the IDs of the history start from 1 again
+func AlertKey(record AlertRecord) string {
+	return fmt.Sprintf("%d-%d", record.CreatedAt.UnixNano(), record.ID)
+}
+
+// SaveAlert replaces the alert, and adds the deliveries not saved yet
+func (s *SQLStore) SaveAlert(record AlertRecord) error {
+	key := AlertKey(record)
+	return s.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO alerts
+			(alert_key, id, rule, priority, user, source, msg_id, cluster, sources, title, content, created_at, acked_at, escalated)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (alert_key) DO UPDATE SET acked_at = excluded.acked_at, escalated = excluded.escalated,
+				sources = excluded.sources`,
+			key, record.ID, record.Rule, record.Priority, record.User, record.Source, record.MsgID, record.Cluster,
+			sqlJSON(record.Sources, len(record.Sources) == 0), record.Title, record.Content, record.CreatedAt.UnixNano(),
+			sqlTime(record.AckedAt), record.Escalated)
+		if err != nil {
+			return err
+		}
+		for seq, event := range record.Timeline {
+			_, err = tx.Exec(`INSERT OR IGNORE INTO alert_deliveries (alert_key, seq, time, type, notifier, error)
+				VALUES (?, ?, ?, ?, ?, ?)`, key, seq, event.Time.UnixNano(), event.Type, event.Notifier, event.Error)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddAlert queues the record to be saved by Start, as the history observers must not block
+func (s *SQLStore) AddAlert(record AlertRecord) {
+	select {
+	case s.alerts <- record:
+	default:
+		glog.Errorf("the SQL store is busy, drop the change of alert %d", record.ID)
+	}
+}
+
+// Start saves the queued alerts
+func (s *SQLStore) Start() {
+	for record := range s.alerts {
+		if err := s.SaveAlert(record); err != nil {
+			glog.Errorf("failed to save alert %d, ERR: %v", record.ID, err)
+		}
+	}
+}
+
+// AlertDelivery is an event in the timeline of an alert
+type AlertDelivery struct {
+	AlertKey string `json:"alert_key"`
+	Rule     string `json:"rule"`
+	User     string `json:"user"`
+	AlertEvent
+}
+
+// Deliveries are the events in [from, to) of the alerts, the newest first, of the type if not empty
+func (s *SQLStore) Deliveries(eventType string, from, to time.Time, limit int) (deliveries []AlertDelivery, err error) {
+	start, end := sqlRange(from, to)
+	rows, err := s.db.Query(`SELECT d.alert_key, a.rule, a.user, d.time, d.type, d.notifier, d.error
+		FROM alert_deliveries d JOIN alerts a ON a.alert_key = d.alert_key
+		WHERE (? = '' OR d.type = ?) AND d.time >= ? AND d.time < ?
+		ORDER BY d.time DESC LIMIT ?`, eventType, eventType, start, end, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			delivery AlertDelivery
+			at       int64
+		)
+		err = rows.Scan(&delivery.AlertKey, &delivery.Rule, &delivery.User, &at, &delivery.Type, &delivery.Notifier, &delivery.Error)
+		if err != nil {
+			return
+		}
+		delivery.Time = time.Unix(0, at).UTC()
+		deliveries = append(deliveries, delivery)
+	}
+	err = rows.Err()
+	return
+}
+
+// Query runs a statement in a transaction rolled back, e.g. for the CLI, returns the columns and the rows as strings
+func (s *SQLStore) Query(query string, args ...interface{}) (columns []string, values [][]string, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	if columns, err = rows.Columns(); err != nil {
+		return
+	}
+	for rows.Next() {
+		var (
+			cells = make([]sql.NullString, len(columns))
+			dest  = make([]interface{}, len(columns))
+		)
+		for idx := range cells {
+			dest[idx] = &cells[idx]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		row := make([]string, len(columns))
+		for idx, cell := range cells {
+			row[idx] = cell.String
+		}
+		values = append(values, row)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+// openTestSQLStore opens a store in the temp dir by the driver linked into the binary
+func openTestSQLStore(t *testing.T) *SQLStore {
+	store, err := OpenSQLStore("sqlite", filepath.Join(t.TempDir(), SQLStoreFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLStoreMigrate(t *testing.T) {
+	store := openTestSQLStore(t)
+
+	// Applied once
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := store.Version(); err != nil || version != len(sqlMigrations) {
+		t.Errorf("unexpected version %d, err: %v", version, err)
+	}
+
+	if _, err := store.DB().Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, 0)`, len(sqlMigrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Migrate(); err == nil {
+		t.Errorf("expected error of the newer schema")
+	}
+}
+
+func TestSQLStore(t *testing.T) {
+	var (
+		now   = time.Date(2020, 12, 2, 8, 0, 0, 123, time.UTC)
+		store = openTestSQLStore(t)
+		msgs  = []*Msg{
+			(&Msg{Source: "futu", ID: "3", CreatedAt: now, Text: "高盛: 普拉格(PLUG.US) 目标价 上调至 40"}).Extract(),
+			{Source: "futu", ID: "2", CreatedAt: now.Add(-time.Hour), CreateTime: "15:00", Text: "评级"},
+			{Source: "sina", ID: "1000", CreatedAt: now.Add(-2 * time.Hour), Text: "PLUG", Symbols: []string{"PLUG"}},
+		}
+	)
+
+	if err := store.Save(msgs); err != nil {
+		t.Fatal(err)
+	}
+	// Saved again, skipped
+	if err := store.Save(msgs[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := store.Get("futu", "3")
+	if err != nil || !msg.CreatedAt.Equal(now) || msg.Rating == nil || msg.Rating.Broker != msgs[0].Rating.Broker ||
+		!slices.Equal(msg.Symbols, msgs[0].Symbols) {
+		t.Errorf("unexpected msg: %+v, err: %v", msg, err)
+	}
+	if _, err = store.Get("futu", "1"); err != ErrMsgNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	var scanned []string
+	err = store.Scan("", now.Add(-90*time.Minute), time.Time{}, func(msg *Msg) bool {
+		scanned = append(scanned, msg.Source+"/"+msg.ID)
+		return true
+	})
+	// By source in the order saved
+	if err != nil || !slices.Equal(scanned, []string{"futu/3", "futu/2"}) {
+		t.Errorf("unexpected scan: %v, err: %v", scanned, err)
+	}
+
+	sources, _ := store.Sources()
+	if !slices.Equal(sources, []string{"futu", "sina"}) {
+		t.Errorf("unexpected sources: %v", sources)
+	}
+
+	found, err := store.EntityMsgs(MsgEntitySymbol, "PLUG", time.Time{}, time.Time{}, 10)
+	if err != nil || len(found) != 2 || found[0].ID != "3" {
+		t.Errorf("unexpected msgs of the symbol: %v, err: %v", found, err)
+	}
+	found, err = store.EntityMsgs(MsgEntityBroker, "高盛", time.Time{}, time.Time{}, 10)
+	if err != nil || len(found) != 1 || found[0].ID != "3" {
+		t.Errorf("unexpected msgs of the broker: %v, err: %v", found, err)
+	}
+}
+
+func TestSQLStoreScanPages(t *testing.T) {
+	var (
+		now   = time.Now().UTC()
+		store = openTestSQLStore(t)
+		msgs  []*Msg
+	)
+	for idx := 0; idx < sqlScanPageSize+10; idx++ {
+		msgs = append(msgs, &Msg{Source: "futu", ID: time.Duration(idx).String(), CreatedAt: now, Text: "PLUG"})
+	}
+	if err := store.Save(msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	err := store.Scan("futu", time.Time{}, time.Time{}, func(msg *Msg) bool {
+		// The store is usable while scanning
+		if _, err := store.Get(msg.Source, msg.ID); err != nil {
+			t.Fatal(err)
+		}
+		count++
+		return true
+	})
+	if err != nil || count != len(msgs) {
+		t.Errorf("unexpected scan: %d, err: %v", count, err)
+	}
+}
+
+func TestSQLStoreMatchesAndAlerts(t *testing.T) {
+	var (
+		now   = time.Date(2020, 12, 2, 8, 0, 0, 0, time.UTC)
+		store = openTestSQLStore(t)
+		rule  = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+		msg   = &Msg{Source: "futu", ID: "3", CreatedAt: now, Text: "目标价"}
+	)
+
+	store.AddMatch(rule, msg, now)
+	// The first match is kept
+	store.AddMatch(rule, msg, now.Add(time.Minute))
+	matches, err := store.RuleMatches("rate", time.Time{}, time.Time{}, 10)
+	if err != nil || len(matches) != 1 || !matches[0].MatchedAt.Equal(now) || matches[0].MsgID != "3" {
+		t.Errorf("unexpected matches: %+v, err: %v", matches, err)
+	}
+
+	record := AlertRecord{ID: 1, Rule: "rate", Priority: PriorityInfo, User: "alice", Source: "futu", MsgID: "3",
+		Title: "rate", Content: "目标价", CreatedAt: now,
+		Timeline: []AlertEvent{{Time: now, Type: AlertEventSent, Notifier: "phone"}}}
+	if err = store.SaveAlert(record); err != nil {
+		t.Fatal(err)
+	}
+	// Acked after a failed delivery
+	acked := now.Add(time.Minute)
+	record.AckedAt = &acked
+	record.Timeline = append(record.Timeline, AlertEvent{Time: now.Add(time.Second), Type: AlertEventFailed, Notifier: "mail", Error: "timeout"})
+	if err = store.SaveAlert(record); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := store.Deliveries("", time.Time{}, time.Time{}, 10)
+	if err != nil || len(deliveries) != 2 || deliveries[0].Type != AlertEventFailed || deliveries[0].User != "alice" ||
+		deliveries[0].AlertKey != AlertKey(record) {
+		t.Errorf("unexpected deliveries: %+v, err: %v", deliveries, err)
+	}
+	_, rows, err := store.Query(`SELECT acked_at FROM alerts WHERE alert_key = ?`, AlertKey(record))
+	if err != nil || len(rows) != 1 || rows[0][0] == "" {
+		t.Errorf("unexpected alert: %v, err: %v", rows, err)
+	}
+
+	// The changes of the queries are rolled back
+	if _, _, err = store.Query(`DELETE FROM alerts`); err != nil {
+		t.Fatal(err)
+	}
+	if _, rows, _ = store.Query(`SELECT COUNT(*) FROM alerts`); len(rows) != 1 || rows[0][0] != "1" {
+		t.Errorf("unexpected count: %v", rows)
+	}
+}
+
+func TestImportFileStore(t *testing.T) {
+	var (
+		now  = time.Date(2020, 12, 2, 8, 0, 0, 0, time.UTC)
+		from = NewFileMsgStore(t.TempDir())
+		to   = NewFileMsgStore(t.TempDir())
+		msgs []*Msg
+	)
+	for idx := 0; idx < msgImportBatchSize+1; idx++ {
+		msgs = append(msgs, &Msg{Source: "futu", ID: time.Duration(idx).String(), CreatedAt: now, Text: "PLUG"})
+	}
+	msgs = append(msgs, &Msg{Source: "sina", ID: "1000", CreatedAt: now, Text: "PLUG"})
+	if err := from.Save(msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	total, err := ImportFileStore(to, from.dir)
+	if err != nil || total != len(msgs) {
+		t.Fatalf("unexpected import: %d, %v", total, err)
+	}
+	if msg, err := to.Get("sina", "1000"); err != nil || !msg.CreatedAt.Equal(now) {
+		t.Errorf("unexpected msg: %+v, %v", msg, err)
+	}
+}
+
+func TestAlertRouterObserveMatch(t *testing.T) {
+	var (
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		phone         = &fakeNotifier{name: "phone"}
+		router        = NewAlertRouter(subscriptions).Notifier(phone).Clusters(NewMsgClusters(10*time.Minute, 0.6, 10))
+		rule          = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+		matched       []string
+	)
+	subscriptions.Load([]config.Subscription{
+		{User: "alice", Rules: []string{"other"}, Notifiers: []string{"phone"}},
+	})
+	router.ObserveMatch(func(filter MsgFilter, msg *Msg, at time.Time) {
+		matched = append(matched, filter.Name()+"/"+msg.ID)
+	})
+
+	router.Route(rule, &Msg{Source: "futu", ID: "3", Text: "目标价"})
+	// Observed though no one subscribes the rule
+	if !slices.Equal(matched, []string{"rate/3"}) || len(phone.titles) != 0 {
+		t.Errorf("unexpected matches %v, alerts %v", matched, phone.titles)
+	}
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	SubscriptionsFileName = "subscriptions.json"
+)
+
+var (
+	TheSubscriptions = NewSubscriptions(filepath.Join(config.Config.Store.Dir, SubscriptionsFileName))
+
+	SubscriptionLanguages = []string{"", "zh", "en"}
+)
+
+func init() {
+	if err := TheSubscriptions.Load(config.Config.Subscriptions); err != nil {
+		glog.Errorf("failed to load the subscriptions, ERR: %v", err)
+	}
+}
+
+// QuietHours is a daily range of the clock, it may cross the midnight
+type QuietHours struct {
+	// Minutes of the day
+	start, end int
+}
+
+// ParseQuietHours parses 23:00-07:00, the zero QuietHours if empty
+func ParseQuietHours(value string) (q QuietHours, err error) {
+	if value == "" {
+		return
+	}
+	var startHour, startMinute, endHour, endMinute int
+	if _, err = fmt.Sscanf(value, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute); err != nil ||
+		startHour > 23 || endHour > 24 || startMinute > 59 || endMinute > 59 {
+		return q, fmt.Errorf("invalid quiet hours %s, expect 23:00-07:00", value)
+	}
+	return QuietHours{start: startHour*60 + startMinute, end: endHour*60 + endMinute}, nil
+}
+
+func (q QuietHours) IsZero() bool {
+	return q.start == q.end
+}
+
+// Contains tells if the clock of t is in the quiet hours
+func (q QuietHours) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if q.start <= q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+// SubscriptionLocation is the timezone of the subscription, the zone of the sources if not given
+func SubscriptionLocation(s config.Subscription) (*time.Location, error) {
+	if s.Timezone == "" {
+		return utils.ShanghaiLocation, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+// InQuietHours tells if the subscription is quiet at the time, in the quiet hours or on the weekends
+func InQuietHours(s config.Subscription, t time.Time) bool {
+	loc, err := SubscriptionLocation(s)
+	if err != nil {
+		return false
+	}
+	t = t.In(loc)
+	if s.QuietWeekends && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return true
+	}
+	q, err := ParseQuietHours(s.QuietHours)
+	if err != nil || q.IsZero() {
+		return false
+	}
+	return q.Contains(t)
+}
+
+// Subscriptions are seeded from the config, the file in the store dir takes over once changed by the API
+type Subscriptions struct {
+	lock     sync.RWMutex
+	fileName string
+	items    map[string]config.Subscription
+}
+
+func NewSubscriptions(fileName string) *Subscriptions {
+	return &Subscriptions{
+		fileName: fileName,
+		items:    make(map[string]config.Subscription),
+	}
+}
+
+// Load uses the saved subscriptions if any, or the defaults
+func (s *Subscriptions) Load(defaults []config.Subscription) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.items = make(map[string]config.Subscription)
+	data, err := utils.ReadFromFile(s.fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		for _, subscription := range defaults {
+			s.items[subscription.User] = subscription
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved []config.Subscription
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s: %v", s.fileName, err)
+	}
+	for _, subscription := range saved {
+		s.items[subscription.User] = subscription
+	}
+	return nil
+}
+
+func (s *Subscriptions) save() error {
+	data, _ := json.MarshalIndent(s.list(), "", "  ")
+	if err := os.MkdirAll(filepath.Dir(s.fileName), 0755); err != nil {
+		return err
+	}
+	return utils.SaveToFile(s.fileName, data)
+}
+
+func (s *Subscriptions) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.items)
+}
+
+// List returns the subscriptions sorted by user
+func (s *Subscriptions) List() []config.Subscription {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.list()
+}
+
+func (s *Subscriptions) list() []config.Subscription {
+	list := make([]config.Subscription, 0, len(s.items))
+	for _, subscription := range s.items {
+		list = append(list, subscription)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].User < list[j].User
+	})
+	return list
+}
+
+func (s *Subscriptions) Get(user string) (subscription config.Subscription, hit bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	subscription, hit = s.items[user]
+	return
+}
+
+// Put adds or replaces the subscription of the user
+func (s *Subscriptions) Put(subscription config.Subscription) error {
+	if err := ValidateSubscription(subscription); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.items[subscription.User] = subscription
+	return s.save()
+}
+
+func (s *Subscriptions) Delete(user string) (hit bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, hit = s.items[user]; !hit {
+		return
+	}
+	delete(s.items, user)
+	return true, s.save()
+}
+
+// Match returns the subscriptions of the msg matched by the rule
+func (s *Subscriptions) Match(rule string, msg *Msg) (matched []config.Subscription) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, subscription := range s.list() {
+		if len(subscription.Rules) > 0 && !slices.Contains(subscription.Rules, rule) {
+			continue
+		}
+		if len(subscription.Watchlists) > 0 && !matchWatchlists(subscription.Watchlists, msg) {
+			continue
+		}
+		matched = append(matched, subscription)
+	}
+	return
+}
+
+// Rules returns the rules referenced by the subscriptions
+func (s *Subscriptions) Rules() (rules []string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, subscription := range s.items {
+		for _, rule := range subscription.Rules {
+			if !slices.Contains(rules, rule) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	sort.Strings(rules)
+	return
+}
+
+// ValidateSubscription checks the subscription against the loaded rules, watchlists and notifiers
+func ValidateSubscription(s config.Subscription) error {
+	if s.User == "" {
+		return fmt.Errorf("missing user")
+	}
+	for _, rule := range s.Rules {
+		if _, err := GetRule(rule); err != nil {
+			return err
+		}
+	}
+	for _, watchlist := range s.Watchlists {
+		if _, err := GetWatchlist(watchlist); err != nil {
+			return err
+		}
+	}
+	notifiers := append(append([]string{}, s.Notifiers...), s.EscalationNotifiers...)
+	for priority, names := range s.PriorityNotifiers {
+		if !slices.Contains(Priorities, priority) {
+			return fmt.Errorf("unknown priority %s, expect %v", priority, Priorities)
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no notifier of priority %s", priority)
+		}
+		notifiers = append(notifiers, names...)
+	}
+	for _, notifier := range notifiers {
+		if _, err := TheAlertRouter.GetNotifier(notifier); err != nil {
+			return err
+		}
+	}
+	return validateSubscriptionOptions(s)
+}
+
+func validateSubscriptionOptions(s config.Subscription) error {
+	if _, err := ParseQuietHours(s.QuietHours); err != nil {
+		return err
+	}
+	if _, err := SubscriptionLocation(s); err != nil {
+		return err
+	}
+	if !slices.Contains(SubscriptionLanguages, s.Language) {
+		return fmt.Errorf("unknown language %s, expect zh or en", s.Language)
+	}
+	if err := validateDelivery(s.Delivery); err != nil {
+		return err
+	}
+	for rule, delivery := range s.RuleDeliveries {
+		if err := validateDelivery(delivery); err != nil {
+			return fmt.Errorf("delivery of %s: %v", rule, err)
+		}
+	}
+	return nil
+}
+
+// ValidateSubscriptions checks the subscriptions of the config, the notifiers and the watchlists are checked by Validate
+func ValidateSubscriptions(c *config.Configuration) (errs []error) {
+	rules := make(map[string]bool)
+	for name := range builtinRules {
+		rules[name] = true
+	}
+	for _, rule := range c.Rules {
+		rules[rule.Name] = true
+	}
+
+	for idx, subscription := range c.Subscriptions {
+		for _, rule := range subscription.Rules {
+			if !rules[rule] {
+				errs = append(errs, fmt.Errorf("Subscriptions[%d]: unknown rule %s", idx, rule))
+			}
+		}
+		if err := validateSubscriptionOptions(subscription); err != nil {
+			errs = append(errs, fmt.Errorf("Subscriptions[%d]: %v", idx, err))
+		}
+	}
+	return
+}
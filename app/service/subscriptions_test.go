@@ -0,0 +1,205 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+type fakeNotifier struct {
+	name     string
+	titles   []string
+	contents []string
+}
+
+func (n *fakeNotifier) Name() string {
+	return n.name
+}
+
+func (n *fakeNotifier) Endpoint() string {
+	return "fake://" + n.name
+}
+
+func (n *fakeNotifier) Notify(title, content string) error {
+	n.titles = append(n.titles, title)
+	n.contents = append(n.contents, content)
+	return nil
+}
+
+func TestQuietHours(t *testing.T) {
+	for _, c := range []struct {
+		hours string
+		clock string
+		want  bool
+	}{
+		{"23:00-07:00", "23:30", true},
+		{"23:00-07:00", "06:59", true},
+		{"23:00-07:00", "07:00", false},
+		{"12:00-13:30", "13:00", true},
+		{"12:00-13:30", "14:00", false},
+	} {
+		q, err := ParseQuietHours(c.hours)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock, _ := time.Parse("15:04", c.clock)
+		if got := q.Contains(clock); got != c.want {
+			t.Errorf("%s contains %s: got %v, want %v", c.hours, c.clock, got, c.want)
+		}
+	}
+	if _, err := ParseQuietHours("late night"); err == nil {
+		t.Error("expect the error of invalid quiet hours")
+	}
+
+	// 23:30 in Shanghai is 15:30 UTC
+	var (
+		now      = time.Date(2020, 12, 1, 15, 30, 0, 0, time.UTC)
+		shanghai = config.Subscription{QuietHours: "23:00-07:00"}
+		london   = config.Subscription{QuietHours: "23:00-07:00", Timezone: "Europe/London"}
+	)
+	if !InQuietHours(shanghai, now) || InQuietHours(london, now) {
+		t.Error("quiet hours should be in the timezone of the subscription")
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	var (
+		fileName      = filepath.Join(t.TempDir(), SubscriptionsFileName)
+		subscriptions = NewSubscriptions(fileName)
+	)
+	if err := subscriptions.Load([]config.Subscription{{User: "alice", Rules: []string{"rate"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if subscriptions.Len() != 1 {
+		t.Fatalf("len %d, want the default one", subscriptions.Len())
+	}
+
+	if err := subscriptions.Put(config.Subscription{User: "bob", Rules: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := subscriptions.Put(config.Subscription{User: "carol", Rules: []string{"unknown"}}); err == nil {
+		t.Error("expect the error of the unknown rule")
+	}
+	if hit, err := subscriptions.Delete("alice"); !hit || err != nil {
+		t.Errorf("delete alice: %v %v", hit, err)
+	}
+
+	// The saved ones take over the defaults
+	reloaded := NewSubscriptions(fileName)
+	if err := reloaded.Load([]config.Subscription{{User: "alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	list := reloaded.List()
+	if len(list) != 1 || list[0].User != "bob" {
+		t.Errorf("reloaded %+v", list)
+	}
+	if rules := reloaded.Rules(); len(rules) != 1 || rules[0] != "test" {
+		t.Errorf("rules %v", rules)
+	}
+}
+
+func TestAlertRouter(t *testing.T) {
+	TheWatchlists["plug"] = NewWatchlist("plug", "PLUG")
+	defer delete(TheWatchlists, "plug")
+
+	var (
+		subscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+		phone         = &fakeNotifier{name: "phone"}
+		mail          = &fakeNotifier{name: "mail"}
+		router        = NewAlertRouter(subscriptions).Notifier(phone).Notifier(mail)
+		rule          = NewKeywordMsgFilter(config.Rule{Name: "rate", Keywords: []string{"目标价"}})
+	)
+	router.now = func() time.Time {
+		// 12:00 in Shanghai
+		return time.Date(2020, 12, 1, 4, 0, 0, 0, time.UTC)
+	}
+	subscriptions.Load([]config.Subscription{
+		{User: "alice", Rules: []string{"rate"}, Notifiers: []string{"phone", "mail"}},
+		{User: "bob", Rules: []string{"rate"}, Watchlists: []string{"plug"}, Notifiers: []string{"phone"}},
+		{User: "carol", Notifiers: []string{"mail"}, QuietHours: "11:00-13:00"},
+		{User: "dave", Rules: []string{"other"}, Notifiers: []string{"mail"}},
+	})
+
+	router.Route(rule, (&Msg{Text: "普拉格(PLUG.US) 目标价 上调至 40"}).Extract())
+	router.Route(rule, (&Msg{Text: "特斯拉 目标价 上调至 800"}).Extract())
+
+	// alice gets both, bob only the one of PLUG, carol is quiet and dave has no rate
+	if len(phone.titles) != 3 || len(mail.titles) != 2 {
+		t.Errorf("phone %v, mail %v", phone.titles, mail.titles)
+	}
+}
+
+func TestAPISubscriptions(t *testing.T) {
+	defer func(subscriptions *Subscriptions) { TheSubscriptions = subscriptions }(TheSubscriptions)
+	TheSubscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+
+	var (
+		server = NewAPIServer("").Tokens([]string{"secret"})
+		do     = func(method, path, body string) *httptest.ResponseRecorder {
+			recorder, request := httptest.NewRecorder(), httptest.NewRequest(method, path, strings.NewReader(body))
+			request.Header.Set("Authorization", "Bearer secret")
+			server.ServeHTTP(recorder, request)
+			return recorder
+		}
+	)
+
+	if r := do(http.MethodPut, "/subscriptions/alice", `{"rules": ["rate"], "quiet_hours": "23:00-07:00"}`); r.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", r.Code, r.Body.String())
+	}
+	if r := do(http.MethodPut, "/subscriptions/bob", `{"notifiers": ["unknown"]}`); r.Code != http.StatusBadRequest {
+		t.Errorf("put unknown notifier: %d %s", r.Code, r.Body.String())
+	}
+	if r := do(http.MethodPut, "/subscriptions/bob", `{"priority_notifiers": {"info": []}}`); r.Code != http.StatusBadRequest {
+		t.Errorf("put no notifier of the priority: %d %s", r.Code, r.Body.String())
+	}
+	if r := do(http.MethodGet, "/subscriptions/alice", ""); r.Code != http.StatusOK || !strings.Contains(r.Body.String(), `"quiet_hours":"23:00-07:00"`) {
+		t.Errorf("get: %d %s", r.Code, r.Body.String())
+	}
+	if r := do(http.MethodDelete, "/subscriptions/alice", ""); r.Code != http.StatusNoContent {
+		t.Errorf("delete: %d %s", r.Code, r.Body.String())
+	}
+	if r := do(http.MethodGet, "/subscriptions", ""); r.Code != http.StatusOK || strings.TrimSpace(r.Body.String()) != "[]" {
+		t.Errorf("list: %d %s", r.Code, r.Body.String())
+	}
+}
+
+func TestAPIAuthorize(t *testing.T) {
+	defer func(subscriptions *Subscriptions) { TheSubscriptions = subscriptions }(TheSubscriptions)
+	TheSubscriptions = NewSubscriptions(filepath.Join(t.TempDir(), SubscriptionsFileName))
+
+	for _, c := range []struct {
+		tokens                    []string
+		remoteAddr, authorization string
+		code                      int
+	}{
+		// Not from the loopback without tokens
+		{nil, "192.0.2.1:1234", "", http.StatusForbidden},
+		{nil, "192.0.2.1:1234", "Bearer secret", http.StatusForbidden},
+		{[]string{"secret"}, "127.0.0.1:1234", "", http.StatusUnauthorized},
+		{[]string{"secret"}, "[::1]:1234", "Bearer wrong", http.StatusUnauthorized},
+		{[]string{"secret"}, "192.0.2.1:1234", "Bearer secret", http.StatusOK},
+		{nil, "[::1]:1234", "", http.StatusOK},
+	} {
+		recorder, request := httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/subscriptions/alice", strings.NewReader(`{"rules": ["rate"]}`))
+		request.RemoteAddr = c.remoteAddr
+		if c.authorization != "" {
+			request.Header.Set("Authorization", c.authorization)
+		}
+		NewAPIServer("").Tokens(c.tokens).ServeHTTP(recorder, request)
+		if recorder.Code != c.code {
+			t.Errorf("%v %s %q: %d %s", c.tokens, c.remoteAddr, c.authorization, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// Reading is open
+	recorder := httptest.NewRecorder()
+	NewAPIServer("").Tokens([]string{"secret"}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/subscriptions", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("list: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	BarkDefaultServer = "https://api.day.app"
+)
+
+// APIError is the unexpected response of an API
+type APIError struct {
+	Code int
+	Body string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected response, code: %d, body: %s", e.Code, e.Body)
+}
+
+// postJSON posts the JSON of v, the response is decoded into result if given
+func postJSON(url string, v interface{}, result interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	rCode, rBody, rErr := SendRequest(http.MethodPost, url, bytes.NewBuffer(data))
+	if rErr != nil {
+		return rErr
+	}
+	if rCode < http.StatusOK || rCode >= http.StatusMultipleChoices {
+		return &APIError{Code: rCode, Body: rBody}
+	}
+	if result != nil {
+		if err = json.Unmarshal([]byte(rBody), result); err != nil {
+			return &APIError{Code: rCode, Body: rBody}
+		}
+	}
+	return nil
+}
+
+// NeuronAlert is the body of the send API of a Neuron user
+type NeuronAlert struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+// NeuronClient sends the alerts to a Neuron user
+type NeuronClient struct {
+	url string
+}
+
+func NewNeuronClient(server, user string) *NeuronClient {
+	return &NeuronClient{
+		url: strings.TrimRight(server, "/") + "/users/" + user + "/send",
+	}
+}
+
+// Endpoint is the endpoint of the breaker of the client
+func (c *NeuronClient) Endpoint() string {
+	return EndpointOf(c.url)
+}
+
+func (c *NeuronClient) Send(title, content string) error {
+	if err := postJSON(c.url, NeuronAlert{Title: title, Content: content}, nil); err != nil {
+		glog.Errorf("failed to send alert to %s, ERR: %v", c.url, err)
+		return err
+	}
+	glog.V(4).Infof("SEND ALERT SUCCESSFULLY, title: %s, content: %s", title, content)
+	return nil
+}
+
+// BarkOptions are the options of the Bark pushes
+type BarkOptions struct {
+	Group string `json:"group,omitempty"`
+	Sound string `json:"sound,omitempty"`
+	// active, timeSensitive, passive or critical
+	Level string `json:"level,omitempty"`
+	// Opened when the alert is tapped
+	URL  string `json:"url,omitempty"`
+	Icon string `json:"icon,omitempty"`
+}
+
+// BarkPush is the body of the push API of Bark
+type BarkPush struct {
+	DeviceKey string `json:"device_key"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	BarkOptions
+}
+
+// BarkResult is the response of the push API of Bark
+type BarkResult struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// BarkClient pushes the alerts to a Bark device
+type BarkClient struct {
+	url     string
+	key     string
+	options BarkOptions
+}
+
+func NewBarkClient(server, key string) *BarkClient {
+	if server == "" {
+		server = BarkDefaultServer
+	}
+	return &BarkClient{
+		url: strings.TrimRight(server, "/") + "/push",
+		key: key,
+	}
+}
+
+func (c *BarkClient) Options(options BarkOptions) *BarkClient {
+	c.options = options
+	return c
+}
+
+// Endpoint is the endpoint of the breaker of the client
+func (c *BarkClient) Endpoint() string {
+	return EndpointOf(c.url)
+}
+
+func (c *BarkClient) Send(title, content string) error {
+	var (
+		push   = BarkPush{DeviceKey: c.key, Title: title, Body: content, BarkOptions: c.options}
+		result BarkResult
+	)
+	err := postJSON(c.url, push, &result)
+	if err == nil && result.Code != http.StatusOK {
+		err = fmt.Errorf("bark push failed, code: %d, message: %s", result.Code, result.Message)
+	}
+	if err != nil {
+		glog.Errorf("failed to push alert to %s, ERR: %v", c.url, err)
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var alertTextSeeds = []string{
+	"",
+	"普拉格能源(PLUG.US)评级至“增持”",
+	`quote " backslash \ slash /`,
+	"line\nbreak\ttab\r",
+	"<p>html &amp; entities</p>",
+	"path/segments/../?query=1#fragment %2F",
+	"\x00\x1f  ",
+}
+
+// receive returns a stand-in server which decodes the JSON body into v
+func receive(t testing.TB, v interface{}, response string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Errorf("invalid JSON %q: %v", data, err)
+		}
+		io.WriteString(w, response)
+	}))
+}
+
+func FuzzNeuronClient(f *testing.F) {
+	for _, seed := range alertTextSeeds {
+		f.Add(seed, seed)
+	}
+	f.Fuzz(func(t *testing.T, title, content string) {
+		if !utf8.ValidString(title) || !utf8.ValidString(content) {
+			t.Skip()
+		}
+		var got NeuronAlert
+		server := receive(t, &got, "{}")
+		defer server.Close()
+
+		if err := NewNeuronClient(server.URL, "user").Send(title, content); err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != title || got.Content != content {
+			t.Errorf("got %q %q, want %q %q", got.Title, got.Content, title, content)
+		}
+	})
+}
+
+func FuzzBarkClient(f *testing.F) {
+	for _, seed := range alertTextSeeds {
+		f.Add(seed, seed)
+	}
+	f.Fuzz(func(t *testing.T, title, content string) {
+		if !utf8.ValidString(title) || !utf8.ValidString(content) {
+			t.Skip()
+		}
+		var got BarkPush
+		server := receive(t, &got, `{"code": 200, "message": "success"}`)
+		defer server.Close()
+
+		options := BarkOptions{Group: "rate", Sound: "alarm", Level: "timeSensitive", URL: "https://futunn.com", Icon: "https://futunn.com/icon.png"}
+		if err := NewBarkClient(server.URL, "key").Options(options).Send(title, content); err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != title || got.Body != content || got.DeviceKey != "key" || got.BarkOptions != options {
+			t.Errorf("got %+v", got)
+		}
+	})
+}
+
+func TestAlertClientStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/users/missing"):
+			http.Error(w, "no such user", http.StatusNotFound)
+		default:
+			io.WriteString(w, `{"code": 400, "message": "failed to get device token"}`)
+		}
+	}))
+	defer server.Close()
+
+	var apiErr *APIError
+	if err := NewNeuronClient(server.URL, "missing").Send("title", "content"); !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		t.Errorf("neuron: %v", err)
+	}
+	if err := NewBarkClient(server.URL, "bad").Send("title", "content"); err == nil || !strings.Contains(err.Error(), "device token") {
+		t.Errorf("bark: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+const (
+	AlertQueueFlushInterval = 10 * time.Second
+)
+
+var (
+	TheAlertQueue = NewAlertQueue(config.Config.CircuitBreaker.AlertQueueSize)
+
+	alertQueueLength = expvar.NewInt("alert_queue_length")
+)
+
+// retryable tells if the alert failed by the error is kept to send again:
+// the open breaker, the transport errors and the 5xx responses
+func retryable(err error) bool {
+	var (
+		urlErr *url.Error
+		apiErr *APIError
+	)
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= http.StatusInternalServerError
+	}
+	return errors.Is(err, ErrCircuitOpen) || errors.As(err, &urlErr)
+}
+
+// queueIfRetryable sends the alert to the endpoint, keeps it until the endpoint comes back if it failed to be retried
+func queueIfRetryable(endpoint, name string, send func() error) error {
+	err := send()
+	if retryable(err) {
+		glog.Warningf("failed to send alert %s, queue it, ERR: %v", name, err)
+		TheAlertQueue.Push(endpoint, name, send)
+		return nil
+	}
+	return err
+}
+
+type pendingAlert struct {
+	name     string
+	send     func() error
+	queuedAt time.Time
+}
+
+// AlertQueue keeps the alerts which can not be sent for now, e.g. while the breaker of the notifier is open.
+// The alerts of an endpoint are retried in order until it comes back, the other endpoints are not blocked.
+type AlertQueue struct {
+	maxSize int
+
+	lock *sync.Mutex
+	// Endpoint -> the alerts from the oldest
+	queues    map[string][]*pendingAlert
+	flushing  *sync.Mutex
+	startOnce *sync.Once
+}
+
+// NewAlertQueue creates the queue keeping at most maxSize alerts per endpoint, no limit if 0
+func NewAlertQueue(maxSize int) *AlertQueue {
+	return &AlertQueue{
+		maxSize:   maxSize,
+		lock:      &sync.Mutex{},
+		queues:    make(map[string][]*pendingAlert),
+		flushing:  &sync.Mutex{},
+		startOnce: &sync.Once{},
+	}
+}
+
+// Len returns the number of the alerts of all the endpoints
+func (q *AlertQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.len()
+}
+
+func (q *AlertQueue) len() (length int) {
+	for _, alerts := range q.queues {
+		length += len(alerts)
+	}
+	return
+}
+
+// Queued returns the number of the alerts of the endpoint
+func (q *AlertQueue) Queued(endpoint string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.queues[endpoint])
+}
+
+// Push queues the alert of the endpoint, the oldest one of the endpoint is dropped if its queue is full
+func (q *AlertQueue) Push(endpoint, name string, send func() error) {
+	q.lock.Lock()
+	alerts := q.queues[endpoint]
+	if q.maxSize > 0 && len(alerts) >= q.maxSize {
+		glog.Errorf("ALERT QUEUE OF %s FULL, DROP THE OLDEST ALERT: %s", endpoint, alerts[0].name)
+		alerts = alerts[1:]
+	}
+	q.queues[endpoint] = append(alerts, &pendingAlert{name: name, send: send, queuedAt: time.Now()})
+	alertQueueLength.Set(int64(q.len()))
+	q.lock.Unlock()
+
+	glog.V(4).Infof("ALERT QUEUED: %s", name)
+	q.startOnce.Do(func() {
+		go q.AutoFlush()
+	})
+}
+
+func (q *AlertQueue) AutoFlush() {
+	ticker := time.NewTicker(AlertQueueFlushInterval)
+	for range ticker.C {
+		q.Flush()
+	}
+}
+
+// Flush sends the queued alerts of each endpoint in order, stops at the first one of the endpoint
+// still failing to be retried, and drops the ones rejected for good. Only one flush runs at a time,
+// the others return 0 at once.
+func (q *AlertQueue) Flush() (sent int) {
+	if !q.flushing.TryLock() {
+		return 0
+	}
+	defer q.flushing.Unlock()
+
+	q.lock.Lock()
+	endpoints := make([]string, 0, len(q.queues))
+	for endpoint := range q.queues {
+		endpoints = append(endpoints, endpoint)
+	}
+	q.lock.Unlock()
+	sort.Strings(endpoints)
+
+	for _, endpoint := range endpoints {
+		sent += q.flush(endpoint)
+	}
+	return
+}
+
+func (q *AlertQueue) flush(endpoint string) (sent int) {
+	for {
+		q.lock.Lock()
+		alerts := q.queues[endpoint]
+		if len(alerts) == 0 {
+			delete(q.queues, endpoint)
+			q.lock.Unlock()
+			return
+		}
+		alert := alerts[0]
+		q.lock.Unlock()
+
+		err := alert.send()
+		if retryable(err) {
+			// Keep the alert for the next round
+			glog.V(4).Infof("QUEUED ALERT %s NOT SENT YET, ERR: %v", alert.name, err)
+			return
+		}
+
+		q.remove(endpoint, alert)
+		if err != nil {
+			glog.Errorf("failed to send queued alert %s, drop it, ERR: %v", alert.name, err)
+			continue
+		}
+		glog.V(4).Infof("QUEUED ALERT SENT: %s, queued at %s", alert.name, alert.queuedAt)
+		sent++
+	}
+}
+
+// remove removes the alert if it is not dropped by Push while being sent
+func (q *AlertQueue) remove(endpoint string, alert *pendingAlert) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	alerts := q.queues[endpoint]
+	for idx := range alerts {
+		if alerts[idx] == alert {
+			q.queues[endpoint] = append(alerts[:idx:idx], alerts[idx+1:]...)
+			break
+		}
+	}
+	alertQueueLength.Set(int64(q.len()))
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"errors"
+	"expvar"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/config"
+)
+
+// BreakerState ...
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+var (
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// Exported on /debug/vars, one map per endpoint
+	breakerMetrics = expvar.NewMap("circuit_breakers")
+
+	breakersLock = &sync.Mutex{}
+	breakers     = make(map[string]*CircuitBreaker)
+)
+
+// CircuitBreaker skips the calls to an endpoint after too many failures in a row,
+// and lets a single trial call through once the cool down is over
+type CircuitBreaker struct {
+	name             string
+	failureThreshold int
+	successThreshold int
+	coolDown         time.Duration
+
+	lock      *sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	trialing  bool
+
+	now     func() time.Time
+	metrics *expvar.Map
+}
+
+func NewCircuitBreaker(name string) *CircuitBreaker {
+	b := &CircuitBreaker{
+		name:             name,
+		failureThreshold: config.Config.CircuitBreaker.FailureThreshold,
+		successThreshold: config.Config.CircuitBreaker.SuccessThreshold,
+		coolDown:         time.Duration(config.Config.CircuitBreaker.CoolDownSeconds) * time.Second,
+		lock:             &sync.Mutex{},
+		now:              time.Now,
+		metrics:          new(expvar.Map).Init(),
+	}
+	b.metrics.Set("state", expvarString(BreakerClosed.String()))
+	return b
+}
+
+func (b *CircuitBreaker) FailureThreshold(failureThreshold int) *CircuitBreaker {
+	b.failureThreshold = failureThreshold
+	return b
+}
+
+func (b *CircuitBreaker) SuccessThreshold(successThreshold int) *CircuitBreaker {
+	b.successThreshold = successThreshold
+	return b
+}
+
+func (b *CircuitBreaker) CoolDown(coolDown time.Duration) *CircuitBreaker {
+	b.coolDown = coolDown
+	return b
+}
+
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// Allow returns ErrCircuitOpen if the call should be skipped.
+// Every allowed call must be followed by Success or Failure.
+func (b *CircuitBreaker) Allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.coolDown {
+			b.metrics.Add("rejected", 1)
+			return ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+		b.trialing = true
+		return nil
+	case BreakerHalfOpen:
+		// Only one trial call at a time
+		if b.trialing {
+			b.metrics.Add("rejected", 1)
+			return ErrCircuitOpen
+		}
+		b.trialing = true
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) Success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.metrics.Add("successes", 1)
+	b.failures = 0
+	if b.state != BreakerHalfOpen {
+		return
+	}
+
+	b.trialing = false
+	b.successes++
+	if b.successes >= b.successThreshold {
+		b.setState(BreakerClosed)
+	}
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.metrics.Add("failures", 1)
+	switch b.state {
+	case BreakerHalfOpen:
+		b.trialing = false
+		b.setState(BreakerOpen)
+	case BreakerClosed:
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// setState must be called with the lock held
+func (b *CircuitBreaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	glog.Warningf("CIRCUIT BREAKER %s: %s -> %s", b.name, b.state, state)
+
+	b.state = state
+	b.failures = 0
+	b.successes = 0
+	if state == BreakerOpen {
+		b.openedAt = b.now()
+	}
+	b.metrics.Set("state", expvarString(state.String()))
+	b.metrics.Add("transitions", 1)
+}
+
+// GetCircuitBreaker returns the shared breaker of the endpoint, creates it if necessary
+func GetCircuitBreaker(endpoint string) *CircuitBreaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	b, hit := breakers[endpoint]
+	if !hit {
+		b = NewCircuitBreaker(endpoint)
+		breakers[endpoint] = b
+		breakerMetrics.Set(endpoint, b.metrics)
+	}
+	return b
+}
+
+// EndpointOf returns the scheme and host of the uri, the breakers are shared by all the paths of a host
+func EndpointOf(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return uri
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func expvarString(s string) *expvar.String {
+	v := new(expvar.String)
+	v.Set(s)
+	return v
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var (
+		clock = &fakeClock{t: time.Now()}
+		b     = NewCircuitBreaker("test").FailureThreshold(2).SuccessThreshold(1).CoolDown(time.Minute)
+	)
+	b.now = clock.Now
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("closed breaker rejected call %d: %v", i, err)
+		}
+		b.Failure()
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected open after 2 failures, got %s", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open breaker allowed a call")
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a trial call after cool down, got %v", err)
+	}
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("half-open breaker allowed a second trial")
+	}
+	b.Failure()
+	if b.State() != BreakerOpen {
+		t.Fatalf("failed trial should reopen, got %s", b.State())
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a trial call after cool down, got %v", err)
+	}
+	b.Success()
+	if b.State() != BreakerClosed {
+		t.Fatalf("successful trial should close, got %s", b.State())
+	}
+}
+
+func TestSendRequestWithBreaker(t *testing.T) {
+	var (
+		calls  = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+	)
+	defer server.Close()
+
+	GetCircuitBreaker(EndpointOf(server.URL)).FailureThreshold(2)
+	for i := 0; i < 5; i++ {
+		SendRequest(http.MethodGet, server.URL+"/feed", nil)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls before the breaker opens, got %d", calls)
+	}
+
+	_, _, err := SendRequest(http.MethodGet, server.URL+"/other", nil)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected the breaker shared by the host, got %v", err)
+	}
+}
+
+func TestAlertQueue(t *testing.T) {
+	var (
+		q     = NewAlertQueue(2)
+		down  = true
+		sent  []string
+		queue = func(endpoint, name string) {
+			q.Push(endpoint, name, func() error {
+				if down {
+					return ErrCircuitOpen
+				}
+				sent = append(sent, name)
+				return nil
+			})
+		}
+	)
+
+	queue("neuron", "a")
+	queue("neuron", "b")
+	queue("neuron", "c")
+	if q.Len() != 2 {
+		t.Fatalf("expected the queue bounded to 2, got %d", q.Len())
+	}
+	if n := q.Flush(); n != 0 {
+		t.Fatalf("sent %d alerts while the breaker is open", n)
+	}
+
+	down = false
+	if n := q.Flush(); n != 2 || q.Len() != 0 {
+		t.Fatalf("expected 2 alerts flushed, got %d, %d left", n, q.Len())
+	}
+	if sent[0] != "b" || sent[1] != "c" {
+		t.Errorf("unexpected order: %v", sent)
+	}
+}
+
+func TestAlertQueueEndpoints(t *testing.T) {
+	var (
+		q    = NewAlertQueue(0)
+		sent []string
+		push = func(endpoint, name string, err error) {
+			q.Push(endpoint, name, func() error {
+				if err == nil {
+					sent = append(sent, name)
+				}
+				return err
+			})
+		}
+	)
+	// The broken endpoint does not block the others, the rejected alert is dropped
+	push("bark", "bark down", &APIError{Code: http.StatusServiceUnavailable})
+	push("neuron", "neuron rejected", errors.New("400 bad request"))
+	push("neuron", "neuron", nil)
+	if n := q.Flush(); n != 1 || q.Queued("bark") != 1 || q.Queued("neuron") != 0 {
+		t.Fatalf("sent %d %v, %d of bark, %d of neuron left", n, sent, q.Queued("bark"), q.Queued("neuron"))
+	}
+}
+
+func TestAlertQueueFlushOnce(t *testing.T) {
+	var (
+		q       = NewAlertQueue(2)
+		sending = make(chan struct{})
+		resume  = make(chan struct{})
+		sent    []string
+	)
+	q.Push("neuron", "a", func() error {
+		close(sending)
+		<-resume
+		sent = append(sent, "a")
+		return nil
+	})
+	q.Push("neuron", "b", func() error {
+		sent = append(sent, "b")
+		return nil
+	})
+
+	done := make(chan int)
+	go func() { done <- q.Flush() }()
+	<-sending
+	// Another flush while sending a
+	if n := q.Flush(); n != 0 {
+		t.Errorf("expected no concurrent flush, sent %d", n)
+	}
+	// The full queue drops a while it is being sent, b is not removed in place of it
+	q.Push("neuron", "c", func() error {
+		sent = append(sent, "c")
+		return nil
+	})
+	close(resume)
+	if n := <-done; n != 3 || q.Len() != 0 || fmt.Sprint(sent) != "[a b c]" {
+		t.Errorf("sent %d %v, %d left", n, sent, q.Len())
+	}
+}
+
+func TestNeuronAlertQueue(t *testing.T) {
+	var (
+		calls  int
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+	)
+	defer server.Close()
+	defer func(c *NeuronClient, q *AlertQueue) { TheNeuronClient, TheAlertQueue = c, q }(TheNeuronClient, TheAlertQueue)
+	TheNeuronClient, TheAlertQueue = NewNeuronClient(server.URL, "test"), NewAlertQueue(10)
+	TheAlertQueue.startOnce.Do(func() {})
+
+	// The 5xx is queued rather than dropped
+	if err := SendAlertV2("title", "content"); err != nil || TheAlertQueue.Queued(server.URL) != 1 {
+		t.Fatalf("expected the alert queued, err: %v, %d queued", err, TheAlertQueue.Queued(server.URL))
+	}
+	if n := TheAlertQueue.Flush(); n != 1 || calls != 2 {
+		t.Errorf("expected the alert sent again, sent %d, %d calls", n, calls)
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"bytes"
 	"github.com/golang/glog"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -10,37 +11,68 @@ import (
 
 // SendRequest ...
 func SendRequest(method string, uri string, body *bytes.Buffer) (int, string, error) {
+	return SendRequestWithHeaders(method, uri, body, nil)
+}
+
+// SendRequestWithHeaders sends the request with the extra headers, which replace the default ones
+func SendRequestWithHeaders(method string, uri string, body *bytes.Buffer, headers map[string]string) (int, string, error) {
+	code, responseBody, _, err := SendRequestForHeader(method, uri, body, headers)
+	return code, responseBody, err
+}
+
+// SendRequestForHeader is SendRequestWithHeaders returning the headers of the response too, e.g. ETag
+func SendRequestForHeader(method string, uri string, body *bytes.Buffer, headers map[string]string) (int, string, http.Header, error) {
 	var (
 		responseBody string
 	)
 
+	breaker := GetCircuitBreaker(EndpointOf(uri))
+	if err := breaker.Allow(); err != nil {
+		glog.V(4).Infof("SKIP REQUEST %s %s: %v\n", method, uri, err)
+		return http.StatusServiceUnavailable, "", nil, err
+	}
+
 	client := &http.Client{}
 	client.Timeout = time.Minute * 3
 
 	glog.V(6).Info(method)
 	glog.V(6).Info(uri)
-	glog.V(6).Info(body.String())
 
-	if body == nil {
-		body = bytes.NewBuffer([]byte("{}"))
+	// No body at all rather than a nil *bytes.Buffer, e.g. of the GETs
+	var reqBody io.Reader
+	if body != nil {
+		glog.V(6).Info(body.String())
+		reqBody = body
 	}
-	req, err := http.NewRequest(method, uri, body)
+	req, err := http.NewRequest(method, uri, reqBody)
 	if err != nil {
-		glog.Fatalf("http.NewRequest() failed with '%s'\n", err)
+		glog.Errorf("http.NewRequest() failed with '%s'\n", err)
+		return http.StatusBadRequest, "", nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	req.SetBasicAuth("elastic", "elastic")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		glog.Warningf("client.Do() failed with '%s'\n", err)
-		return http.StatusBadRequest, "", err
+		breaker.Failure()
+		return http.StatusBadRequest, "", nil, err
 	}
 	glog.V(6).Info(resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		breaker.Failure()
+	} else {
+		breaker.Success()
+	}
 	glog.V(6).Infof("RESP: %+v", resp)
 
 	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	responseBody = string(bodyBytes)
 
-	return resp.StatusCode, responseBody, nil
+	return resp.StatusCode, responseBody, resp.Header, nil
 }
@@ -1,49 +1,75 @@
 package utils
 
+import (
+	"cmp"
+	"strings"
+)
+
 type ToMergeObject interface {
 	ID() int64
 }
 
-// Descend arrays
-func MergeDescendObjects(sourceObjects, newObjects []ToMergeObject) (lastObjects []ToMergeObject) {
+// Descend arrays, merged by ID()
+func MergeDescendObjects[T ToMergeObject](sourceObjects, newObjects []T) []T {
+	return MergeDescendFunc(sourceObjects, newObjects, T.ID, cmp.Compare[int64])
+}
+
+// MergeDescendOrdered merges descend arrays by an ordered key
+func MergeDescendOrdered[T any, K cmp.Ordered](sourceObjects, newObjects []T, key func(T) K) []T {
+	return MergeDescendFunc(sourceObjects, newObjects, key, cmp.Compare[K])
+}
+
+// MergeDescendFunc merges two arrays sorted descend by compare on key.
+// The objects with the same key are merged into one, the new object wins.
+func MergeDescendFunc[T any, K comparable](sourceObjects, newObjects []T, key func(T) K, compare func(a, b K) int) (lastObjects []T) {
 	var (
 		newAnchor, sourceAnchor = 0, 0
 		newLength, sourceLength = len(newObjects), len(sourceObjects)
 	)
 
-	if newLength == 0 {
+	if newLength == 0 && sourceLength == 0 {
 		return sourceObjects
 	}
 
-	if sourceLength == 0 {
-		return newObjects
-	}
-
-	for {
-		if newAnchor < newLength && sourceAnchor < sourceLength {
-			if sourceObjects[sourceAnchor].ID() < newObjects[newAnchor].ID() {
-				lastObjects = append(lastObjects, newObjects[newAnchor])
-				newAnchor++
-			} else if sourceObjects[sourceAnchor].ID() == newObjects[newAnchor].ID() {
-				lastObjects = append(lastObjects, newObjects[newAnchor])
-				newAnchor++
-				sourceAnchor++
-			} else {
-				lastObjects = append(lastObjects, sourceObjects[sourceAnchor])
-				sourceAnchor++
-			}
+	lastObjects = make([]T, 0, newLength+sourceLength)
+	appendObject := func(object T) {
+		// The arrays may have duplicated keys themselves
+		if len(lastObjects) > 0 && compare(key(lastObjects[len(lastObjects)-1]), key(object)) == 0 {
+			return
 		}
+		lastObjects = append(lastObjects, object)
+	}
 
-		if newAnchor == newLength {
-			lastObjects = append(lastObjects, sourceObjects[sourceAnchor:]...)
-			break
+	for newAnchor < newLength && sourceAnchor < sourceLength {
+		switch c := compare(key(sourceObjects[sourceAnchor]), key(newObjects[newAnchor])); {
+		case c < 0:
+			appendObject(newObjects[newAnchor])
+			newAnchor++
+		case c == 0:
+			appendObject(newObjects[newAnchor])
+			newAnchor++
+			sourceAnchor++
+		default:
+			appendObject(sourceObjects[sourceAnchor])
+			sourceAnchor++
 		}
+	}
 
-		if sourceAnchor == sourceLength {
-			lastObjects = append(lastObjects, newObjects[newAnchor:]...)
-			break
-		}
+	for ; newAnchor < newLength; newAnchor++ {
+		appendObject(newObjects[newAnchor])
+	}
+	for ; sourceAnchor < sourceLength; sourceAnchor++ {
+		appendObject(sourceObjects[sourceAnchor])
 	}
 
 	return
 }
+
+// CompareNumericStrings compares the string IDs by their numeric value, so "999" < "1000"
+func CompareNumericStrings(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return cmp.Compare(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
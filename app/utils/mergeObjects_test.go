@@ -1,8 +1,12 @@
 package utils
 
 import (
+	"cmp"
 	"fmt"
+	"sort"
+	"strconv"
 	"testing"
+	"testing/quick"
 )
 
 type Student struct {
@@ -74,3 +78,109 @@ func TestArray(t *testing.T) {
 	la = len(sl)
 	fmt.Println(sl[:la-lb])
 }
+
+// descendIDs sorts the random IDs descend, duplicates are kept
+func descendIDs(ids []uint16) []int64 {
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, int64(id))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] > result[j] })
+	return result
+}
+
+func checkMerged[K comparable](t *testing.T, merged, source, new []K, compare func(a, b K) int) bool {
+	var (
+		seen = make(map[K]bool)
+	)
+
+	for idx := range merged {
+		// Sorted and deduplicated
+		if idx > 0 && compare(merged[idx-1], merged[idx]) <= 0 {
+			t.Logf("NOT STRICTLY DESCEND AT %d: %v", idx, merged)
+			return false
+		}
+		seen[merged[idx]] = true
+	}
+
+	// Superset of both inputs
+	for _, id := range append(append([]K{}, source...), new...) {
+		if !seen[id] {
+			t.Logf("MISSING %v IN %v", id, merged)
+			return false
+		}
+	}
+	return true
+}
+
+func TestMergeDescendObjectsProperty(t *testing.T) {
+	property := func(s1, s2 []uint16) bool {
+		var (
+			source, new = descendIDs(s1), descendIDs(s2)
+			sObjects    []Student
+			nObjects    []Student
+			mergedIDs   []int64
+		)
+		for _, id := range source {
+			sObjects = append(sObjects, Student{id: id})
+		}
+		for _, id := range new {
+			nObjects = append(nObjects, Student{id: id})
+		}
+
+		for _, s := range MergeDescendObjects(sObjects, nObjects) {
+			mergedIDs = append(mergedIDs, s.ID())
+		}
+		return checkMerged(t, mergedIDs, source, new, cmp.Compare[int64])
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeDescendFuncNumericStringsProperty(t *testing.T) {
+	toStrings := func(ids []uint16) (result []string) {
+		for _, id := range descendIDs(ids) {
+			result = append(result, strconv.FormatInt(id, 10))
+		}
+		return
+	}
+
+	property := func(s1, s2 []uint16) bool {
+		var (
+			source, new = toStrings(s1), toStrings(s2)
+			key         = func(s string) string { return s }
+		)
+		return checkMerged(t, MergeDescendFunc(source, new, key, CompareNumericStrings), source, new, CompareNumericStrings)
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeDescendFuncNewWins(t *testing.T) {
+	type msg struct {
+		id   string
+		text string
+	}
+	var (
+		source = []msg{{"1000", "old"}, {"999", "old"}}
+		new    = []msg{{"1000", "new"}, {"1000", "new again"}}
+		merged = MergeDescendFunc(source, new, func(m msg) string { return m.id }, CompareNumericStrings)
+	)
+
+	if len(merged) != 2 || merged[0].text != "new" || merged[1].id != "999" {
+		t.Errorf("unexpected merge result: %+v", merged)
+	}
+}
+
+func TestCompareNumericStrings(t *testing.T) {
+	if CompareNumericStrings("999", "1000") >= 0 {
+		t.Errorf("999 should be less than 1000")
+	}
+	if CompareNumericStrings("0042", "42") != 0 {
+		t.Errorf("leading zeros should be ignored")
+	}
+}
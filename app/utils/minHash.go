@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+const (
+	MinHashSize = 64
+)
+
+var (
+	minHashSeeds = newMinHashSeeds(MinHashSize)
+)
+
+// splitMix64 is the finalizer of SplitMix64, used to derive the hash functions from one hash
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func newMinHashSeeds(n int) []uint64 {
+	seeds := make([]uint64, n)
+	for i := range seeds {
+		seeds[i] = splitMix64(uint64(i))
+	}
+	return seeds
+}
+
+// Shingles are the bigrams of the letters and the digits in lower case, the punctuations and the spaces are ignored
+func Shingles(text string) (shingles []string) {
+	var (
+		runes []rune
+		seen  = make(map[string]bool)
+	)
+	for _, r := range strings.ToLower(text) {
+		if isWordRune(r) {
+			runes = append(runes, r)
+		}
+	}
+	if len(runes) == 1 {
+		return []string{string(runes)}
+	}
+	for i := 0; i+1 < len(runes); i++ {
+		shingle := string(runes[i : i+2])
+		if !seen[shingle] {
+			seen[shingle] = true
+			shingles = append(shingles, shingle)
+		}
+	}
+	return
+}
+
+// MinHash is the signature of a text to estimate its Jaccard similarity with the others
+type MinHash [MinHashSize]uint64
+
+func NewMinHash(text string) (m MinHash) {
+	for i := range m {
+		m[i] = ^uint64(0)
+	}
+	for _, shingle := range Shingles(text) {
+		h := fnv.New64a()
+		h.Write([]byte(shingle))
+		sum := h.Sum64()
+		for i, seed := range minHashSeeds {
+			if v := splitMix64(sum ^ seed); v < m[i] {
+				m[i] = v
+			}
+		}
+	}
+	return
+}
+
+// Empty tells if the text has no letters or digits
+func (m MinHash) Empty() bool {
+	return m[0] == ^uint64(0)
+}
+
+// Similarity estimates the Jaccard similarity of the shingles, 0 to 1
+func (m MinHash) Similarity(other MinHash) float64 {
+	if m.Empty() || other.Empty() {
+		return 0
+	}
+	same := 0
+	for i := range m {
+		if m[i] == other[i] {
+			same++
+		}
+	}
+	return float64(same) / MinHashSize
+}
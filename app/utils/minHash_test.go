@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestMinHash(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		min, max float64
+	}{
+		{"美联储宣布维持利率不变，符合市场预期", "美联储宣布维持利率不变，符合市场预期", 1, 1},
+		{"美联储宣布维持利率不变，符合市场预期", "【美联储维持利率不变 符合市场预期】", 0.6, 0.95},
+		{"腾讯控股：拟回购不超过10亿港元股份", "阿里巴巴：拟回购不超过100亿美元股份", 0, 0.5},
+		{"Tesla beats estimates", "", 0, 0},
+	} {
+		if s := NewMinHash(c.a).Similarity(NewMinHash(c.b)); s < c.min || s > c.max {
+			t.Errorf("%s ~ %s: %.2f, expect %.2f to %.2f", c.a, c.b, s, c.min, c.max)
+		}
+	}
+}
+
+func TestShingles(t *testing.T) {
+	if shingles := Shingles("A-b, AB!"); len(shingles) != 2 || shingles[0] != "ab" || shingles[1] != "ba" {
+		t.Errorf("shingles %v", shingles)
+	}
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Window keeps the latest objects sorted descend by key in a ring buffer, bounded by count and age,
+// with an index by key. Merging the objects newer than the head only costs O(new objects).
+type Window[K comparable, T any] struct {
+	key      func(T) K
+	compare  func(a, b K) int
+	timeOf   func(T) time.Time
+	maxCount int
+	maxAge   time.Duration
+	now      func() time.Time
+
+	// items[head] is the newest
+	items []T
+	head  int
+	size  int
+	index map[K]T
+}
+
+func NewWindow[K comparable, T any](maxCount int, key func(T) K, compare func(a, b K) int) *Window[K, T] {
+	if maxCount <= 0 {
+		maxCount = 1
+	}
+	return &Window[K, T]{
+		key:      key,
+		compare:  compare,
+		maxCount: maxCount,
+		now:      time.Now,
+		items:    make([]T, maxCount),
+		index:    make(map[K]T, maxCount),
+	}
+}
+
+// MaxAge drops the objects older than maxAge on every merge, timeOf returns the time of the object
+func (w *Window[K, T]) MaxAge(maxAge time.Duration, timeOf func(T) time.Time) *Window[K, T] {
+	w.maxAge = maxAge
+	w.timeOf = timeOf
+	return w
+}
+
+func (w *Window[K, T]) Len() int {
+	return w.size
+}
+
+func (w *Window[K, T]) Get(key K) (object T, hit bool) {
+	object, hit = w.index[key]
+	return
+}
+
+// Items returns a copy of the objects, descend
+func (w *Window[K, T]) Items() []T {
+	result := make([]T, w.size)
+	for i := 0; i < w.size; i++ {
+		result[i] = w.at(i)
+	}
+	return result
+}
+
+// Merge merges the descend objects into the window, the new object wins on the same key.
+// fresh are the objects not in the window before, descend, but the expired ones.
+func (w *Window[K, T]) Merge(newObjects []T) (fresh []T) {
+	// From the oldest to the newest, so the new head is pushed one by one
+	for i := len(newObjects) - 1; i >= 0; i-- {
+		var (
+			object = newObjects[i]
+			key    = w.key(object)
+		)
+		if w.expired(object) {
+			// Or it is fresh again on every merge
+			continue
+		}
+
+		if _, hit := w.index[key]; hit {
+			w.set(w.search(key), object)
+			w.index[key] = object
+			continue
+		}
+
+		if w.size == 0 || w.compare(key, w.key(w.at(0))) > 0 {
+			if w.size == w.maxCount {
+				w.popBack()
+			}
+			w.pushFront(object)
+		} else {
+			position := w.search(key)
+			if position == w.size && w.size == w.maxCount {
+				// Older than all of the full window, it is in the store only
+				continue
+			}
+			if w.size == w.maxCount {
+				w.popBack()
+			}
+			w.insert(position, object)
+		}
+		w.index[key] = object
+		fresh = append(fresh, object)
+	}
+
+	w.expire()
+
+	// Descend as the input
+	for i, j := 0, len(fresh)-1; i < j; i, j = i+1, j-1 {
+		fresh[i], fresh[j] = fresh[j], fresh[i]
+	}
+	return
+}
+
+func (w *Window[K, T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.Items())
+}
+
+// UnmarshalJSON merges the saved objects into the window, they must be descend
+func (w *Window[K, T]) UnmarshalJSON(data []byte) error {
+	var objects []T
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return err
+	}
+	w.Merge(objects)
+	return nil
+}
+
+// expired tells if the object is older than maxAge, the ones without a time never expire
+func (w *Window[K, T]) expired(object T) bool {
+	if w.maxAge <= 0 || w.timeOf == nil {
+		return false
+	}
+	t := w.timeOf(object)
+	return !t.IsZero() && t.Before(w.now().Add(-w.maxAge))
+}
+
+func (w *Window[K, T]) expire() {
+	for w.size > 0 && w.expired(w.at(w.size-1)) {
+		w.popBack()
+	}
+}
+
+// search returns the position of the first object not greater than key
+func (w *Window[K, T]) search(key K) int {
+	low, high := 0, w.size
+	for low < high {
+		middle := (low + high) / 2
+		if w.compare(w.key(w.at(middle)), key) > 0 {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low
+}
+
+func (w *Window[K, T]) physical(i int) int {
+	return (w.head + i) % w.maxCount
+}
+
+func (w *Window[K, T]) at(i int) T {
+	return w.items[w.physical(i)]
+}
+
+func (w *Window[K, T]) set(i int, object T) {
+	w.items[w.physical(i)] = object
+}
+
+func (w *Window[K, T]) pushFront(object T) {
+	w.head = (w.head - 1 + w.maxCount) % w.maxCount
+	w.items[w.head] = object
+	w.size++
+}
+
+func (w *Window[K, T]) popBack() {
+	var (
+		zero     T
+		position = w.physical(w.size - 1)
+	)
+	delete(w.index, w.key(w.items[position]))
+	w.items[position] = zero
+	w.size--
+}
+
+// insert shifts the older objects, only for the objects arriving out of order
+func (w *Window[K, T]) insert(i int, object T) {
+	for j := w.size; j > i; j-- {
+		w.set(j, w.at(j-1))
+	}
+	w.set(i, object)
+	w.size++
+}
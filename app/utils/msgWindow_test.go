@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"cmp"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type windowItem struct {
+	Idx       int64     `json:"idx"`
+	CreatedAt time.Time `json:"created_at"`
+	Text      string    `json:"text"`
+}
+
+func newTestWindow(maxCount int) *Window[int64, *windowItem] {
+	return NewWindow(maxCount, func(i *windowItem) int64 { return i.Idx }, cmp.Compare[int64])
+}
+
+func windowIDs(w *Window[int64, *windowItem]) (ids []int64) {
+	for _, item := range w.Items() {
+		ids = append(ids, item.Idx)
+	}
+	return
+}
+
+func items(ids ...int64) (result []*windowItem) {
+	for _, id := range ids {
+		result = append(result, &windowItem{Idx: id})
+	}
+	return
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWindowMerge(t *testing.T) {
+	w := newTestWindow(5)
+
+	fresh := w.Merge(items(10, 8, 6))
+	if len(fresh) != 3 || fresh[0].Idx != 10 {
+		t.Fatalf("unexpected fresh items: %v", fresh)
+	}
+
+	// New head, a duplicate and a gap filled
+	fresh = w.Merge(items(12, 10, 7))
+	if len(fresh) != 2 || fresh[0].Idx != 12 || fresh[1].Idx != 7 {
+		t.Errorf("unexpected fresh items: %+v, %+v", fresh[0], fresh[1])
+	}
+	if ids := windowIDs(w); !equalIDs(ids, []int64{12, 10, 8, 7, 6}) {
+		t.Errorf("unexpected window: %v", ids)
+	}
+
+	// Bounded, the oldest ones are dropped
+	w.Merge(items(14, 13))
+	if ids := windowIDs(w); !equalIDs(ids, []int64{14, 13, 12, 10, 8}) {
+		t.Errorf("unexpected window: %v", ids)
+	}
+	if _, hit := w.Get(6); hit {
+		t.Errorf("dropped item is still indexed")
+	}
+
+	// Older than the full window
+	if fresh = w.Merge(items(1)); len(fresh) != 0 || w.Len() != 5 {
+		t.Errorf("expected the old item skipped, fresh: %d, len: %d", len(fresh), w.Len())
+	}
+
+	// The new one wins
+	w.Merge([]*windowItem{{Idx: 13, Text: "updated"}})
+	if item, _ := w.Get(13); item.Text != "updated" {
+		t.Errorf("expected the item updated")
+	}
+	if items := w.Items(); items[1].Text != "updated" {
+		t.Errorf("expected the item updated in the ring")
+	}
+}
+
+func TestWindowMaxAge(t *testing.T) {
+	var (
+		now = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		w   = newTestWindow(10).MaxAge(time.Hour, func(i *windowItem) time.Time { return i.CreatedAt })
+	)
+	w.now = func() time.Time { return now }
+
+	page := []*windowItem{
+		{Idx: 3, CreatedAt: now.Add(-time.Minute)},
+		{Idx: 2, CreatedAt: now.Add(-2 * time.Hour)},
+		{Idx: 1, CreatedAt: now.Add(-3 * time.Hour)},
+	}
+	fresh := w.Merge(page)
+	if ids := windowIDs(w); !equalIDs(ids, []int64{3}) || len(fresh) != 1 {
+		t.Errorf("unexpected window: %v, fresh: %d", ids, len(fresh))
+	}
+	// The expired ones are not fresh again
+	if fresh = w.Merge(page); len(fresh) != 0 {
+		t.Errorf("unexpected fresh: %d", len(fresh))
+	}
+}
+
+func TestWindowJSON(t *testing.T) {
+	w := newTestWindow(10)
+	w.Merge(items(5, 3, 1))
+
+	data, err := json.Marshal(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestWindow(10)
+	if err = json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if ids := windowIDs(loaded); !equalIDs(ids, []int64{5, 3, 1}) {
+		t.Errorf("unexpected window: %v, json: %s", ids, data)
+	}
+}
+
+// Every round gets a page with one new item, like the collector in the steady state
+func BenchmarkWindowMerge(b *testing.B) {
+	var (
+		w    = newTestWindow(10000)
+		page = make([]*windowItem, 50)
+		next = int64(0)
+	)
+	for ; next < 10000; next++ {
+		w.Merge([]*windowItem{{Idx: next}})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page[0] = &windowItem{Idx: next}
+		for j := 1; j < len(page); j++ {
+			page[j], _ = w.Get(next - int64(j))
+		}
+		w.Merge(page)
+		next++
+	}
+}
+
+// The same rounds with the slices merged
+func BenchmarkMergeDescendObjects(b *testing.B) {
+	var (
+		msgs []*windowItem
+		page = make([]*windowItem, 50)
+		next = int64(10000)
+		key  = func(i *windowItem) int64 { return i.Idx }
+	)
+	for i := next - 1; i >= 0; i-- {
+		msgs = append(msgs, &windowItem{Idx: i})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page[0] = &windowItem{Idx: next}
+		copy(page[1:], msgs[:len(page)-1])
+		msgs = MergeDescendOrdered(msgs, page, key)
+		next++
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/skeyic/monitoring/config"
+)
+
+// Notifier sends the alerts to a channel of a user
+type Notifier interface {
+	Name() string
+	// Endpoint is the endpoint of the breaker and the queue of the alerts
+	Endpoint() string
+	Notify(title, content string) error
+}
+
+// NeuronNotifier sends the alerts to a Neuron user
+type NeuronNotifier struct {
+	name   string
+	client *NeuronClient
+}
+
+func NewNeuronNotifier(name string, client *NeuronClient) *NeuronNotifier {
+	return &NeuronNotifier{
+		name:   name,
+		client: client,
+	}
+}
+
+func (n *NeuronNotifier) Name() string {
+	return n.name
+}
+
+func (n *NeuronNotifier) Endpoint() string {
+	return n.client.Endpoint()
+}
+
+func (n *NeuronNotifier) Notify(title, content string) error {
+	return queueIfRetryable(n.Endpoint(), n.name+": "+title, func() error {
+		return n.client.Send(title, content)
+	})
+}
+
+// BarkNotifier pushes the alerts to a Bark device
+type BarkNotifier struct {
+	name   string
+	client *BarkClient
+}
+
+func NewBarkNotifier(name string, client *BarkClient) *BarkNotifier {
+	return &BarkNotifier{
+		name:   name,
+		client: client,
+	}
+}
+
+func (n *BarkNotifier) Name() string {
+	return n.name
+}
+
+func (n *BarkNotifier) Endpoint() string {
+	return n.client.Endpoint()
+}
+
+func (n *BarkNotifier) Notify(title, content string) error {
+	return queueIfRetryable(n.Endpoint(), n.name+": "+title, func() error {
+		return n.client.Send(title, content)
+	})
+}
+
+// NewNotifier returns the notifier of the config
+func NewNotifier(c config.Notifier) (Notifier, error) {
+	switch c.Type {
+	case "neuron":
+		return NewNeuronNotifier(c.Name, NewNeuronClient(c.URL, c.User)), nil
+	case "bark":
+		client := NewBarkClient(c.URL, c.User).Options(BarkOptions{
+			Group: c.Group,
+			Sound: c.Sound,
+			Level: c.Level,
+			URL:   c.Link,
+			Icon:  c.Icon,
+		})
+		return NewBarkNotifier(c.Name, client), nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %s of %s, expect neuron or bark", c.Type, c.Name)
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// Do not depend on the tzdata of the container
+	_ "time/tzdata"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// A message can not be newer than its neighbour, but the clock of the source may be a bit ahead of ours
+	SourceTimeTolerance = 5 * time.Minute
+
+	sourceTimeFormat = "2006-01-02 15:04:05"
+)
+
+var (
+	// All the sources we watch publish in China Standard Time
+	ShanghaiLocation = loadLocation("Asia/Shanghai", 8*60*60)
+
+	fullTimeLayouts = []string{
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006/01/02 15:04:05",
+		"2006/01/02 15:04",
+		"2006-01-02",
+		time.RFC3339,
+	}
+	dateTimeLayouts = []string{
+		"01-02 15:04:05",
+		"01-02 15:04",
+		"01/02 15:04:05",
+		"01/02 15:04",
+	}
+	clockTimeLayouts = []string{
+		"15:04:05",
+		"15:04",
+	}
+)
+
+func loadLocation(name string, offset int) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		glog.Errorf("failed to load location %s, use fixed offset %d, ERR: %v", name, offset, err)
+		return time.FixedZone(name, offset)
+	}
+	return loc
+}
+
+// SourceTimeParser parses the time strings of a message list sorted from the newest to the oldest.
+// The missing year or date is taken from the previous (newer) message, and rolled back by one
+// year or day if the message would be newer than its neighbour.
+type SourceTimeParser struct {
+	location  *time.Location
+	reference time.Time
+}
+
+func NewSourceTimeParser(location *time.Location, reference time.Time) *SourceTimeParser {
+	return &SourceTimeParser{
+		location:  location,
+		reference: reference.In(location),
+	}
+}
+
+// Reference is the time of the last parsed message
+func (p *SourceTimeParser) Reference() time.Time {
+	return p.reference
+}
+
+func (p *SourceTimeParser) Parse(value string) (t time.Time, err error) {
+	value = strings.TrimSpace(value)
+
+	if unix, uErr := strconv.ParseInt(value, 10, 64); uErr == nil {
+		if unix > 1e12 {
+			t = time.Unix(0, unix*int64(time.Millisecond))
+		} else {
+			t = time.Unix(unix, 0)
+		}
+		p.reference = t.In(p.location)
+		return
+	}
+
+	for _, layout := range fullTimeLayouts {
+		if t, err = time.ParseInLocation(layout, value, p.location); err == nil {
+			p.reference = t
+			return
+		}
+	}
+
+	for _, layout := range dateTimeLayouts {
+		if t, err = time.ParseInLocation(layout, value, p.location); err == nil {
+			t = t.AddDate(p.reference.Year(), 0, 0)
+			if t.After(p.reference.Add(SourceTimeTolerance)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			p.reference = t
+			return
+		}
+	}
+
+	for _, layout := range clockTimeLayouts {
+		if t, err = time.ParseInLocation(layout, value, p.location); err == nil {
+			year, month, day := p.reference.Date()
+			t = time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, p.location)
+			if t.After(p.reference.Add(SourceTimeTolerance)) {
+				t = t.AddDate(0, 0, -1)
+			}
+			p.reference = t
+			return
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unknown time format: %q", value)
+}
+
+// FormatSourceTime formats the time in the zone of the sources
+func FormatSourceTime(t time.Time) string {
+	return t.In(ShanghaiLocation).Format(sourceTimeFormat)
+}
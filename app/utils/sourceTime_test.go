@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSourceTimeParser(t *testing.T) {
+	var (
+		now    = time.Date(2021, 1, 1, 0, 2, 0, 0, ShanghaiLocation)
+		parser = NewSourceTimeParser(ShanghaiLocation, now)
+		cases  = []struct {
+			value    string
+			expected string
+		}{
+			{"00:01", "2021-01-01 00:01:00"},
+			{"00:00:30", "2021-01-01 00:00:30"},
+			// Rolled back to the previous day, and year
+			{"23:59", "2020-12-31 23:59:00"},
+			{"12-31 08:00", "2020-12-31 08:00:00"},
+			{"2020-12-30 10:00:00", "2020-12-30 10:00:00"},
+			{"18:00", "2020-12-29 18:00:00"},
+			{"1609344000", "2020-12-31 00:00:00"},
+		}
+	)
+
+	for _, c := range cases {
+		result, err := parser.Parse(c.value)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", c.value, err)
+		}
+		if FormatSourceTime(result) != c.expected {
+			t.Errorf("parse %s, expected %s, got %s", c.value, c.expected, FormatSourceTime(result))
+		}
+	}
+
+	if _, err := parser.Parse("yesterday"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestSourceTimeParserTolerance(t *testing.T) {
+	var (
+		now    = time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+		parser = NewSourceTimeParser(ShanghaiLocation, now)
+	)
+
+	// The source clock is ahead of ours, it is still the same day
+	result, _ := parser.Parse("18:02")
+	if result.UTC() != time.Date(2021, 1, 1, 10, 2, 0, 0, time.UTC) {
+		t.Errorf("unexpected time: %s", result.UTC())
+	}
+}
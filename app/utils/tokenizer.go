@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// The words we always want as a whole in the financial news
+	defaultDictionaryWords = []string{
+		"目标价", "评级", "上调", "下调", "维持", "首次覆盖", "买入", "卖出", "增持", "减持", "中性", "跑赢", "跑输",
+		"财报", "营收", "净利润", "业绩", "指引", "回购", "分红", "停牌", "复牌", "收购", "并购", "美股", "港股", "A股",
+	}
+
+	TheDictionary = NewDictionary(defaultDictionaryWords...)
+)
+
+// Dictionary is the Chinese words matched by forward maximum matching
+type Dictionary struct {
+	words   map[string]bool
+	maxRune int
+}
+
+func NewDictionary(words ...string) *Dictionary {
+	d := &Dictionary{
+		words: make(map[string]bool),
+	}
+	d.Add(words...)
+	return d
+}
+
+func (d *Dictionary) Add(words ...string) {
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		d.words[word] = true
+		if n := utf8.RuneCountInString(word); n > d.maxRune {
+			d.maxRune = n
+		}
+	}
+}
+
+// LoadFile adds the words of the file, one per line
+func (d *Dictionary) LoadFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		d.Add(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// match returns the dictionary words in the han runes, longest first
+func (d *Dictionary) match(runes []rune) (words []string) {
+	for i := 0; i < len(runes); {
+		matched := 0
+		for n := d.maxRune; n >= 2; n-- {
+			if i+n <= len(runes) && d.words[string(runes[i:i+n])] {
+				matched = n
+				break
+			}
+		}
+		if matched == 0 {
+			i++
+			continue
+		}
+		words = append(words, string(runes[i:i+matched]))
+		i += matched
+	}
+	return
+}
+
+func isHan(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Tokenize splits the text into the index terms:
+// lower case English words and numbers, Chinese characters, their bigrams and the dictionary words
+func (d *Dictionary) Tokenize(text string) (terms []string) {
+	var (
+		runes = []rune(strings.ToLower(text))
+	)
+
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case isHan(r):
+			j := i
+			for j < len(runes) && isHan(runes[j]) {
+				j++
+			}
+			han := runes[i:j]
+			for k := range han {
+				terms = append(terms, string(han[k]))
+				if k+1 < len(han) {
+					terms = append(terms, string(han[k:k+2]))
+				}
+			}
+			terms = append(terms, d.match(han)...)
+			i = j
+		case isWordRune(r):
+			j := i
+			for j < len(runes) && isWordRune(runes[j]) && !isHan(runes[j]) {
+				j++
+			}
+			terms = append(terms, string(runes[i:j]))
+			i = j
+		default:
+			i++
+		}
+	}
+	return
+}
+
+// QueryTerms are the terms a doc must have to contain the text,
+// the dictionary word or the bigrams for Chinese, and the words for English
+func (d *Dictionary) QueryTerms(text string) (terms []string) {
+	var (
+		runes = []rune(strings.ToLower(text))
+	)
+
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case isHan(r):
+			j := i
+			for j < len(runes) && isHan(runes[j]) {
+				j++
+			}
+			if j-i == 1 || d.words[string(runes[i:j])] {
+				terms = append(terms, string(runes[i:j]))
+				i = j
+				continue
+			}
+			for k := i; k+1 < j; k++ {
+				terms = append(terms, string(runes[k:k+2]))
+			}
+			i = j
+		case isWordRune(r):
+			j := i
+			for j < len(runes) && isWordRune(runes[j]) && !isHan(runes[j]) {
+				j++
+			}
+			terms = append(terms, string(runes[i:j]))
+			i = j
+		default:
+			i++
+		}
+	}
+	return
+}
+
+// NormalizeText is used to check the phrases, case and spaces insensitive
+func NormalizeText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	var (
+		d     = NewDictionary("普拉格", "目标价")
+		terms = d.Tokenize("大摩上调普拉格(PLUG.US)目标价至45美元")
+		has   = make(map[string]bool)
+	)
+	for _, term := range terms {
+		has[term] = true
+	}
+
+	for _, expected := range []string{"大", "大摩", "摩上", "普拉", "拉格", "普拉格", "目标价", "plug", "us", "45", "美元"} {
+		if !has[expected] {
+			t.Errorf("missing term %s in %v", expected, terms)
+		}
+	}
+}
+
+func TestQueryTerms(t *testing.T) {
+	d := NewDictionary("普拉格")
+
+	cases := map[string]string{
+		"普拉格":      "普拉格",
+		"拉格":       "拉格",
+		"的":        "的",
+		"大摩上调":     "大摩 摩上 上调",
+		"00700.HK": "00700 hk",
+	}
+	for query, expected := range cases {
+		if terms := strings.Join(d.QueryTerms(query), " "); terms != expected {
+			t.Errorf("query %s, expected %s, got %s", query, expected, terms)
+		}
+	}
+}
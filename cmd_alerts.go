@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skeyic/monitoring/app/service"
+	"github.com/skeyic/monitoring/app/utils"
+)
+
+func alertsSendTestCommand(args []string) (err error) {
+	var (
+		flags    = flag.NewFlagSet("alerts send-test", flag.ExitOnError)
+		notifier = flags.String("notifier", "neuron", "neuron, bark or a notifier in the config")
+		title    = flags.String("title", "Test", "title of the alert")
+	)
+	flags.Parse(args)
+
+	content := strings.Join(flags.Args(), " ")
+	if content == "" {
+		content = "it is " + utils.FormatSourceTime(time.Now())
+	}
+
+	var (
+		endpoint = utils.TheBarkClient.Endpoint()
+		send     = utils.SendAlert
+	)
+	if *notifier != "bark" {
+		n, nErr := service.TheAlertRouter.GetNotifier(*notifier)
+		if nErr != nil {
+			return nErr
+		}
+		endpoint, send = n.Endpoint(), n.Notify
+	}
+
+	breaker := utils.GetCircuitBreaker(endpoint)
+	if breaker.State() == utils.BreakerOpen {
+		return fmt.Errorf("the circuit breaker of %s is open, the alert is not sent", endpoint)
+	}
+	if err = send(*title, content); err != nil {
+		return
+	}
+	// The alerts failed to be retried are queued instead of returning the error
+	if utils.TheAlertQueue.Queued(endpoint) > 0 {
+		return fmt.Errorf("the alert is not sent but queued, the circuit breaker of %s is %s", endpoint, breaker.State())
+	}
+	fmt.Printf("ALERT SENT BY %s\n", *notifier)
+	return
+}
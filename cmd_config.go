@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/skeyic/monitoring/app/service"
+	"github.com/skeyic/monitoring/config"
+)
+
+func configValidateCommand(args []string) (err error) {
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	flags.Parse(args)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = config.Files()
+	}
+
+	c, err := config.Load(files...)
+	if err != nil {
+		return
+	}
+
+	errs := append(c.Validate(), service.ValidateRules(c)...)
+	errs = append(errs, service.ValidateSubscriptions(c)...)
+	errs = append(errs, service.ValidateTemplates(c)...)
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "INVALID: %v\n", e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors in the configuration %v", len(errs), files)
+	}
+	fmt.Printf("CONFIGURATION %v OK\n", files)
+	return
+}
+
+func versionCommand(args []string) error {
+	fmt.Printf("monitoring %s %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
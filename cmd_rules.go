@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/skeyic/monitoring/app/service"
+)
+
+func rulesTestCommand(args []string) (err error) {
+	var (
+		flags     = flag.NewFlagSet("rules test", flag.ExitOnError)
+		ruleNames = flags.String("rules", "", "comma separated rules to test, all if empty")
+		file      = flags.String("file", "", "JSON lines of msgs, as written by store export")
+		names     = service.RuleNames()
+		msgs      []*service.Msg
+	)
+	flags.Parse(args)
+
+	if *ruleNames != "" {
+		names = strings.Split(*ruleNames, ",")
+	}
+	rules, err := service.GetRules(names)
+	if err != nil {
+		return
+	}
+
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if msgs, err = service.ReadMsgs(f, ""); err != nil {
+			return err
+		}
+	}
+	if text := strings.Join(flags.Args(), " "); text != "" {
+		msgs = append(msgs, (&service.Msg{Source: "cli", ID: "0", Text: text}).Extract())
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("nothing to test, give a text or -file")
+	}
+
+	matched := 0
+	for _, msg := range msgs {
+		var hits []string
+		for idx, rule := range rules {
+			if rule.Match(msg) {
+				hits = append(hits, names[idx])
+			}
+		}
+		if len(hits) > 0 {
+			matched++
+			fmt.Printf("%s/%s MATCH %v: %s\n", msg.Source, msg.ID, hits, msg.Text)
+		}
+	}
+	fmt.Printf("%d OF %d MSGS MATCHED\n", matched, len(msgs))
+	return
+}
+
+func rulesReplayCommand(args []string) (err error) {
+	var (
+		flags     = flag.NewFlagSet("rules replay", flag.ExitOnError)
+		ruleNames = flags.String("rules", "", "comma separated rules to replay, all if empty")
+		source    = flags.String("source", "", "only the msgs of the source")
+		since     = flags.String("since", "", "only the msgs created since, e.g. 2020-12-01")
+		until     = flags.String("until", "", "only the msgs created before")
+		samples   = flags.Int("samples", service.ReplayDefaultSamples, "number of sample matches per rule")
+		names     []string
+	)
+	flags.Parse(args)
+
+	if *ruleNames != "" {
+		names = strings.Split(*ruleNames, ",")
+	}
+	rules, err := service.GetRules(names)
+	if err != nil {
+		return
+	}
+	options, err := service.NewSearchOptions(*source, *since, *until, 0)
+	if err != nil {
+		return
+	}
+
+	report, err := service.Replay(service.TheMsgStore, rules, options.Source, options.From, options.To, *samples)
+	if err != nil {
+		return
+	}
+	report.Print(os.Stdout)
+	return
+}
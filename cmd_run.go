@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/skeyic/monitoring/app/service"
+	"github.com/skeyic/monitoring/app/utils"
+	"github.com/skeyic/monitoring/config"
+)
+
+func runCommand(args []string) (err error) {
+	var (
+		flags   = flag.NewFlagSet("run", flag.ExitOnError)
+		offline = flags.Bool("offline", false, "collect from the file sources only")
+	)
+	flags.Parse(args)
+
+	if errs := service.ValidateTemplates(&config.Config); len(errs) > 0 {
+		return errs[0]
+	}
+
+	rules, err := service.AlertRules()
+	if err != nil {
+		return
+	}
+	for _, rule := range rules {
+		service.TheFutuCollector.AddFilter(rule)
+		service.TheIngester.AddFilter(rule)
+		for _, c := range service.LiveCollectors() {
+			c.AddFilter(rule)
+		}
+	}
+
+	go func() {
+		if err := service.TheSearchIndex.Build(); err != nil {
+			glog.Errorf("Build search index failed, ERR: %v\n", err)
+		}
+	}()
+
+	service.TheAlertRouter.Start()
+
+	service.TheSinks.Start()
+	if service.TheSQLStore != nil {
+		service.TheAlertRouter.ObserveMatch(service.TheSQLStore.AddMatch)
+		service.TheAlertHistory.Observe(service.TheSQLStore.AddAlert)
+		go service.TheSQLStore.Start()
+	}
+	if service.TheESSink.Enabled() {
+		service.TheAlertHistory.Observe(service.TheESSink.AddAlert)
+		go service.TheESSink.Start()
+	}
+
+	for _, c := range service.LiveCollectors() {
+		if _, ok := c.(*service.FileCollector); *offline && !ok {
+			continue
+		}
+		go c.Start()
+	}
+
+	go func() {
+		glog.Errorf("API server stopped, ERR: %v\n", service.TheAPIServer.Start())
+	}()
+
+	if *offline {
+		<-make(chan struct{}, 1)
+	}
+
+	err = service.TheFutuCollector.Start()
+	if err != nil {
+		glog.V(4).Infof("Start Futu collector failed, ERR: %v\n", err)
+		return
+	}
+
+	<-make(chan struct{}, 1)
+	return
+}
+
+// fetch futu -page 1, the source may be before the flags
+func fetchCommand(args []string) (err error) {
+	var (
+		flags  = flag.NewFlagSet("fetch", flag.ExitOnError)
+		page   = flags.Int("page", 0, "page number, starts from 0")
+		size   = flags.Int("size", 0, "page size, the default of the source if 0")
+		save   = flags.Bool("save", false, "save the msgs into the store")
+		source string
+	)
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		source, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+	if source == "" {
+		source = flags.Arg(0)
+	}
+
+	collector, err := service.GetCollector(source)
+	if err != nil {
+		return fmt.Errorf("%v, sources: %v", err, service.CollectorNames())
+	}
+	if *size == 0 {
+		*size = collector.DefaultPageSize()
+	}
+
+	msgs, err := collector.Fetch(*page, *size)
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		fmt.Printf("%s %s/%s %s\n", utils.FormatSourceTime(msg.CreatedAt), msg.Source, msg.ID, msg.Text)
+	}
+	fmt.Printf("TOTAL %d MSGS\n", len(msgs))
+
+	if *save {
+		err = service.TheMsgStore.Save(msgs)
+	}
+	return
+}
+
+func backfillCommand(args []string) (err error) {
+	var (
+		flags  = flag.NewFlagSet("backfill", flag.ExitOnError)
+		source = flags.String("source", service.FutuSourceName, "the source to backfill")
+		pages  = flags.Int("pages", 10, "number of pages")
+		size   = flags.Int("size", 0, "page size, the default of the source if 0")
+	)
+	flags.Parse(args)
+
+	collector, err := service.GetCollector(*source)
+	if err != nil {
+		return fmt.Errorf("%v, sources: %v", err, service.CollectorNames())
+	}
+	if *size == 0 {
+		*size = collector.DefaultPageSize()
+	}
+
+	total, err := service.Backfill(collector, service.TheMsgStore, *pages, *size)
+	fmt.Printf("BACKFILL %d NEW MSGS OF %s\n", total, *source)
+	return
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/skeyic/monitoring/app/service"
+	"github.com/skeyic/monitoring/app/utils"
+)
+
+// monitoring search [-source futu] [-since 2020-12-01] [-until 2020-12-02] [-limit 50] PLUG OR 普拉格
+func searchCommand(args []string) (err error) {
+	var (
+		flags  = flag.NewFlagSet("search", flag.ExitOnError)
+		source = flags.String("source", "", "only the msgs of the source")
+		since  = flags.String("since", "", "only the msgs created since, e.g. 2020-12-01 or 2020-12-01 09:30:00")
+		until  = flags.String("until", "", "only the msgs created before")
+		limit  = flags.Int("limit", service.SearchDefaultLimit, "max number of msgs")
+	)
+	flags.Parse(args)
+
+	query := strings.Join(flags.Args(), " ")
+	options, err := service.NewSearchOptions(*source, *since, *until, *limit)
+	if err != nil {
+		return
+	}
+
+	if err = service.TheSearchIndex.Build(); err != nil {
+		return
+	}
+	msgs, err := service.TheSearchIndex.Search(query, options)
+	if err != nil {
+		return
+	}
+
+	for _, msg := range msgs {
+		fmt.Printf("%s %s/%s %s\n", utils.FormatSourceTime(msg.CreatedAt), msg.Source, msg.ID, msg.Text)
+	}
+	fmt.Printf("TOTAL %d MSGS\n", len(msgs))
+	return
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/skeyic/monitoring/app/service"
+)
+
+func storeExportCommand(args []string) (err error) {
+	var (
+		flags  = flag.NewFlagSet("store export", flag.ExitOnError)
+		source = flags.String("source", "", "only the msgs of the source")
+		since  = flags.String("since", "", "only the msgs created since, e.g. 2020-12-01")
+		until  = flags.String("until", "", "only the msgs created before")
+		output = flags.String("o", "", "output file, stdout if empty")
+		out    = os.Stdout
+	)
+	flags.Parse(args)
+
+	options, err := service.NewSearchOptions(*source, *since, *until, 0)
+	if err != nil {
+		return
+	}
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return
+		}
+		defer out.Close()
+	}
+
+	total, err := service.ExportMsgs(service.TheMsgStore, out, options.Source, options.From, options.To)
+	fmt.Fprintf(os.Stderr, "EXPORTED %d MSGS\n", total)
+	return
+}
+
+func storeImportCommand(args []string) (err error) {
+	var (
+		flags  = flag.NewFlagSet("store import", flag.ExitOnError)
+		source = flags.String("source", "", "source of the msgs without one, required for the data files of the collectors")
+		dir    = flags.String("dir", "", "import the files of the file store in the dir, e.g. into the sql store")
+	)
+	flags.Parse(args)
+
+	if *dir != "" {
+		total, err := service.ImportFileStore(service.TheMsgStore, *dir)
+		if err != nil {
+			return fmt.Errorf("%s: %v", *dir, err)
+		}
+		fmt.Printf("IMPORTED %d MSGS FROM %s\n", total, *dir)
+	}
+	if flags.NArg() == 0 && *dir == "" {
+		return fmt.Errorf("no file to import")
+	}
+	for _, fileName := range flags.Args() {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		total, err := service.ImportMsgs(service.TheMsgStore, file, *source)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", fileName, err)
+		}
+		fmt.Printf("IMPORTED %d MSGS FROM %s\n", total, fileName)
+	}
+	return
+}
+
+func sqlStore() (*service.SQLStore, error) {
+	if service.TheSQLStore == nil {
+		return nil, fmt.Errorf("the sql store is not enabled, see Store.Backend")
+	}
+	return service.TheSQLStore, nil
+}
+
+// The migrations are applied when the store is opened
+func storeMigrateCommand(args []string) (err error) {
+	store, err := sqlStore()
+	if err != nil {
+		return
+	}
+	version, err := store.Version()
+	if err != nil {
+		return
+	}
+	fmt.Printf("SCHEMA VERSION %d\n", version)
+	return
+}
+
+func storeQueryCommand(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("no query")
+	}
+	store, err := sqlStore()
+	if err != nil {
+		return
+	}
+	columns, rows, err := store.Query(strings.Join(args, " "))
+	if err != nil {
+		return
+	}
+	fmt.Println(strings.Join(columns, "\t"))
+	for _, row := range rows {
+		fmt.Println(strings.Join(row, "\t"))
+	}
+	return
+}
@@ -1,10 +1,33 @@
 module github.com/skeyic/monitoring
 
-go 1.15
+go 1.22
 
 require (
+	github.com/PuerkitoBio/goquery v1.9.2
 	github.com/buger/jsonparser v1.1.1
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
+	github.com/gorilla/websocket v1.5.3
 	github.com/jinzhu/configor v1.2.1
-	github.com/reusee/mmh3 v0.0.0-20140820141314-64b85163255b // indirect
+	golang.org/x/net v0.24.0
+	golang.org/x/text v0.14.0
+	modernc.org/sqlite v1.36.0
+)
+
+require (
+	github.com/BurntSushi/toml v0.3.1 // indirect
+	github.com/andybalholm/cascadia v1.3.2 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/exp v0.0.0-20230315142452-642cacee5cc0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+	modernc.org/libc v1.61.13 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.8.2 // indirect
 )
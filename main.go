@@ -2,23 +2,89 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/golang/glog"
-	"github.com/skeyic/monitoring/app/service"
+	"os"
+	"sort"
+	"strings"
 )
 
+var (
+	// Set by -ldflags "-X main.version=..."
+	version = "dev"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var (
+	commands map[string]command
+)
+
+func init() {
+	commands = map[string]command{
+		"run":              {"run the collectors, apply the rules and serve the API (default)", runCommand},
+		"fetch":            {"fetch [-page 0] [-size N] [-save] <source>: print a page of the source", fetchCommand},
+		"backfill":         {"backfill [-source futu] [-pages 10] [-size N]: save the pages of the source into the store", backfillCommand},
+		"search":           {"search [-source] [-since] [-until] [-limit] <query>: search the stored msgs", searchCommand},
+		"rules test":       {"rules test [-rules a,b] [-file msgs.jsonl] [text]: show the rules matching the msgs", rulesTestCommand},
+		"rules replay":     {"rules replay [-rules a,b] [-source] [-since] [-until] [-samples 3]: dry run the rules over the stored msgs", rulesReplayCommand},
+		"alerts send-test": {"alerts send-test [-notifier neuron|bark] [-title] [content]: send a test alert", alertsSendTestCommand},
+		"store export":     {"store export [-source] [-since] [-until] [-o file]: write the stored msgs as JSON lines", storeExportCommand},
+		"store import":     {"store import [-source] [-dir data] <file>...: import JSON lines, the data files of the collectors or a file store", storeImportCommand},
+		"store migrate":    {"store migrate: apply the migrations of the sql store and print the version", storeMigrateCommand},
+		"store query":      {"store query <sql>: run a query on the sql store, the changes are rolled back", storeQueryCommand},
+		"config validate":  {"config validate [file]...: check the configuration", configValidateCommand},
+		"version":          {"print the version", versionCommand},
+	}
+}
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <command> [args]\n\nCommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-18s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// findCommand matches "store export" before "store"
+func findCommand(args []string) (cmd command, rest []string, hit bool) {
+	if len(args) == 0 {
+		return commands["run"], nil, true
+	}
+	if len(args) > 1 {
+		if cmd, hit = commands[args[0]+" "+args[1]]; hit {
+			return cmd, args[2:], true
+		}
+	}
+	cmd, hit = commands[args[0]]
+	return cmd, args[1:], hit
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
+	defer glog.Flush()
 
-	var (
-		err error
-	)
-
-	service.TheFutuCollector.AddFilter(service.NewRateFutuMsgFilter())
-	err = service.TheFutuCollector.Start()
-	if err != nil {
-		glog.V(4).Infof("Start Futu collector failed, ERR: %v\n", err)
-		return
+	cmd, args, hit := findCommand(flag.Args())
+	if !hit {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", strings.Join(flag.Args(), " "))
+		usage()
+		os.Exit(2)
 	}
 
-	<-make(chan struct{}, 1)
+	if err := cmd.run(args); err != nil {
+		glog.Flush()
+		fmt.Fprintf(os.Stderr, "ERR: %v\n", err)
+		os.Exit(1)
+	}
 }
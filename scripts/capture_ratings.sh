@@ -0,0 +1,18 @@
+#!/bin/bash
+# Capture the rating msgs of Futu and Sina into the golden corpus of the rating extractor,
+# then review the new lines and regenerate the golden file:
+#   go test ./app/service -run TestExtractRatingChangeGolden -update
+set -e
+cd "$(dirname "$0")/.."
+
+pages="${PAGES:-20}"
+corpus="app/service/testdata/ratings.jsonl"
+store="$(mktemp -d)"
+trap 'rm -rf "$store"' EXIT
+
+export STORE_DIR="$store"
+go run . backfill -source futu -pages "$pages"
+go run . backfill -source sina -pages "$pages"
+go run . store export | grep -iE '评级|目标价|rating|price target' >> "$corpus"
+sort -u -o "$corpus" "$corpus"
+echo "$(wc -l < "$corpus") samples in $corpus"
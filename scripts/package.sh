@@ -5,7 +5,8 @@ bin="monitoring"
 
 # GO Build
 echo "build app: $module"
-CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -ldflags "-s" -o bin/${bin} .
+version="$(git describe --tags --always --dirty 2>/dev/null || echo dev)"
+CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -ldflags "-s -X main.version=${version}" -o bin/${bin} .
 
 echo "build docker image: $module"
 